@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/edpsouza/chatterbox/internal/blobstore"
 	"github.com/edpsouza/chatterbox/internal/config"
 	"github.com/edpsouza/chatterbox/internal/handlers"
+	"github.com/edpsouza/chatterbox/internal/models"
+	"github.com/edpsouza/chatterbox/internal/retention"
 	"github.com/edpsouza/chatterbox/internal/store"
+	"github.com/edpsouza/chatterbox/internal/tlsconfig"
 	"github.com/joho/godotenv"
 )
 
@@ -21,6 +30,10 @@ func main() {
 	// Load configuration
 	cfg := config.Load()
 
+	// SIGTERM or SIGINT starts a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	// Initialize SQLite store
 	fmt.Println("DEBUG: Using database file:", cfg.Database)
 	storeInstance, err := store.NewStore(cfg.Database)
@@ -28,27 +41,154 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 		os.Exit(1)
 	}
-	defer storeInstance.Close()
+	if collisions, err := storeInstance.UsernameCollisions(); err == nil && len(collisions) > 0 {
+		log.Printf("WARNING: %d accounts have usernames that clash with another account; see GET /admin/username-collisions", len(collisions))
+	}
 	// Set global store instance for WebSocket authentication
 	handlers.SetStoreInstance(storeInstance)
+	handlers.SetMessageEditWindow(cfg.MessageEditWindow)
+	handlers.SetTokenLifetimes(cfg.AccessTokenTTL, cfg.RefreshTokenTTL)
+	handlers.SetPasswordResetTTL(cfg.PasswordResetTTL)
+	handlers.SetAdminClientCertRequired(cfg.TLSCertFile != "" && cfg.TLSClientCAFile != "")
+	models.SetArgon2Params(models.Argon2Params{
+		Time:    uint32(cfg.Argon2Time),
+		Memory:  uint32(cfg.Argon2Memory),
+		Threads: uint8(cfg.Argon2Threads),
+	})
+	handlers.SetLoginLockout(handlers.LoginLockout{
+		Account: store.LockoutPolicy{MaxFailures: cfg.LoginMaxFailures, Window: cfg.LoginFailureWindow, Base: cfg.LoginLockoutBase, Max: cfg.LoginLockoutMax},
+		IP:      store.LockoutPolicy{MaxFailures: cfg.LoginMaxFailuresPerIP, Window: cfg.LoginFailureWindow, Base: cfg.LoginLockoutBase, Max: cfg.LoginLockoutMax},
+	})
 
 	// Initialize WebSocket hub
+	if err := storeInstance.ResetPresence(); err != nil {
+		log.Printf("Failed to reset presence: %v", err)
+	}
 	hub := handlers.NewHub()
+	hub.AwayAfter = cfg.PresenceAwayAfter
+	hub.PingInterval = cfg.WSPingInterval
+	hub.PongWait = cfg.WSPongWait
+	hub.WriteWait = cfg.WSWriteWait
+	hub.MaxMessageSize = cfg.WSMaxMessageSize
+	if !handlers.IsSlowConsumerPolicy(cfg.WSSlowConsumer) {
+		log.Fatalf("Unknown WS_SLOW_CONSUMER policy %q (use drop, disconnect or block)", cfg.WSSlowConsumer)
+	}
+	hub.SlowConsumer = cfg.WSSlowConsumer
+	hub.SlowConsumerTimeout = cfg.WSSlowConsumerTimeout
+	if cfg.WSPongWait > 0 && (cfg.WSPingInterval <= 0 || cfg.WSPingInterval >= cfg.WSPongWait) {
+		log.Printf("WARNING: WS_PING_INTERVAL should be shorter than WS_PONG_WAIT, or idle connections will be dropped")
+	}
 	go hub.Run()
 
+	// Initialize attachment blob storage
+	blobs, err := blobstore.NewFS(cfg.AttachmentDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize attachment storage: %v", err)
+	}
+
+	// Start the message retention and attachment GC job
+	retentionDone := make(chan struct{})
+	go func() {
+		defer close(retentionDone)
+		retention.NewJob(storeInstance, blobs, cfg).Run(ctx)
+	}()
+
 	// Set up HTTP routes
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		handlers.ServeWS(hub, w, r)
+	router := handlers.NewRouter(handlers.RouterConfig{
+		Store:         storeInstance,
+		Hub:           hub,
+		Blobs:         blobs,
+		AdminToken:    cfg.AdminToken,
+		SearchLimiter: handlers.NewRateLimiter(cfg.SearchRateLimit, time.Minute),
+		Attachments: handlers.AttachmentConfig{
+			MaxSize:  cfg.AttachmentMaxSize,
+			Quota:    cfg.AttachmentQuota,
+			TokenTTL: cfg.AttachmentTokenTTL,
+		},
 	})
-	http.HandleFunc("/register", handlers.RegisterHandler(storeInstance))
-	http.HandleFunc("/login", handlers.LoginHandler(storeInstance))
-	http.HandleFunc("/users/", handlers.UserHandler(storeInstance))
-	http.HandleFunc("/messages/", handlers.MessageHistoryHandler(storeInstance))
 
-	log.Printf("Starting server on port %s...", cfg.Port)
-	err = http.ListenAndServe(":"+cfg.Port, nil)
-	if err != nil {
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: router}
+	serve := server.ListenAndServe
+	if cfg.TLSCertFile != "" {
+		if serve, err = setupTLS(ctx, server, cfg); err != nil {
+			log.Fatalf("Failed to set up TLS: %v", err)
+		}
+		log.Printf("Starting TLS server on port %s...", cfg.Port)
+	} else {
+		log.Printf("Starting server on port %s...", cfg.Port)
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- serve() }()
+	select {
+	case err := <-serveErr:
 		log.Fatalf("Server failed: %v", err)
-		os.Exit(1)
+	case <-ctx.Done():
 	}
+	// A second signal stops the process right away
+	stop()
+
+	// Stop accepting connections, finish in-flight requests, then drain the
+	// WebSocket clients and close the store, all within the deadline
+	log.Printf("Shutting down, draining connections for up to %s...", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP shutdown incomplete: %v", err)
+	}
+	if err := hub.Shutdown(shutdownCtx, cfg.ShutdownRetryAfter); err != nil {
+		log.Printf("WebSocket shutdown incomplete: %v", err)
+	}
+	select {
+	case <-retentionDone:
+	case <-shutdownCtx.Done():
+		log.Printf("Retention job still running at shutdown")
+	}
+	if err := storeInstance.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	}
+	log.Println("Server stopped")
+}
+
+// setupTLS prepares server for HTTPS and HTTP/2, reloading the certificate
+// on SIGHUP or when its files change, and starts the optional HTTP-to-HTTPS
+// redirect listener. It returns the function that serves until shutdown.
+func setupTLS(ctx context.Context, server *http.Server, cfg config.Config) (func() error, error) {
+	minVersion, err := tlsconfig.ParseVersion(cfg.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	reloader, err := tlsconfig.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	server.TLSConfig = reloader.Config(minVersion)
+
+	go reloader.Watch(ctx, cfg.TLSReloadInterval)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloader.Reload(); err != nil {
+				log.Printf("TLS reload failed, keeping the current certificate: %v", err)
+				continue
+			}
+			log.Printf("TLS certificate reloaded")
+		}
+	}()
+
+	if cfg.HTTPRedirectPort != "" {
+		redirect := &http.Server{Addr: ":" + cfg.HTTPRedirectPort, Handler: tlsconfig.RedirectHandler(cfg.Port)}
+		go func() {
+			log.Printf("Redirecting HTTP on port %s to HTTPS", cfg.HTTPRedirectPort)
+			if err := redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("HTTP redirect listener failed: %v", err)
+			}
+		}()
+		go func() {
+			<-ctx.Done()
+			redirect.Close()
+		}()
+	}
+
+	return func() error { return server.ListenAndServeTLS("", "") }, nil
 }
@@ -1,6 +1,6 @@
 module github.com/edpsouza/chatterbox
 
-go 1.21
+go 1.22
 
 require (
 	github.com/golang-jwt/jwt/v5 v5.0.0
@@ -8,6 +8,7 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/mattn/go-sqlite3 v0.0.0-00010101000000-000000000000
 	golang.org/x/crypto v0.17.0
+	golang.org/x/text v0.14.0
 )
 
 require golang.org/x/sys v0.15.0 // indirect
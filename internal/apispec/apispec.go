@@ -0,0 +1,14 @@
+package apispec
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI 3 description of the REST API. Keep it in step
+// with the routes in handlers.NewRouter; the contract tests check both ways.
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+// AsyncAPI describes the frames exchanged over the /ws WebSocket.
+//
+//go:embed asyncapi.json
+var AsyncAPI []byte
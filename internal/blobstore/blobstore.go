@@ -0,0 +1,230 @@
+package blobstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// Errors returned by BlobStore implementations.
+var (
+	ErrNotFound       = errors.New("blob not found")
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	ErrInvalidKey     = errors.New("invalid blob key")
+)
+
+// BlobStore stores opaque, client-encrypted blobs addressed by the SHA-256 of
+// their contents. Uploads are staged under an upload ID and can be resumed by
+// appending at the current offset until they are committed.
+type BlobStore interface {
+	// Append writes r to the staged upload id, which must currently hold offset bytes.
+	// It returns the new size of the staged upload.
+	Append(id string, offset int64, r io.Reader) (int64, error)
+	// Offset returns how many bytes have been staged for id.
+	Offset(id string) (int64, error)
+	// Commit moves a staged upload into content-addressed storage and returns its digest.
+	Commit(id string) (digest string, err error)
+	// Abort discards a staged upload.
+	Abort(id string) error
+
+	// Open returns a reader for the blob with the given digest.
+	Open(digest string) (io.ReadCloser, error)
+	// Delete removes the blob with the given digest.
+	Delete(digest string) error
+}
+
+// FS is a BlobStore backed by the local filesystem.
+//
+// Layout:
+//
+//	<root>/uploads/<id>          staged uploads
+//	<root>/blobs/<ab>/<abcdef…>  committed blobs, sharded by digest prefix
+type FS struct {
+	root string
+
+	// uploads holds a lock per staged upload in use, so concurrent appends
+	// to the same upload take turns and see each other's writes.
+	mu      sync.Mutex
+	uploads map[string]*uploadLock
+}
+
+// uploadLock serializes operations on one staged upload; users counts the
+// operations holding or waiting for it.
+type uploadLock struct {
+	mu    sync.Mutex
+	users int
+}
+
+// NewFS creates the directory layout under root and returns an FS store.
+func NewFS(root string) (*FS, error) {
+	for _, dir := range []string{"uploads", "blobs"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	return &FS{root: root, uploads: make(map[string]*uploadLock)}, nil
+}
+
+// lockUpload waits for other operations on the staged upload id to finish
+// and returns the function that lets the next one in.
+func (f *FS) lockUpload(id string) func() {
+	f.mu.Lock()
+	l := f.uploads[id]
+	if l == nil {
+		l = &uploadLock{}
+		f.uploads[id] = l
+	}
+	l.users++
+	f.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		f.mu.Lock()
+		if l.users--; l.users == 0 {
+			delete(f.uploads, id)
+		}
+		f.mu.Unlock()
+	}
+}
+
+var (
+	uploadIDPattern = regexp.MustCompile(`^[0-9a-f]{16,64}$`)
+	digestPattern   = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+func (f *FS) uploadPath(id string) (string, error) {
+	if !uploadIDPattern.MatchString(id) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(f.root, "uploads", id), nil
+}
+
+func (f *FS) blobPath(digest string) (string, error) {
+	if !digestPattern.MatchString(digest) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(f.root, "blobs", digest[:2], digest), nil
+}
+
+// Append implements BlobStore.
+func (f *FS) Append(id string, offset int64, r io.Reader) (int64, error) {
+	path, err := f.uploadPath(id)
+	if err != nil {
+		return 0, err
+	}
+	defer f.lockUpload(id)()
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() != offset {
+		return info.Size(), ErrOffsetMismatch
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	n, err := io.Copy(file, r)
+	return offset + n, err
+}
+
+// Offset implements BlobStore.
+func (f *FS) Offset(id string) (int64, error) {
+	path, err := f.uploadPath(id)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Commit implements BlobStore. Identical contents share a single blob.
+func (f *FS) Commit(id string) (string, error) {
+	path, err := f.uploadPath(id)
+	if err != nil {
+		return "", err
+	}
+	defer f.lockUpload(id)()
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	file.Close()
+	if err != nil {
+		return "", err
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+
+	dest, _ := f.blobPath(digest)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o700); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(dest); err == nil {
+		// Already stored; drop the duplicate upload.
+		return digest, os.Remove(path)
+	}
+	if err := os.Rename(path, dest); err != nil {
+		return "", fmt.Errorf("commit upload %s: %w", id, err)
+	}
+	return digest, nil
+}
+
+// Abort implements BlobStore.
+func (f *FS) Abort(id string) error {
+	path, err := f.uploadPath(id)
+	if err != nil {
+		return err
+	}
+	defer f.lockUpload(id)()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Open implements BlobStore.
+func (f *FS) Open(digest string) (io.ReadCloser, error) {
+	path, err := f.blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Delete implements BlobStore.
+func (f *FS) Delete(digest string) error {
+	path, err := f.blobPath(digest)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
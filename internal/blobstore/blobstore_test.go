@@ -0,0 +1,101 @@
+package blobstore
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFS_ResumableUploadAndDedup(t *testing.T) {
+	fs, err := NewFS(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	const id = "0123456789abcdef0123456789abcdef"
+
+	n, err := fs.Append(id, 0, strings.NewReader("hello "))
+	if err != nil || n != 6 {
+		t.Fatalf("first chunk: n=%d err=%v", n, err)
+	}
+	if _, err := fs.Append(id, 0, strings.NewReader("again")); err != ErrOffsetMismatch {
+		t.Errorf("expected ErrOffsetMismatch, got %v", err)
+	}
+	if off, _ := fs.Offset(id); off != 6 {
+		t.Errorf("expected offset 6, got %d", off)
+	}
+	if _, err := fs.Append(id, 6, strings.NewReader("world")); err != nil {
+		t.Fatalf("second chunk: %v", err)
+	}
+
+	digest, err := fs.Commit(id)
+	if err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	blob, err := fs.Open(digest)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	data, _ := io.ReadAll(blob)
+	blob.Close()
+	if string(data) != "hello world" {
+		t.Errorf("unexpected blob contents %q", data)
+	}
+
+	// Uploading identical bytes yields the same content address
+	const other = "fedcba9876543210fedcba9876543210"
+	fs.Append(other, 0, strings.NewReader("hello world"))
+	again, err := fs.Commit(other)
+	if err != nil || again != digest {
+		t.Errorf("expected digest %s, got %s (err=%v)", digest, again, err)
+	}
+
+	if err := fs.Delete(digest); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err := fs.Open(digest); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+	if _, err := fs.Open("../../etc/passwd"); err != ErrInvalidKey {
+		t.Errorf("expected ErrInvalidKey for path traversal, got %v", err)
+	}
+}
+
+func TestFS_ConcurrentAppendsAtSameOffset(t *testing.T) {
+	fs, err := NewFS(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	const id = "0123456789abcdef0123456789abcdef"
+
+	// Only one of several chunks sent for the same offset is written
+	const senders = 8
+	errs := make(chan error, senders)
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := fs.Append(id, 0, strings.NewReader(strings.Repeat(string(rune('a'+i)), 4096)))
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	written := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			written++
+		case ErrOffsetMismatch:
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if written != 1 {
+		t.Errorf("expected exactly one chunk written, got %d", written)
+	}
+	if off, _ := fs.Offset(id); off != 4096 {
+		t.Errorf("expected offset 4096, got %d", off)
+	}
+}
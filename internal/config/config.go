@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -10,6 +11,95 @@ type Config struct {
 	Database  string
 	JWTSecret string
 	Debug     bool
+
+	// MessageEditWindow bounds how long after sending a message can be
+	// edited or deleted for everyone. Zero means no limit.
+	MessageEditWindow time.Duration
+
+	// PresenceAwayAfter is how long a connection may go without activity or
+	// heartbeats before its user is shown as away.
+	PresenceAwayAfter time.Duration
+
+	// WebSocket keepalive and limits: ping every WSPingInterval, drop
+	// connections not heard from within WSPongWait, fail writes taking longer
+	// than WSWriteWait, and close connections sending frames larger than
+	// WSMaxMessageSize bytes. Zero disables each.
+	WSPingInterval   time.Duration
+	WSPongWait       time.Duration
+	WSWriteWait      time.Duration
+	WSMaxMessageSize int64
+
+	// WSSlowConsumer is what happens when a connection's send queue is full:
+	// "drop" spills the frame to the offline queue, "disconnect" also closes
+	// the connection, and "block" waits up to WSSlowConsumerTimeout for room
+	// before dropping.
+	WSSlowConsumer        string
+	WSSlowConsumerTimeout time.Duration
+
+	// Retention settings for the scheduled purge job. A zero value disables
+	// the corresponding rule; a zero RetentionInterval disables the job.
+	RetentionInterval           time.Duration
+	RetentionMaxAgeDays         int
+	RetentionMaxPerConversation int
+	RetentionDeliveredDays      int
+	RetentionDryRun             bool
+	RetentionVacuumPages        int
+
+	// Attachment storage. Blobs are content-addressed under AttachmentDir;
+	// unreferenced blobs are removed by the retention job after AttachmentGCGrace.
+	AttachmentDir      string
+	AttachmentMaxSize  int64
+	AttachmentQuota    int64
+	AttachmentTokenTTL time.Duration
+	AttachmentGCGrace  time.Duration
+
+	// AccessTokenTTL is the lifetime of access tokens; RefreshTokenTTL that
+	// of the rotating refresh tokens used to renew them.
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// Brute-force protection: after LoginMaxFailures wrong passwords or codes
+	// within LoginFailureWindow an account is locked for LoginLockoutBase,
+	// doubling with each further failure up to LoginLockoutMax. Client IPs
+	// get the same treatment after LoginMaxFailuresPerIP failures.
+	LoginMaxFailures      int
+	LoginMaxFailuresPerIP int
+	LoginFailureWindow    time.Duration
+	LoginLockoutBase      time.Duration
+	LoginLockoutMax       time.Duration
+
+	// AdminToken authorizes admin endpoints. Empty disables them.
+	AdminToken string
+
+	// PasswordResetTTL is how long admin-issued password reset tokens stay valid.
+	PasswordResetTTL time.Duration
+
+	// Argon2id parameters for new password hashes (memory in KiB). Existing
+	// hashes made with weaker parameters are upgraded on the next login.
+	Argon2Time    int
+	Argon2Memory  int
+	Argon2Threads int
+
+	// SearchRateLimit caps directory searches per user per minute. Zero disables the limit.
+	SearchRateLimit int
+
+	// TLS. With a certificate and key the server speaks HTTPS (and HTTP/2),
+	// reloading them on SIGHUP or when the files change, checked every
+	// TLSReloadInterval. TLSClientCAFile makes admin endpoints require a client
+	// certificate signed by one of its CAs. HTTPRedirectPort, when set, serves
+	// a plain HTTP listener redirecting to HTTPS.
+	TLSCertFile       string
+	TLSKeyFile        string
+	TLSMinVersion     string
+	TLSClientCAFile   string
+	TLSReloadInterval time.Duration
+	HTTPRedirectPort  string
+
+	// On SIGTERM or SIGINT the server stops accepting connections and drains
+	// the open ones, telling WebSocket clients to reconnect after
+	// ShutdownRetryAfter, then closes the store. ShutdownTimeout bounds it all.
+	ShutdownTimeout    time.Duration
+	ShutdownRetryAfter time.Duration
 }
 
 func Load() Config {
@@ -18,6 +108,59 @@ func Load() Config {
 		Database:  getEnv("DATABASE_URL", "chatterbox.db"),
 		JWTSecret: getEnv("JWT_SECRET", "your_jwt_secret_here"),
 		Debug:     getEnvBool("DEBUG", false),
+
+		MessageEditWindow: getEnvDuration("MESSAGE_EDIT_WINDOW", 15*time.Minute),
+		PresenceAwayAfter: getEnvDuration("PRESENCE_AWAY_AFTER", 5*time.Minute),
+
+		WSPingInterval:   getEnvDuration("WS_PING_INTERVAL", 30*time.Second),
+		WSPongWait:       getEnvDuration("WS_PONG_WAIT", 60*time.Second),
+		WSWriteWait:      getEnvDuration("WS_WRITE_WAIT", 10*time.Second),
+		WSMaxMessageSize: int64(getEnvInt("WS_MAX_MESSAGE_SIZE", 64<<10)),
+
+		WSSlowConsumer:        getEnv("WS_SLOW_CONSUMER", "block"),
+		WSSlowConsumerTimeout: getEnvDuration("WS_SLOW_CONSUMER_TIMEOUT", 5*time.Second),
+
+		RetentionInterval:           getEnvDuration("RETENTION_INTERVAL", time.Hour),
+		RetentionMaxAgeDays:         getEnvInt("RETENTION_MAX_AGE_DAYS", 0),
+		RetentionMaxPerConversation: getEnvInt("RETENTION_MAX_PER_CONVERSATION", 0),
+		RetentionDeliveredDays:      getEnvInt("RETENTION_DELIVERED_DAYS", 0),
+		RetentionDryRun:             getEnvBool("RETENTION_DRY_RUN", false),
+		RetentionVacuumPages:        getEnvInt("RETENTION_VACUUM_PAGES", 1000),
+
+		AttachmentDir:      getEnv("ATTACHMENT_DIR", "attachments"),
+		AttachmentMaxSize:  int64(getEnvInt("ATTACHMENT_MAX_SIZE", 25<<20)),
+		AttachmentQuota:    int64(getEnvInt("ATTACHMENT_QUOTA", 500<<20)),
+		AttachmentTokenTTL: getEnvDuration("ATTACHMENT_TOKEN_TTL", 5*time.Minute),
+		AttachmentGCGrace:  getEnvDuration("ATTACHMENT_GC_GRACE", 24*time.Hour),
+
+		AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		LoginMaxFailures:      getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginMaxFailuresPerIP: getEnvInt("LOGIN_MAX_FAILURES_PER_IP", 20),
+		LoginFailureWindow:    getEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		LoginLockoutBase:      getEnvDuration("LOGIN_LOCKOUT_BASE", time.Minute),
+		LoginLockoutMax:       getEnvDuration("LOGIN_LOCKOUT_MAX", time.Hour),
+
+		AdminToken: getEnv("ADMIN_TOKEN", ""),
+
+		PasswordResetTTL: getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+
+		Argon2Time:    getEnvInt("ARGON2_TIME", 1),
+		Argon2Memory:  getEnvInt("ARGON2_MEMORY", 64*1024),
+		Argon2Threads: getEnvInt("ARGON2_THREADS", 2),
+
+		SearchRateLimit: getEnvInt("SEARCH_RATE_LIMIT", 30),
+
+		TLSCertFile:       getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:        getEnv("TLS_KEY_FILE", ""),
+		TLSMinVersion:     getEnv("TLS_MIN_VERSION", "1.2"),
+		TLSClientCAFile:   getEnv("TLS_CLIENT_CA_FILE", ""),
+		TLSReloadInterval: getEnvDuration("TLS_RELOAD_INTERVAL", 30*time.Second),
+		HTTPRedirectPort:  getEnv("HTTP_REDIRECT_PORT", ""),
+
+		ShutdownTimeout:    getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		ShutdownRetryAfter: getEnvDuration("SHUTDOWN_RETRY_AFTER", 5*time.Second),
 	}
 }
 
@@ -39,3 +182,27 @@ func getEnvBool(key string, fallback bool) bool {
 	}
 	return b
 }
+
+func getEnvInt(key string, fallback int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		return fallback
+	}
+	return i
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/blobstore"
+	"github.com/edpsouza/chatterbox/internal/models"
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+// AccountHandler serves the authenticated user's account:
+//
+//	DELETE /account          {"password":"...","code":"..."}: delete the account
+//	GET    /account/export   zip of everything stored about the user
+func AccountHandler(storeInstance *store.Store, hub *Hub, blobs blobstore.BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := authenticateRequest(r)
+		if err != nil {
+			writeError(w, CodeUnauthorized, "Unauthorized")
+			return
+		}
+		if r.Method == http.MethodGet {
+			handleExportAccount(storeInstance, w, claims.Username)
+			return
+		}
+		handleDeleteAccount(storeInstance, hub, blobs, w, r, claims)
+	}
+}
+
+// handleDeleteAccount deletes the caller's account after checking their
+// password, and second factor if enabled. Contacts are told the account is gone.
+func handleDeleteAccount(storeInstance *store.Store, hub *Hub, blobs blobstore.BlobStore, w http.ResponseWriter, r *http.Request, claims *Claims) {
+	var req UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" {
+		writeError(w, CodeBadRequest, "Password required")
+		return
+	}
+	ip := clientIP(r)
+	release, wait := beginLoginAttempt(r.Context(), storeInstance, claims.Username, ip)
+	defer release()
+	if wait > 0 {
+		writeLockedOut(w, wait)
+		return
+	}
+	user, err := checkPassword(storeInstance, claims.Username, req.Password)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return
+	}
+	if user == nil {
+		recordLoginFailure(storeInstance, claims.Username, ip)
+		writeError(w, CodeInvalidCredentials, "Invalid credentials")
+		return
+	}
+	enabled, err := twoFactorEnabled(storeInstance, user.Username)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return
+	}
+	if enabled {
+		ok, err := verifySecondFactor(storeInstance, user.Username, req.Code)
+		if err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		if !ok {
+			recordLoginFailure(storeInstance, user.Username, ip)
+			writeError(w, CodeInvalidCredentials, "Invalid two-factor code")
+			return
+		}
+	}
+
+	contacts, err := storeInstance.ListContacts(user.Username)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return
+	}
+	orphaned, err := storeInstance.DeleteAccount(user.Username)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return
+	}
+	if blobs != nil {
+		for _, a := range orphaned {
+			if a.Complete() {
+				err = blobs.Delete(a.Digest)
+			} else {
+				err = blobs.Abort(a.ID)
+			}
+			// The account is gone either way; leftovers are swept by the retention job
+			if err != nil {
+				log.Printf("Failed to remove attachment %s of deleted account: %v", a.ID, err)
+			}
+		}
+	}
+	if hub != nil {
+		hub.disconnectUser(user.Username)
+	}
+	for _, contact := range contacts {
+		if contact.Status == models.ContactAccepted {
+			notifyUser(hub, storeInstance, contact.Username, Event{Type: EventAccountDeleted, Username: user.Username})
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// accountExport is the content of profile.json in an account export.
+type accountExport struct {
+	Account    *models.User           `json:"account"`
+	Profile    *models.Profile        `json:"profile"`
+	Privacy    models.PrivacySettings `json:"privacy"`
+	ExportedAt time.Time              `json:"exported_at"`
+}
+
+// exportedKey is an entry of keys.json in an account export.
+type exportedKey struct {
+	PublicKey string `json:"public_key"`
+	Current   bool   `json:"current"`
+}
+
+// handleExportAccount responds with a zip holding the caller's profile,
+// keys, contacts, sessions and ciphertext message history as JSON files.
+func handleExportAccount(storeInstance *store.Store, w http.ResponseWriter, username string) {
+	user, err := storeInstance.GetUserByUsername(username)
+	if err != nil || user == nil {
+		writeError(w, CodeNotFound, "User not found")
+		return
+	}
+	profile, err := storeInstance.GetProfile(user.Username)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return
+	}
+	privacy, err := storeInstance.GetPrivacySettings(user.Username)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return
+	}
+	contacts, err := storeInstance.ListContacts(user.Username)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return
+	}
+	blocks, err := storeInstance.ListBlocks(user.Username)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return
+	}
+	sessions, err := storeInstance.ListSessions(user.Username)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return
+	}
+	messages, err := storeInstance.GetUserMessages(user.Username)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return
+	}
+
+	// Only the current public key is stored; key history starts with it
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"profile.json", accountExport{Account: user, Profile: profile, Privacy: privacy, ExportedAt: time.Now().UTC()}},
+		{"keys.json", []exportedKey{{PublicKey: user.PublicKey, Current: true}}},
+		{"contacts.json", map[string]any{"contacts": contacts, "blocks": blocks}},
+		{"sessions.json", sessions},
+		{"messages.json", messages},
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			writeError(w, CodeInternal, "Export failed")
+			return
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.v); err != nil {
+			writeError(w, CodeInternal, "Export failed")
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		writeError(w, CodeInternal, "Export failed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "chatterbox-export-" + user.Username + ".zip"}))
+	w.Write(buf.Bytes())
+}
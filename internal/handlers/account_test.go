@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+func TestAccountExportAndDelete(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	hashed, _ := models.HashPassword("secret123")
+	storeInstance.CreateUser(&models.User{Username: "alice", Password: hashed, PublicKey: "alicekey", DisplayName: "Alice"})
+	storeInstance.CreateUser(&models.User{Username: "bob", Password: hashed, PublicKey: "bobkey"})
+	storeInstance.CreateMessage(1, "alice", "bob", "ciphertext-1")
+	storeInstance.CreateMessage(2, "bob", "alice", "ciphertext-2")
+	storeInstance.RequestContact("alice", "bob")
+	storeInstance.AcceptContact("bob", "alice")
+
+	hub := NewHub()
+	go hub.Run()
+	handler := routed(AccountHandler(storeInstance, hub, nil), "DELETE /account", "GET /account/export")
+	token := issueTestToken(t, "alice")
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	w := do(http.MethodGet, "/account/export", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("expected zip export, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		var buf bytes.Buffer
+		buf.ReadFrom(rc)
+		rc.Close()
+		files[f.Name] = buf.Bytes()
+	}
+	for _, name := range []string{"profile.json", "keys.json", "contacts.json", "sessions.json", "messages.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("export missing %s", name)
+		}
+	}
+	var messages []models.Message
+	json.Unmarshal(files["messages.json"], &messages)
+	if len(messages) != 2 || messages[0].Content != "ciphertext-1" {
+		t.Errorf("unexpected exported messages: %+v", messages)
+	}
+	if !strings.Contains(string(files["keys.json"]), "alicekey") || strings.Contains(string(files["profile.json"]), hashed) {
+		t.Error("expected keys in export and no password hash")
+	}
+
+	// Bob is online and hears about the deletion
+	bob := newTestClient(hub)
+	hub.authenticate(bob, "2", "bob")
+
+	if w := do(http.MethodDelete, "/account", `{"password":"wrong"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for wrong password, got %d", w.Code)
+	}
+	if w := do(http.MethodDelete, "/account", `{"password":"secret123"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 deleting account, got %d", w.Code)
+	}
+	if event := nextEvent(t, bob); event.Type != EventAccountDeleted || event.Username != "alice" {
+		t.Errorf("expected account_deleted event, got %+v", event)
+	}
+	if user, _ := storeInstance.GetUserByUsername("alice"); user != nil {
+		t.Error("expected alice to be deleted")
+	}
+	if messages, _ := storeInstance.GetMessagesBetween("alice", "bob"); len(messages) != 0 {
+		t.Errorf("expected messages to be deleted, got %d", len(messages))
+	}
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/blobstore"
+	"github.com/edpsouza/chatterbox/internal/store"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AttachmentConfig holds limits for attachment uploads and downloads.
+type AttachmentConfig struct {
+	MaxSize  int64         // largest accepted attachment, in bytes
+	Quota    int64         // total bytes a user may store
+	TokenTTL time.Duration // lifetime of download tokens
+}
+
+// attachmentAudience marks download tokens so they cannot be used as access tokens.
+const attachmentAudience = "attachment"
+
+// AttachmentClaims authorize a single user to download a single attachment.
+type AttachmentClaims struct {
+	AttachmentID string `json:"attachment_id"`
+	Username     string `json:"username"`
+	jwt.RegisteredClaims
+}
+
+// AttachmentsHandler serves the attachment upload and download endpoints:
+//
+//	POST  /attachments              {"size":N}: start an upload
+//	HEAD  /attachments/:id          current offset in the Upload-Offset header
+//	PATCH /attachments/:id          append a chunk at Upload-Offset
+//	POST  /attachments/:id/token    issue a download token to a participant
+//	GET   /attachments/:id?token=   download the encrypted blob
+func AttachmentsHandler(storeInstance *store.Store, blobs blobstore.BlobStore, cfg AttachmentConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		switch {
+		case id == "":
+			handleCreateAttachment(storeInstance, cfg, w, r)
+		case r.Method == http.MethodHead:
+			handleAttachmentOffset(storeInstance, w, r, id)
+		case r.Method == http.MethodPatch:
+			handleAttachmentChunk(storeInstance, blobs, w, r, id)
+		case r.Method == http.MethodGet:
+			handleAttachmentDownload(storeInstance, blobs, w, r, id)
+		default:
+			// The only other route is POST /attachments/{id}/token
+			handleAttachmentToken(storeInstance, cfg, w, r, id)
+		}
+	}
+}
+
+// handleCreateAttachment registers a new upload after checking size limits and quota.
+func handleCreateAttachment(storeInstance *store.Store, cfg AttachmentConfig, w http.ResponseWriter, r *http.Request) {
+	claims, err := authenticateRequest(r)
+	if err != nil {
+		writeError(w, CodeUnauthorized, "Unauthorized")
+		return
+	}
+	var req struct {
+		Size int64 `json:"size"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Size <= 0 {
+		writeError(w, CodeBadRequest, "Positive size required")
+		return
+	}
+	if cfg.MaxSize > 0 && req.Size > cfg.MaxSize {
+		writeError(w, CodePayloadTooLarge, "Attachment too large")
+		return
+	}
+	a, err := storeInstance.CreateAttachment(claims.Username, req.Size, cfg.Quota)
+	if errors.Is(err, store.ErrQuotaExceeded) {
+		writeError(w, CodePayloadTooLarge, "Attachment quota exceeded")
+		return
+	}
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Upload-Offset", "0")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+// handleAttachmentOffset reports how much of an upload the server has, so clients can resume.
+func handleAttachmentOffset(storeInstance *store.Store, w http.ResponseWriter, r *http.Request, id string) {
+	claims, err := authenticateRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	a, err := storeInstance.GetAttachment(id)
+	if err != nil || a == nil || a.Owner != claims.Username {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(a.Received, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(a.Size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleAttachmentChunk appends a chunk to an upload and commits it once all bytes have arrived.
+func handleAttachmentChunk(storeInstance *store.Store, blobs blobstore.BlobStore, w http.ResponseWriter, r *http.Request, id string) {
+	claims, err := authenticateRequest(r)
+	if err != nil {
+		writeError(w, CodeUnauthorized, "Unauthorized")
+		return
+	}
+	a, err := storeInstance.GetAttachment(id)
+	if err != nil || a == nil || a.Owner != claims.Username {
+		writeError(w, CodeNotFound, "Attachment not found")
+		return
+	}
+	if a.Complete() {
+		writeError(w, CodeConflict, "Upload already complete")
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != a.Received {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(a.Received, 10))
+		writeError(w, CodeConflict, "Upload-Offset does not match")
+		return
+	}
+
+	// Never accept more than the declared size
+	body := http.MaxBytesReader(w, r.Body, a.Size-offset)
+	received, err := blobs.Append(id, offset, body)
+	if received > a.Received {
+		_ = storeInstance.SetAttachmentReceived(id, received)
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(received, 10))
+	if errors.Is(err, blobstore.ErrOffsetMismatch) {
+		writeError(w, CodeConflict, "Upload-Offset does not match")
+		return
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, CodePayloadTooLarge, "Chunk exceeds declared size")
+		return
+	}
+	if err != nil {
+		// Partial chunks are kept; the client resumes from Upload-Offset.
+		writeError(w, CodeInternal, "Upload interrupted")
+		return
+	}
+
+	if received == a.Size {
+		digest, err := blobs.Commit(id)
+		if err != nil {
+			writeError(w, CodeInternal, "Failed to store attachment")
+			return
+		}
+		if err := storeInstance.CompleteAttachment(id, digest); err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAttachmentToken issues a short-lived download token to the uploader
+// or a participant of a message that references the attachment.
+func handleAttachmentToken(storeInstance *store.Store, cfg AttachmentConfig, w http.ResponseWriter, r *http.Request, id string) {
+	claims, err := authenticateRequest(r)
+	if err != nil {
+		writeError(w, CodeUnauthorized, "Unauthorized")
+		return
+	}
+	ok, err := storeInstance.CanAccessAttachment(id, claims.Username)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return
+	}
+	if !ok {
+		writeError(w, CodeNotFound, "Attachment not found")
+		return
+	}
+	expires := time.Now().Add(cfg.TokenTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, AttachmentClaims{
+		AttachmentID: id,
+		Username:     claims.Username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{attachmentAudience},
+			ExpiresAt: jwt.NewNumericDate(expires),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	signed, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		writeError(w, CodeInternal, "JWT error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"token":      signed,
+		"expires_at": expires.UTC().Format(time.RFC3339),
+	})
+}
+
+// handleAttachmentDownload streams an encrypted blob to the holder of a valid download token.
+func handleAttachmentDownload(storeInstance *store.Store, blobs blobstore.BlobStore, w http.ResponseWriter, r *http.Request, id string) {
+	claims := &AttachmentClaims{}
+	_, err := jwt.ParseWithClaims(r.URL.Query().Get("token"), claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(attachmentAudience))
+	if err != nil || claims.AttachmentID != id {
+		writeError(w, CodeForbidden, "Invalid download token")
+		return
+	}
+	// Access may have been lost since the token was issued (e.g. message deleted)
+	ok, err := storeInstance.CanAccessAttachment(id, claims.Username)
+	if err != nil || !ok {
+		writeError(w, CodeNotFound, "Attachment not found")
+		return
+	}
+	a, err := storeInstance.GetAttachment(id)
+	if err != nil || a == nil || !a.Complete() {
+		writeError(w, CodeNotFound, "Attachment not found")
+		return
+	}
+	blob, err := blobs.Open(a.Digest)
+	if err != nil {
+		writeError(w, CodeNotFound, "Attachment not found")
+		return
+	}
+	defer blob.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	io.Copy(w, blob)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/blobstore"
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+func TestAttachmentsHandler_UploadAndDownload(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	blobs, err := blobstore.NewFS(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create blob store: %v", err)
+	}
+	handler := routed(AttachmentsHandler(storeInstance, blobs, AttachmentConfig{MaxSize: 1024, Quota: 2048, TokenTTL: time.Minute}), attachmentsRoutes...)
+	alice := "Bearer " + issueTestToken(t, "alice")
+
+	do := func(method, path, auth string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	// Quota applies to declared sizes
+	if w := do(http.MethodPost, "/attachments", alice, strings.NewReader(`{"size":4096}`), nil); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for oversized attachment, got %d", w.Code)
+	}
+
+	w := do(http.MethodPost, "/attachments", alice, strings.NewReader(`{"size":11}`), nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var a models.Attachment
+	json.NewDecoder(w.Body).Decode(&a)
+	path := "/attachments/" + a.ID
+
+	// Upload in two chunks, resuming from the reported offset
+	if w := do(http.MethodPatch, path, alice, strings.NewReader("hello "), map[string]string{"Upload-Offset": "0"}); w.Code != http.StatusNoContent {
+		t.Fatalf("first chunk failed: %d", w.Code)
+	}
+	w = do(http.MethodHead, path, alice, nil, nil)
+	if off := w.Header().Get("Upload-Offset"); off != "6" {
+		t.Fatalf("expected offset 6, got %q", off)
+	}
+	if w := do(http.MethodPatch, path, alice, strings.NewReader("world"), map[string]string{"Upload-Offset": "6"}); w.Code != http.StatusNoContent {
+		t.Fatalf("second chunk failed: %d", w.Code)
+	}
+
+	// Attach it to a message for bob
+	id, _ := storeInstance.CreateMessage(1, "alice", "bob", "ciphertext")
+	if err := storeInstance.CheckAttachments("alice", []string{a.ID}); err != nil {
+		t.Fatalf("attachment not usable: %v", err)
+	}
+	storeInstance.LinkAttachments(id, []string{a.ID})
+
+	// Strangers cannot get a download token
+	if w := do(http.MethodPost, path+"/token", "Bearer "+issueTestToken(t, "mallory"), nil, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for non-participant, got %d", w.Code)
+	}
+	w = do(http.MethodPost, path+"/token", "Bearer "+issueTestToken(t, "bob"), nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected token for participant, got %d", w.Code)
+	}
+	var tok map[string]string
+	json.NewDecoder(w.Body).Decode(&tok)
+
+	// Download tokens are not access tokens
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tok["token"])
+	if _, err := authenticateRequest(req); err == nil {
+		t.Error("download token accepted as access token")
+	}
+
+	w = do(http.MethodGet, path+"?token="+tok["token"], "", nil, nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), []byte("hello world")) {
+		t.Fatalf("download failed: %d %q", w.Code, w.Body.String())
+	}
+}
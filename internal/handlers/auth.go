@@ -2,11 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
-	"time"
+	"unicode/utf8"
 
 	"github.com/edpsouza/chatterbox/internal/models"
 	"github.com/edpsouza/chatterbox/internal/store"
@@ -18,49 +17,94 @@ type UserRequest struct {
 	Username  string `json:"username"`
 	Password  string `json:"password,omitempty"`
 	PublicKey string `json:"public_key,omitempty"`
+
+	DisplayName string `json:"display_name,omitempty"` // optional, registration only
+	DeviceName  string `json:"device_name,omitempty"`  // optional, login only
+	Code        string `json:"code,omitempty"`         // TOTP or recovery code, login only
+}
+
+// TwoFactorChallenge answers a login that still needs its second factor;
+// the challenge is exchanged at /login/2fa.
+type TwoFactorChallenge struct {
+	ErrorResponse
+	Challenge string `json:"challenge"`
 }
 
 // JWT claims
 type Claims struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// authenticateRequest validates the "Authorization: Bearer <jwt>" header
+// issued by LoginHandler and returns the token's claims. Every authenticated
+// HTTP endpoint goes through it and the WebSocket handshake shares
+// parseAccessToken, so revoked tokens are rejected everywhere.
+func authenticateRequest(r *http.Request) (*Claims, error) {
+	header := r.Header.Get("Authorization")
+	tokenString, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || tokenString == "" {
+		return nil, errors.New("missing bearer token")
+	}
+	claims, err := parseAccessToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if storeInstance, err := getStoreInstance(); err == nil && claims.SessionID != "" {
+		_ = storeInstance.TouchSession(claims.SessionID, r.UserAgent(), clientIP(r))
+	}
+	return claims, nil
+}
+
 // RegisterHandler handles user registration using Store
 func RegisterHandler(storeInstance *store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req UserRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request", http.StatusBadRequest)
+			writeError(w, CodeBadRequest, "Invalid request")
 			return
 		}
 		if req.Username == "" || req.Password == "" || req.PublicKey == "" {
-			http.Error(w, "Username, password, and public key required", http.StatusBadRequest)
+			writeError(w, CodeBadRequest, "Username, password, and public key required")
+			return
+		}
+		username, err := models.NormalizeUsername(req.Username)
+		if err != nil {
+			writeError(w, CodeInvalidUsername, err.Error())
+			return
+		}
+		if utf8.RuneCountInString(req.DisplayName) > models.MaxDisplayNameLength {
+			writeError(w, CodeBadRequest, "Display name too long")
 			return
 		}
 		// Hash password
 		hashed, err := models.HashPassword(req.Password)
 		if err != nil {
-			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			writeError(w, CodeInternal, "Failed to hash password")
 			return
 		}
 		user := &models.User{
-			Username:  req.Username,
-			Password:  hashed,
-			PublicKey: req.PublicKey,
+			Username:    username,
+			Password:    hashed,
+			PublicKey:   req.PublicKey,
+			DisplayName: strings.TrimSpace(req.DisplayName),
 		}
 		err = storeInstance.CreateUser(user)
 		if err != nil {
-			if err.Error() == "username already exists" {
-				http.Error(w, "Username already taken", http.StatusConflict)
+			if errors.Is(err, store.ErrUsernameTaken) {
+				writeError(w, CodeUsernameTaken, "Username already taken")
+				return
+			}
+			if errors.Is(err, store.ErrUsernameConfusable) {
+				writeError(w, CodeUsernameTaken, "Username too similar to an existing one")
 				return
 			}
-			http.Error(w, "Database error", http.StatusInternalServerError)
+			writeError(w, CodeInternal, "Database error")
 			return
 		}
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(map[string]interface{}{
+		writeJSON(w, http.StatusCreated, map[string]interface{}{
 			"id":         user.ID,
 			"username":   user.Username,
 			"public_key": user.PublicKey,
@@ -73,46 +117,85 @@ func LoginHandler(storeInstance *store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req UserRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request", http.StatusBadRequest)
+			writeError(w, CodeBadRequest, "Invalid request")
 			return
 		}
 		if req.Username == "" || req.Password == "" {
-			http.Error(w, "Username and password required", http.StatusBadRequest)
+			writeError(w, CodeBadRequest, "Username and password required")
 			return
 		}
-		// Get user
-		user, err := storeInstance.GetUserByUsername(req.Username)
-		if err != nil || user == nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		ip := clientIP(r)
+		release, wait := beginLoginAttempt(r.Context(), storeInstance, req.Username, ip)
+		defer release()
+		if wait > 0 {
+			writeLockedOut(w, wait)
 			return
 		}
-		ok, err := models.VerifyPassword(user.Password, req.Password)
-		if err != nil || !ok {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		user, err := checkPassword(storeInstance, req.Username, req.Password)
+		if err != nil {
+			writeError(w, CodeInternal, "Database error")
 			return
 		}
-		// Issue JWT
-		secret := os.Getenv("JWT_SECRET")
-		if secret == "" {
-			http.Error(w, "JWT secret not set", http.StatusInternalServerError)
+		if user == nil {
+			recordLoginFailure(storeInstance, req.Username, ip)
+			writeError(w, CodeInvalidCredentials, "Invalid credentials")
 			return
 		}
-		claims := Claims{
-			UserID:   strconv.FormatInt(user.ID, 10),
-			Username: user.Username,
-			RegisteredClaims: jwt.RegisteredClaims{
-				ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-				IssuedAt:  jwt.NewNumericDate(time.Now()),
-			},
-		}
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		signed, err := token.SignedString([]byte(secret))
+		// With 2FA on, the code may come along; otherwise hand out a challenge
+		// for the second step at /login/2fa
+		enabled, err := twoFactorEnabled(storeInstance, user.Username)
 		if err != nil {
-			http.Error(w, "JWT error", http.StatusInternalServerError)
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		if enabled && req.Code == "" {
+			challenge, err := signLoginChallenge(user.Username, req.DeviceName)
+			if err != nil {
+				writeError(w, CodeInternal, "JWT error")
+				return
+			}
+			writeJSON(w, http.StatusUnauthorized, TwoFactorChallenge{
+				ErrorResponse: newErrorResponse(w, CodeTwoFactorRequired, "Two-factor code required"),
+				Challenge:     challenge,
+			})
 			return
 		}
-		json.NewEncoder(w).Encode(map[string]string{"token": signed})
+		if enabled {
+			ok, err := verifySecondFactor(storeInstance, user.Username, req.Code)
+			if err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			if !ok {
+				recordLoginFailure(storeInstance, req.Username, ip)
+				writeError(w, CodeInvalidCredentials, "Invalid two-factor code")
+				return
+			}
+		}
+		clearLoginFailures(storeInstance, req.Username)
+		startSession(storeInstance, w, r, user, req.DeviceName)
+	}
+}
+
+// startSession opens a new session for a fully authenticated user and
+// responds with its access/refresh token pair.
+func startSession(storeInstance *store.Store, w http.ResponseWriter, r *http.Request, user *models.User, deviceName string) {
+	sessionID, err := storeInstance.CreateSession(user.Username, deviceName, r.UserAgent(), clientIP(r), refreshTokenTTL)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return
+	}
+	access, err := signAccessToken(user.ID, user.Username, sessionID)
+	if err != nil {
+		writeError(w, CodeInternal, "JWT error")
+		return
+	}
+	refresh, err := storeInstance.CreateRefreshToken(user.Username, sessionID, refreshTokenTTL)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return
 	}
+	writeTokens(w, access, refresh)
 }
 
 // PublicKeyHandler handles GET /users/:username/public_key requests
@@ -121,17 +204,17 @@ func PublicKeyHandler(storeInstance *store.Store) http.HandlerFunc {
 		// Extract username from URL path
 		parts := strings.Split(r.URL.Path, "/")
 		if len(parts) < 4 || parts[1] != "users" || parts[3] != "public_key" {
-			http.Error(w, "Invalid endpoint", http.StatusBadRequest)
+			writeError(w, CodeBadRequest, "Invalid endpoint")
 			return
 		}
 		username := parts[2]
 		if username == "" {
-			http.Error(w, "Username required", http.StatusBadRequest)
+			writeError(w, CodeBadRequest, "Username required")
 			return
 		}
 		user, err := storeInstance.GetUserByUsername(username)
 		if err != nil || user == nil {
-			http.Error(w, "User not found", http.StatusNotFound)
+			writeError(w, CodeNotFound, "User not found")
 			return
 		}
 		resp := map[string]string{
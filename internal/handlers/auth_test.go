@@ -6,18 +6,21 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/edpsouza/chatterbox/internal/store"
 )
 
 func setupTestStore(t *testing.T) *store.Store {
-	dbPath := "test_auth.db"
-	t.Cleanup(func() { os.Remove(dbPath) })
-	storeInstance, err := store.NewStore(dbPath)
+	storeInstance, err := store.NewStore(filepath.Join(t.TempDir(), "test_auth.db"))
 	if err != nil {
 		t.Fatalf("failed to create test store: %v", err)
 	}
+	t.Cleanup(func() { storeInstance.Close() })
+	// authenticateRequest checks the revocation list in the global store
+	SetStoreInstance(storeInstance)
+	t.Cleanup(func() { SetStoreInstance(nil) })
 	return storeInstance
 }
 
@@ -80,3 +83,50 @@ func TestRegisterAndLoginHandler(t *testing.T) {
 		t.Errorf("login response missing 'token' field: %v", respBody)
 	}
 }
+
+func TestRegisterNormalizesUsernames(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+
+	register := func(username string) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(map[string]string{"username": username, "password": "testpassword", "public_key": "k"})
+		w := httptest.NewRecorder()
+		RegisterHandler(storeInstance)(w, httptest.NewRequest("POST", "/register", bytes.NewReader(body)))
+		return w
+	}
+
+	w := register("Alice")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	var created map[string]any
+	json.NewDecoder(w.Body).Decode(&created)
+	if created["username"] != "alice" {
+		t.Errorf("expected canonical username alice, got %v", created["username"])
+	}
+
+	cases := map[string]int{
+		"ALICE":  http.StatusConflict,   // same canonical name
+		"аӏісе":  http.StatusConflict,   // all Cyrillic look-alike
+		"a1ice":  http.StatusCreated,    // digits aren't folded
+		"аlice":  http.StatusBadRequest, // mixed Cyrillic and Latin
+		"admin":  http.StatusBadRequest, // reserved
+		"a b":    http.StatusBadRequest,
+		"x":      http.StatusBadRequest,
+		"alicia": http.StatusCreated,
+	}
+	for username, want := range cases {
+		if w := register(username); w.Code != want {
+			t.Errorf("register %q: expected %d, got %d (%s)", username, want, w.Code, w.Body.String())
+		}
+	}
+
+	// Any case logs in to the canonical account
+	body, _ := json.Marshal(map[string]string{"username": "ALICE", "password": "testpassword"})
+	w = httptest.NewRecorder()
+	LoginHandler(storeInstance)(w, httptest.NewRequest("POST", "/login", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected login as ALICE to succeed, got %d", w.Code)
+	}
+}
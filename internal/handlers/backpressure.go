@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Slow consumer policies, applied when a connection's send queue is full.
+const (
+	SlowConsumerDrop       = "drop"
+	SlowConsumerDisconnect = "disconnect"
+	SlowConsumerBlock      = "block"
+)
+
+// IsSlowConsumerPolicy reports whether policy is one of the slow consumer policies.
+func IsSlowConsumerPolicy(policy string) bool {
+	switch policy {
+	case SlowConsumerDrop, SlowConsumerDisconnect, SlowConsumerBlock:
+		return true
+	}
+	return false
+}
+
+// pendingFlushWait bounds the wait for room while flushing queued events to
+// a new connection.
+const pendingFlushWait = 5 * time.Second
+
+// sendMetrics counts what happened to frames offered to connections:
+// queued, dropped (queue full), timed_out (waited in vain under the block
+// policy), disconnected, closed (connection already gone), and spilled or
+// lost for dropped frames that did or did not make it to the offline queue.
+var sendMetrics = expvar.NewMap("websocket_sends")
+
+// sendOutcome is the result of offering a frame to a connection.
+type sendOutcome int
+
+const (
+	sendQueued sendOutcome = iota
+	sendDropped
+	sendClosed
+)
+
+// offer queues payload on c, applying the hub's slow consumer policy when
+// the queue is full. A dropped frame is the caller's to spill.
+func (c *Client) offer(payload []byte) sendOutcome {
+	return c.offerWaiting(payload, true)
+}
+
+// offerNow is offer for frames the hub pushes on its own, such as presence
+// events and broadcasts, which never wait for room: under the block policy
+// a full queue drops the frame.
+func (c *Client) offerNow(payload []byte) sendOutcome {
+	return c.offerWaiting(payload, false)
+}
+
+// offerWaiting implements offer, waiting for room under the block policy
+// only if mayWait is set.
+func (c *Client) offerWaiting(payload []byte, mayWait bool) sendOutcome {
+	policy, timeout := SlowConsumerBlock, 5*time.Second
+	if c.hub != nil {
+		policy, timeout = c.hub.SlowConsumer, c.hub.SlowConsumerTimeout
+	}
+	wait := time.Duration(0)
+	if policy == SlowConsumerBlock && mayWait {
+		wait = timeout
+	}
+	queued, closed := c.queue(payload, wait)
+	switch {
+	case queued:
+		sendMetrics.Add("queued", 1)
+		return sendQueued
+	case closed:
+		sendMetrics.Add("closed", 1)
+		return sendClosed
+	}
+	sendMetrics.Add("dropped", 1)
+	switch policy {
+	case SlowConsumerBlock:
+		if wait > 0 {
+			sendMetrics.Add("timed_out", 1)
+		}
+	case SlowConsumerDisconnect:
+		sendMetrics.Add("disconnected", 1)
+		c.disconnectSlow()
+	}
+	return sendDropped
+}
+
+// queue puts payload on Send, waiting up to wait for room. It reports
+// whether payload was queued, and whether Send was already closed.
+func (c *Client) queue(payload []byte, wait time.Duration) (queued, closed bool) {
+	c.sendMu.RLock()
+	defer c.sendMu.RUnlock()
+	if c.sendClosed {
+		return false, true
+	}
+	select {
+	case c.Send <- payload:
+		return true, false
+	default:
+	}
+	if wait <= 0 {
+		return false, false
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case c.Send <- payload:
+		return true, false
+	case <-timer.C:
+		return false, false
+	case <-c.stopping():
+		return false, true
+	}
+}
+
+// stopping returns the channel closeSend closes before it waits for sendMu.
+func (c *Client) stopping() chan struct{} {
+	c.sendStopInit.Do(func() { c.sendStop = make(chan struct{}) })
+	return c.sendStop
+}
+
+// closeSend closes Send once no frame is being queued; later offers see
+// the connection as closed. Offers waiting for room give up instead of
+// holding it up, so it never waits out the slow consumer timeout. It is
+// safe to call more than once.
+func (c *Client) closeSend() {
+	c.sendStopOnce.Do(func() { close(c.stopping()) })
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if !c.sendClosed {
+		c.sendClosed = true
+		close(c.Send)
+	}
+}
+
+// disconnectSlow closes a connection that cannot keep up; its readPump then
+// unregisters it. Clients without a connection are unregistered directly.
+func (c *Client) disconnectSlow() {
+	if c.Conn != nil {
+		c.Conn.Close()
+		return
+	}
+	if c.hub != nil {
+		go func() {
+			select {
+			case c.hub.Unregister <- c:
+			case <-c.hub.quit:
+			}
+		}()
+	}
+}
+
+// spill queues a frame that a connection could not take in username's
+// offline queue, delivered when one of their devices next connects.
+func spill(username string, payload []byte) bool {
+	storeInstance, err := getStoreInstance()
+	if err != nil || username == "" {
+		sendMetrics.Add("lost", 1)
+		return false
+	}
+	if err := storeInstance.QueueEvent(username, payload); err != nil {
+		log.Printf("Failed to spill event for %s: %v", username, err)
+		sendMetrics.Add("lost", 1)
+		return false
+	}
+	sendMetrics.Add("spilled", 1)
+	return true
+}
+
+// AdminMetricsHandler serves GET /admin/metrics: the process's expvar
+// variables, including the websocket_sends counters, as JSON. It requires
+// "Authorization: Bearer <ADMIN_TOKEN>".
+func AdminMetricsHandler(adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(w, r, adminToken) {
+			return
+		}
+		expvar.Handler().ServeHTTP(w, r)
+	}
+}
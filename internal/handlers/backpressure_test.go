@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"expvar"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sendCount reads a websocket_sends counter.
+func sendCount(name string) int64 {
+	if v, ok := sendMetrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// newSlowClient registers a client for username whose one-frame send queue
+// is already full.
+func newSlowClient(t *testing.T, hub *Hub, username string) *Client {
+	t.Helper()
+	c := &Client{Send: make(chan []byte, 1), subscriptions: make(map[string]bool), hub: hub}
+	hub.Register <- c
+	hub.authenticate(c, "1", username)
+	if !hub.SendToUser(username, []byte("first")) {
+		t.Fatal("expected the first frame to be queued")
+	}
+	return c
+}
+
+func TestBackpressure_DropSpillsToOfflineQueue(t *testing.T) {
+	storeInstance := setupTestStore(t)
+	hub := NewHub()
+	hub.SlowConsumer = SlowConsumerDrop
+	go hub.Run()
+	stopHubOnCleanup(t, hub)
+	newSlowClient(t, hub, "bob")
+
+	dropped, spilled := sendCount("dropped"), sendCount("spilled")
+	start := time.Now()
+	if hub.SendToUser("bob", []byte(`{"type":"message","id":2}`)) {
+		t.Error("expected the frame not to be delivered")
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("expected the drop policy not to wait")
+	}
+	// notifyUser must not queue a spilled event a second time
+	notifyUser(hub, storeInstance, "bob", Event{Type: EventProfile, Username: "alice"})
+
+	events, err := storeInstance.TakePendingEvents("bob")
+	if err != nil || len(events) != 2 || string(events[0]) != `{"type":"message","id":2}` {
+		t.Fatalf("expected both frames spilled once, got %q %v", events, err)
+	}
+	if sendCount("dropped") != dropped+2 || sendCount("spilled") != spilled+2 {
+		t.Errorf("expected 2 drops and 2 spills, got %d and %d", sendCount("dropped")-dropped, sendCount("spilled")-spilled)
+	}
+}
+
+func TestBackpressure_DisconnectsSlowClient(t *testing.T) {
+	storeInstance := setupTestStore(t)
+	hub := NewHub()
+	hub.SlowConsumer = SlowConsumerDisconnect
+	go hub.Run()
+	stopHubOnCleanup(t, hub)
+	bob := newSlowClient(t, hub, "bob")
+
+	disconnected := sendCount("disconnected")
+	if hub.SendToUser("bob", []byte(`{"type":"message","id":2}`)) {
+		t.Error("expected the frame not to be delivered")
+	}
+	if sendCount("disconnected") != disconnected+1 {
+		t.Error("expected the disconnect to be counted")
+	}
+	// The queued frame is still flushed, then the queue closes
+	if frame := <-bob.Send; string(frame) != "first" {
+		t.Errorf("expected the queued frame, got %q", frame)
+	}
+	select {
+	case _, ok := <-bob.Send:
+		if ok {
+			t.Error("expected no further frames")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the slow client to be disconnected")
+	}
+	if events, _ := storeInstance.TakePendingEvents("bob"); len(events) != 1 {
+		t.Errorf("expected the dropped frame to be spilled, got %q", events)
+	}
+	// Later frames find the connection gone instead of panicking
+	if hub.SendToUser("bob", []byte("late")) {
+		t.Error("expected no delivery after the disconnect")
+	}
+}
+
+func TestBackpressure_BlockWaitsForRoom(t *testing.T) {
+	setupTestStore(t)
+	hub := NewHub()
+	hub.SlowConsumer = SlowConsumerBlock
+	hub.SlowConsumerTimeout = time.Second
+	go hub.Run()
+	stopHubOnCleanup(t, hub)
+	bob := newSlowClient(t, hub, "bob")
+
+	// A consumer that catches up in time gets the frame
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		<-bob.Send
+	}()
+	if !hub.SendToUser("bob", []byte("second")) {
+		t.Fatal("expected the frame to be queued once there was room")
+	}
+
+	// One that doesn't costs the sender at most the timeout
+	hub.SlowConsumerTimeout = 20 * time.Millisecond
+	timedOut := sendCount("timed_out")
+	start := time.Now()
+	if hub.SendToUser("bob", []byte("third")) {
+		t.Error("expected the frame to time out")
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond || waited > time.Second {
+		t.Errorf("expected to wait about the timeout, waited %s", waited)
+	}
+	if sendCount("timed_out") != timedOut+1 {
+		t.Error("expected the timeout to be counted")
+	}
+}
+
+func TestBackpressure_UnregisterDoesNotWaitForBlockedSender(t *testing.T) {
+	setupTestStore(t)
+	hub := NewHub()
+	hub.SlowConsumer = SlowConsumerBlock
+	hub.SlowConsumerTimeout = 10 * time.Second
+	go hub.Run()
+	stopHubOnCleanup(t, hub)
+	bob := newSlowClient(t, hub, "bob")
+
+	sent := make(chan sendOutcome, 1)
+	go func() { sent <- bob.offer([]byte(`{"type":"message","id":2}`)) }()
+	time.Sleep(20 * time.Millisecond)
+
+	// Closing the queue releases the waiting sender instead of stalling the hub
+	start := time.Now()
+	hub.Unregister <- bob
+	hub.Register <- newTestClient(hub)
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("expected the hub not to wait for the sender, waited %s", waited)
+	}
+	select {
+	case outcome := <-sent:
+		if outcome != sendClosed {
+			t.Errorf("expected the waiting frame to find the queue closed, got %d", outcome)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the waiting sender to give up")
+	}
+}
+
+func TestBackpressure_LiveFramesAreNotSpilled(t *testing.T) {
+	storeInstance := setupTestStore(t)
+	hub := NewHub()
+	hub.SlowConsumer = SlowConsumerBlock
+	hub.SlowConsumerTimeout = time.Second
+	go hub.Run()
+	stopHubOnCleanup(t, hub)
+	bob := newSlowClient(t, hub, "bob")
+
+	// The hub's own frames never wait, even under the block policy
+	start := time.Now()
+	hub.Broadcast <- []byte(`{"type":"ack"}`)
+	hub.Register <- newTestClient(hub)
+	if waited := time.Since(start); waited > 500*time.Millisecond {
+		t.Errorf("expected the broadcast not to wait, waited %s", waited)
+	}
+
+	// Replies to bob's own frames are lost rather than replayed later
+	hub.SlowConsumerTimeout = 20 * time.Millisecond
+	bob.sendEvent(Event{Type: EventAck, ID: 2})
+	bob.sendError(CodeBadRequest, "Invalid chat message format")
+	if events, _ := storeInstance.TakePendingEvents("bob"); len(events) != 0 {
+		t.Errorf("expected nothing spilled, got %q", events)
+	}
+}
+
+func TestBackpressure_ConcurrentSendsAndUnregister(t *testing.T) {
+	setupTestStore(t)
+	for _, policy := range []string{SlowConsumerDrop, SlowConsumerDisconnect, SlowConsumerBlock} {
+		t.Run(policy, func(t *testing.T) {
+			hub := NewHub()
+			hub.SlowConsumer = policy
+			hub.SlowConsumerTimeout = time.Millisecond
+			go hub.Run()
+			stopHubOnCleanup(t, hub)
+
+			var clients []*Client
+			for i := 0; i < 10; i++ {
+				c := &Client{Send: make(chan []byte, 2), subscriptions: make(map[string]bool), hub: hub}
+				hub.Register <- c
+				hub.authenticate(c, "1", "bob")
+				clients = append(clients, c)
+			}
+
+			// Senders, broadcasts and unregistrations race; no frame may be
+			// sent on a closed queue and no queue closed twice
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 50; j++ {
+						hub.SendToUser("bob", []byte(`{"type":"ack"}`))
+					}
+				}()
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 20; j++ {
+						hub.Broadcast <- []byte(`{"type":"ack"}`)
+					}
+				}()
+			}
+			for _, c := range clients {
+				wg.Add(1)
+				go func(c *Client) {
+					defer wg.Done()
+					hub.Unregister <- c
+					hub.Unregister <- c
+					c.closeSend()
+				}(c)
+			}
+			wg.Wait()
+
+			for _, c := range clients {
+				for range c.Send {
+				}
+			}
+		})
+	}
+}
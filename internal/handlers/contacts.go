@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+// ContactsHandler manages the authenticated user's contact list.
+//
+//	GET    /contacts            contacts and pending requests
+//	POST   /contacts/:username  send a request, or accept one from :username
+//	DELETE /contacts/:username  remove a contact, or decline/cancel a request
+func ContactsHandler(storeInstance *store.Store, hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := authenticateRequest(r)
+		if err != nil {
+			writeError(w, CodeUnauthorized, "Unauthorized")
+			return
+		}
+		username := r.PathValue("username")
+		if username == "" {
+			contacts, err := storeInstance.ListContacts(claims.Username)
+			if err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(contacts)
+			return
+		}
+		other, ok := lookupOtherUser(storeInstance, w, claims.Username, username)
+		if !ok {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			// Requests from blocked users are accepted silently but never delivered
+			if blocked, err := storeInstance.IsBlocked(other, claims.Username); err != nil || blocked {
+				writeContactStatus(w, models.ContactOutgoing)
+				return
+			}
+			status, err := storeInstance.RequestContact(claims.Username, other)
+			if err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			peerStatus := models.ContactIncoming
+			if status == models.ContactAccepted {
+				peerStatus = models.ContactAccepted
+			}
+			notifyUser(hub, storeInstance, other, Event{Type: EventContact, Username: claims.Username, Status: peerStatus})
+			writeContactStatus(w, status)
+		case http.MethodDelete:
+			if err := storeInstance.RemoveContact(claims.Username, other); err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// BlocksHandler manages the authenticated user's block list.
+//
+//	GET    /blocks            blocked users
+//	POST   /blocks/:username  block :username
+//	DELETE /blocks/:username  unblock :username
+func BlocksHandler(storeInstance *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := authenticateRequest(r)
+		if err != nil {
+			writeError(w, CodeUnauthorized, "Unauthorized")
+			return
+		}
+		username := r.PathValue("username")
+		if username == "" {
+			blocks, err := storeInstance.ListBlocks(claims.Username)
+			if err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(blocks)
+			return
+		}
+		other, ok := lookupOtherUser(storeInstance, w, claims.Username, username)
+		if !ok {
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			err = storeInstance.Unblock(claims.Username, other)
+		} else {
+			err = storeInstance.Block(claims.Username, other)
+		}
+		if err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// lookupOtherUser resolves the username in a contact or block path, writing
+// an error response and returning false if it is unknown or the caller's own.
+func lookupOtherUser(storeInstance *store.Store, w http.ResponseWriter, self, username string) (string, bool) {
+	user, err := storeInstance.GetUserByUsername(username)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return "", false
+	}
+	if user == nil {
+		writeError(w, CodeNotFound, "User not found")
+		return "", false
+	}
+	if user.Username == self {
+		writeError(w, CodeBadRequest, "Cannot target yourself")
+		return "", false
+	}
+	return user.Username, true
+}
+
+// writeContactStatus reports the caller's relationship state after a request.
+func writeContactStatus(w http.ResponseWriter, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+func TestContactsAndBlocksHandlers(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	for _, name := range []string{"alice", "bob", "mallory"} {
+		if err := storeInstance.CreateUser(&models.User{Username: name, Password: "x", PublicKey: "k"}); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	do := func(handler http.HandlerFunc, method, path, user string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+issueTestToken(t, user))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	contacts := routed(ContactsHandler(storeInstance, nil), "GET /contacts", "POST /contacts/{username}", "DELETE /contacts/{username}")
+	blocks := routed(BlocksHandler(storeInstance), "GET /blocks", "POST /blocks/{username}", "DELETE /blocks/{username}")
+
+	if w := do(contacts, http.MethodPost, "/contacts/Bob", "alice"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 requesting contact, got %d: %s", w.Code, w.Body.String())
+	}
+	w := do(contacts, http.MethodPost, "/contacts/alice", "bob")
+	var resp map[string]string
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp["status"] != models.ContactAccepted {
+		t.Fatalf("expected accepted contact, got %v", resp)
+	}
+	if w := do(contacts, http.MethodPost, "/contacts/nobody", "alice"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown user, got %d", w.Code)
+	}
+
+	// Requests from blocked users look successful but never arrive
+	if w := do(blocks, http.MethodPost, "/blocks/mallory", "alice"); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 blocking, got %d", w.Code)
+	}
+	if w := do(contacts, http.MethodPost, "/contacts/alice", "mallory"); w.Code != http.StatusOK {
+		t.Errorf("expected blocked request to look successful, got %d", w.Code)
+	}
+	var list []models.Contact
+	json.NewDecoder(do(contacts, http.MethodGet, "/contacts", "alice").Body).Decode(&list)
+	if len(list) != 1 || list[0].Username != "bob" {
+		t.Errorf("expected only bob in alice's contacts, got %+v", list)
+	}
+
+	if canMessage(storeInstance, "mallory", "alice") {
+		t.Error("blocked sender should not be able to message")
+	}
+	if !canMessage(storeInstance, "bob", "alice") || canMessage(storeInstance, "bob", "nobody") {
+		t.Error("unexpected canMessage result")
+	}
+}
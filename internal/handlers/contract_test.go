@@ -0,0 +1,656 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/apispec"
+	"github.com/edpsouza/chatterbox/internal/blobstore"
+	"github.com/edpsouza/chatterbox/internal/totp"
+)
+
+// apiDoc is a decoded OpenAPI or AsyncAPI document.
+type apiDoc map[string]any
+
+func loadDoc(t *testing.T, raw []byte) apiDoc {
+	t.Helper()
+	var doc apiDoc
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatalf("invalid API document: %v", err)
+	}
+	return doc
+}
+
+// resolve follows local "$ref" pointers such as "#/components/schemas/Error".
+func (d apiDoc) resolve(node map[string]any) map[string]any {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		var cur any = map[string]any(d)
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			m, _ := cur.(map[string]any)
+			cur = m[part]
+		}
+		next, ok := cur.(map[string]any)
+		if !ok {
+			panic("unresolvable $ref " + ref)
+		}
+		node = next
+	}
+}
+
+// validate returns every way v violates schema. Objects may only carry the
+// properties their schema declares, so the documents must list every field
+// the server sends.
+func (d apiDoc) validate(schema map[string]any, v any, at string) []string {
+	schema = d.resolve(schema)
+	if alts, ok := schema["oneOf"].([]any); ok {
+		matches := 0
+		for _, alt := range alts {
+			if len(d.validate(alt.(map[string]any), v, at)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return []string{fmt.Sprintf("%s: matches %d of the oneOf schemas", at, matches)}
+		}
+		return nil
+	}
+	if v == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return []string{at + ": unexpected null"}
+	}
+	var errs []string
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected object, got %T", at, v)}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required %q", at, name))
+			}
+		}
+		for name, value := range obj {
+			if prop, ok := props[name].(map[string]any); ok {
+				errs = append(errs, d.validate(prop, value, at+"."+name)...)
+			} else if extra, ok := schema["additionalProperties"].(map[string]any); ok {
+				errs = append(errs, d.validate(extra, value, at+"."+name)...)
+			} else if schema["additionalProperties"] != true {
+				errs = append(errs, fmt.Sprintf("%s: undocumented property %q", at, name))
+			}
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected array, got %T", at, v)}
+		}
+		for i, item := range items {
+			errs = append(errs, d.validate(schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return []string{fmt.Sprintf("%s: expected string, got %T", at, v)}
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != math.Trunc(n) {
+			return []string{fmt.Sprintf("%s: expected integer, got %v", at, v)}
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return []string{fmt.Sprintf("%s: expected boolean, got %T", at, v)}
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			found = found || e == v
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: %v is not one of %v", at, v, enum))
+		}
+	}
+	return errs
+}
+
+// routerPatterns returns the "METHOD /path" patterns NewRouter registers,
+// read from the source so new routes can't be missed.
+func routerPatterns(t *testing.T) []string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "router.go", nil, 0)
+	if err != nil {
+		t.Fatalf("failed to parse router.go: %v", err)
+	}
+	var patterns []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "HandleFunc" {
+			return true
+		}
+		if x, ok := sel.X.(*ast.Ident); !ok || x.Name != "api" {
+			return true
+		}
+		if lit, ok := call.Args[0].(*ast.BasicLit); ok {
+			pattern, _ := strconv.Unquote(lit.Value)
+			patterns = append(patterns, pattern)
+		}
+		return true
+	})
+	return patterns
+}
+
+// operations lists the "METHOD /path" pairs the OpenAPI document describes.
+func (d apiDoc) operations() []string {
+	var ops []string
+	for path, item := range d["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			ops = append(ops, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// checkRefs fails on "$ref" pointers that lead nowhere.
+func (d apiDoc) checkRefs(t *testing.T, node any) {
+	t.Helper()
+	switch n := node.(type) {
+	case map[string]any:
+		if ref, ok := n["$ref"].(string); ok {
+			func() {
+				defer func() {
+					if recover() != nil {
+						t.Errorf("unresolvable $ref %s", ref)
+					}
+				}()
+				d.resolve(n)
+			}()
+		}
+		for _, v := range n {
+			d.checkRefs(t, v)
+		}
+	case []any:
+		for _, v := range n {
+			d.checkRefs(t, v)
+		}
+	}
+}
+
+func TestOpenAPIDescribesEveryRoute(t *testing.T) {
+	doc := loadDoc(t, apispec.OpenAPI)
+	doc.checkRefs(t, map[string]any(doc))
+	documented := map[string]bool{}
+	for _, op := range doc.operations() {
+		documented[op] = true
+	}
+	registered := map[string]bool{}
+	for _, pattern := range routerPatterns(t) {
+		registered[pattern] = true
+		if !documented[pattern] {
+			t.Errorf("route %s is missing from openapi.json", pattern)
+		}
+	}
+	if len(registered) < 10 {
+		t.Fatalf("found only %d routes in router.go", len(registered))
+	}
+	for op := range documented {
+		if !registered[op] {
+			t.Errorf("openapi.json documents %s, which the router does not serve", op)
+		}
+	}
+
+	// Every security requirement names a scheme the server implements
+	schemes := doc["components"].(map[string]any)["securitySchemes"].(map[string]any)
+	for path, item := range doc["paths"].(map[string]any) {
+		for method, op := range item.(map[string]any) {
+			security, _ := op.(map[string]any)["security"].([]any)
+			for _, requirement := range security {
+				for name := range requirement.(map[string]any) {
+					if _, ok := schemes[name]; !ok {
+						t.Errorf("%s %s requires undeclared security scheme %s", strings.ToUpper(method), path, name)
+					}
+				}
+			}
+		}
+	}
+	for name, scheme := range schemes {
+		if scheme.(map[string]any)["type"] != "http" {
+			t.Errorf("security scheme %s is not bearer authentication", name)
+		}
+	}
+
+	// Every error code the server can send is in the Error schema
+	codes := map[string]bool{}
+	for _, c := range doc.resolve(map[string]any{"$ref": "#/components/schemas/Error"})["properties"].(map[string]any)["code"].(map[string]any)["enum"].([]any) {
+		codes[c.(string)] = true
+	}
+	for code := range errorStatus {
+		if !codes[code] {
+			t.Errorf("error code %s is missing from the Error schema", code)
+		}
+	}
+}
+
+// contractChecker sends requests through the router and checks each response
+// against the operation the OpenAPI document describes for it.
+type contractChecker struct {
+	t         *testing.T
+	doc       apiDoc
+	router    http.Handler
+	exercised map[string]bool
+}
+
+// template finds the documented path matching path, preferring literal
+// segments over parameters.
+func (c *contractChecker) template(path string) string {
+	segments := strings.Split(path, "/")
+	best, bestLiterals := "", -1
+	for tmpl := range c.doc["paths"].(map[string]any) {
+		parts := strings.Split(tmpl, "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		literals := 0
+		for i, part := range parts {
+			if strings.HasPrefix(part, "{") {
+				continue
+			}
+			if part != segments[i] {
+				literals = -1
+				break
+			}
+			literals++
+		}
+		if literals > bestLiterals {
+			best, bestLiterals = tmpl, literals
+		}
+	}
+	return best
+}
+
+// do sends a request to /v1+target and fails unless the response has status
+// want and matches the document.
+func (c *contractChecker) do(method, target, auth, body string, want int, headers ...string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	req := httptest.NewRequest(method, APIPrefix+target, strings.NewReader(body))
+	if auth != "" {
+		req.Header.Set("Authorization", "Bearer "+auth)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	c.router.ServeHTTP(w, req)
+	if w.Code != want {
+		c.t.Fatalf("%s %s: expected %d, got %d: %s", method, target, want, w.Code, w.Body.String())
+	}
+
+	path, _, _ := strings.Cut(target, "?")
+	tmpl := c.template(path)
+	op, ok := c.doc["paths"].(map[string]any)[tmpl].(map[string]any)[strings.ToLower(method)].(map[string]any)
+	if !ok {
+		c.t.Errorf("%s %s: no operation in openapi.json", method, target)
+		return w
+	}
+	c.exercised[method+" "+tmpl] = true
+	responses := op["responses"].(map[string]any)
+	resp, ok := responses[strconv.Itoa(w.Code)].(map[string]any)
+	if !ok && w.Code >= 500 {
+		resp, ok = responses["default"].(map[string]any)
+	}
+	if !ok {
+		c.t.Errorf("%s %s: status %d is not documented", method, tmpl, w.Code)
+		return w
+	}
+	c.checkBody(method+" "+tmpl, c.doc.resolve(resp), w)
+	return w
+}
+
+// checkBody checks the response body against the documented content.
+func (c *contractChecker) checkBody(name string, resp map[string]any, w *httptest.ResponseRecorder) {
+	c.t.Helper()
+	content, _ := resp["content"].(map[string]any)
+	if len(content) == 0 {
+		if w.Body.Len() > 0 {
+			c.t.Errorf("%s %d: undocumented body %q", name, w.Code, w.Body.String())
+		}
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	media, ok := content[mediaType].(map[string]any)
+	if !ok {
+		c.t.Errorf("%s %d: undocumented content type %q", name, w.Code, mediaType)
+		return
+	}
+	if mediaType != "application/json" {
+		return
+	}
+	var v any
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		c.t.Errorf("%s %d: invalid JSON: %v", name, w.Code, err)
+		return
+	}
+	for _, err := range c.doc.validate(media["schema"].(map[string]any), v, "body") {
+		c.t.Errorf("%s %d: %s", name, w.Code, err)
+	}
+}
+
+// decode unmarshals a JSON response body into v.
+func decode(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("failed to decode %q: %v", w.Body.String(), err)
+	}
+}
+
+func TestOpenAPIContract(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	blobs, err := blobstore.NewFS(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create blob store: %v", err)
+	}
+	hub := NewHub()
+	go hub.Run()
+	c := &contractChecker{t: t, doc: loadDoc(t, apispec.OpenAPI), exercised: map[string]bool{}, router: NewRouter(RouterConfig{
+		Store:         storeInstance,
+		Hub:           hub,
+		Blobs:         blobs,
+		AdminToken:    "admin-secret",
+		SearchLimiter: NewRateLimiter(0, 0),
+		Attachments:   AttachmentConfig{MaxSize: 1024, Quota: 2048, TokenTTL: time.Minute},
+	})}
+
+	// Accounts and tokens
+	for _, name := range []string{"alice", "bob", "carol"} {
+		c.do("POST", "/register", "", `{"username":"`+name+`","password":"secret123","public_key":"key-`+name+`"}`, http.StatusCreated)
+	}
+	c.do("POST", "/register", "", `{"username":"alice","password":"secret123","public_key":"k"}`, http.StatusConflict)
+	c.do("POST", "/register", "", `{}`, http.StatusBadRequest)
+	c.do("POST", "/login", "", `{"username":"alice","password":"wrong"}`, http.StatusUnauthorized)
+	var tokens TokenResponse
+	decode(t, c.do("POST", "/login", "", `{"username":"alice","password":"secret123","device_name":"laptop"}`, http.StatusOK), &tokens)
+	decode(t, c.do("POST", "/token/refresh", "", `{"refresh_token":"`+tokens.RefreshToken+`"}`, http.StatusOK), &tokens)
+	c.do("POST", "/token/refresh", "", `{"refresh_token":"bogus"}`, http.StatusUnauthorized)
+	alice := tokens.Token
+	var bobTokens TokenResponse
+	decode(t, c.do("POST", "/login", "", `{"username":"bob","password":"secret123"}`, http.StatusOK), &bobTokens)
+	bob := bobTokens.Token
+
+	// bob keeps a connection open; the events it receives are checked
+	// against the AsyncAPI document at the end
+	bobUser, _ := storeInstance.GetUserByUsername("bob")
+	bobConn := &Client{Send: make(chan []byte, 256), subscriptions: make(map[string]bool)}
+	hub.Register <- bobConn
+	hub.authenticate(bobConn, strconv.FormatInt(bobUser.ID, 10), "bob")
+	for _, event := range hub.subscribe(bobConn, []string{"alice"}) {
+		bobConn.sendEvent(event)
+	}
+
+	// Users and profiles
+	c.do("GET", "/users/bob/public_key", alice, "", http.StatusOK)
+	c.do("GET", "/users/bob/public_key", "", "", http.StatusUnauthorized)
+	c.do("GET", "/users/nobody/public_key", alice, "", http.StatusNotFound)
+	c.do("GET", "/users/bob/presence", alice, "", http.StatusOK)
+	c.do("GET", "/users/bob/presence", "", "", http.StatusUnauthorized)
+	c.do("GET", "/users/nobody/presence", alice, "", http.StatusNotFound)
+	c.do("GET", "/users/bob/profile", alice, "", http.StatusOK)
+	c.do("GET", "/users/bob/profile", "", "", http.StatusUnauthorized)
+	c.do("GET", "/users?q=b&limit=1", alice, "", http.StatusOK)
+	c.do("GET", "/users", alice, "", http.StatusBadRequest)
+	c.do("GET", "/profile", alice, "", http.StatusOK)
+	c.do("GET", "/settings/privacy", alice, "", http.StatusOK)
+	c.do("PUT", "/settings/privacy", alice, `{"last_seen":"contacts"}`, http.StatusOK)
+	c.do("PATCH", "/settings/privacy", alice, `{"online_status":"bogus"}`, http.StatusBadRequest)
+
+	// Contacts and blocks
+	c.do("POST", "/contacts/bob", alice, "", http.StatusOK)
+	c.do("POST", "/contacts/alice", bob, "", http.StatusOK)
+	c.do("POST", "/contacts/alice", alice, "", http.StatusBadRequest)
+	c.do("POST", "/contacts/carol", alice, "", http.StatusOK)
+	c.do("GET", "/contacts", alice, "", http.StatusOK)
+	c.do("DELETE", "/contacts/carol", alice, "", http.StatusNoContent)
+	c.do("DELETE", "/contacts/nobody", alice, "", http.StatusNotFound)
+	c.do("POST", "/blocks/carol", alice, "", http.StatusNoContent)
+	c.do("GET", "/blocks", alice, "", http.StatusOK)
+	c.do("DELETE", "/blocks/carol", alice, "", http.StatusNoContent)
+	c.do("PUT", "/profile", alice, `{"display_name":"Alice","bio":"hi"}`, http.StatusOK)
+	c.do("PATCH", "/profile", alice, `{"avatar":"missing"}`, http.StatusBadRequest)
+
+	// Messages
+	aliceUser, _ := storeInstance.GetUserByUsername("alice")
+	id, err := storeInstance.CreateMessage(aliceUser.ID, "alice", "bob", "hello")
+	if err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+	msg := "/messages/bob/" + strconv.FormatInt(id, 10)
+	c.do("GET", "/messages/bob", alice, "", http.StatusOK)
+	c.do("GET", "/messages/bob?thread=x", alice, "", http.StatusBadRequest)
+	c.do("GET", "/messages/bob", "", "", http.StatusUnauthorized)
+	c.do("PATCH", msg, alice, `{"ciphertext":"edited"}`, http.StatusOK)
+	c.do("PUT", msg, alice, `{}`, http.StatusBadRequest)
+	c.do("PATCH", msg, bob, `{"ciphertext":"x"}`, http.StatusNotFound)
+	c.do("DELETE", msg, alice, "", http.StatusOK)
+	c.do("PUT", msg, alice, `{"ciphertext":"again"}`, http.StatusGone)
+	c.do("DELETE", "/messages/bob/x", alice, "", http.StatusBadRequest)
+	c.do("GET", "/conversations", alice, "", http.StatusOK)
+	c.do("POST", "/conversations/bob/read", alice, "", http.StatusNoContent)
+
+	// Attachments
+	var a struct {
+		ID string `json:"id"`
+	}
+	c.do("POST", "/attachments", alice, `{"size":4096}`, http.StatusRequestEntityTooLarge)
+	decode(t, c.do("POST", "/attachments", alice, `{"size":5}`, http.StatusCreated), &a)
+	c.do("HEAD", "/attachments/"+a.ID, alice, "", http.StatusOK)
+	c.do("HEAD", "/attachments/"+a.ID, bob, "", http.StatusNotFound)
+	c.do("PATCH", "/attachments/"+a.ID, alice, "hello", http.StatusNoContent, "Upload-Offset", "0")
+	c.do("PATCH", "/attachments/"+a.ID, alice, "!", http.StatusConflict, "Upload-Offset", "5")
+	var download struct {
+		Token string `json:"token"`
+	}
+	decode(t, c.do("POST", "/attachments/"+a.ID+"/token", alice, "", http.StatusOK), &download)
+	c.do("POST", "/attachments/"+a.ID+"/token", bob, "", http.StatusNotFound)
+	c.do("GET", "/attachments/"+a.ID+"?token="+download.Token, "", "", http.StatusOK)
+	c.do("GET", "/attachments/"+a.ID+"?token=bogus", "", "", http.StatusForbidden)
+
+	// Two-factor authentication
+	c.do("GET", "/2fa", alice, "", http.StatusOK)
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	decode(t, c.do("POST", "/2fa/enroll", alice, "", http.StatusOK), &enrollment)
+	c.do("POST", "/2fa/confirm", alice, `{}`, http.StatusBadRequest)
+	code, _ := totp.Code(enrollment.Secret, totp.Step(clock()))
+	var recovery struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decode(t, c.do("POST", "/2fa/confirm", alice, `{"code":"`+code+`"}`, http.StatusOK), &recovery)
+	c.do("POST", "/2fa/enroll", alice, "", http.StatusConflict)
+	c.do("POST", "/2fa/bogus", alice, `{"code":"1"}`, http.StatusNotFound)
+	var challenge TwoFactorChallenge
+	decode(t, c.do("POST", "/login", "", `{"username":"alice","password":"secret123"}`, http.StatusUnauthorized), &challenge)
+	c.do("POST", "/login/2fa", "", `{"challenge":"`+challenge.Challenge+`","code":"`+recovery.RecoveryCodes[0]+`"}`, http.StatusOK)
+	c.do("POST", "/login/2fa", "", `{"challenge":"bogus","code":"1"}`, http.StatusUnauthorized)
+	decode(t, c.do("POST", "/2fa/recovery-codes", alice, `{"code":"`+recovery.RecoveryCodes[1]+`"}`, http.StatusOK), &recovery)
+	c.do("POST", "/2fa/disable", alice, `{"code":"`+recovery.RecoveryCodes[0]+`","password":"wrong"}`, http.StatusUnauthorized)
+	c.do("POST", "/2fa/disable", alice, `{"code":"`+recovery.RecoveryCodes[0]+`","password":"secret123"}`, http.StatusNoContent)
+
+	// Sessions and passwords
+	var sessions []struct {
+		ID string `json:"id"`
+	}
+	decode(t, c.do("GET", "/sessions", alice, "", http.StatusOK), &sessions)
+	c.do("DELETE", "/sessions/nope", alice, "", http.StatusNotFound)
+	c.do("DELETE", "/sessions", alice, "", http.StatusNoContent)
+	c.do("POST", "/password", alice, `{"current_password":"secret123","new_password":"short"}`, http.StatusBadRequest)
+	c.do("POST", "/password", alice, `{"current_password":"secret123","new_password":"secret456"}`, http.StatusNoContent)
+	c.do("POST", "/password", "", `{}`, http.StatusUnauthorized)
+	c.do("POST", "/logout", bob, "", http.StatusNoContent)
+	c.do("POST", "/logout", bob, "", http.StatusUnauthorized)
+
+	// Admin endpoints
+	var reset struct {
+		Token string `json:"token"`
+	}
+	c.do("POST", "/admin/password-reset", "", `{"username":"carol"}`, http.StatusUnauthorized)
+	c.do("POST", "/admin/password-reset", "admin-secret", `{"username":"nobody"}`, http.StatusNotFound)
+	decode(t, c.do("POST", "/admin/password-reset", "admin-secret", `{"username":"carol"}`, http.StatusCreated), &reset)
+	c.do("POST", "/password/reset", "", `{"token":"`+reset.Token+`","new_password":"secret456"}`, http.StatusNoContent)
+	c.do("POST", "/password/reset", "", `{"token":"`+reset.Token+`","new_password":"secret456"}`, http.StatusBadRequest)
+	c.do("POST", "/admin/unlock", "admin-secret", `{"username":"carol"}`, http.StatusNoContent)
+	c.do("POST", "/admin/unlock", "admin-secret", `{}`, http.StatusBadRequest)
+	c.do("GET", "/admin/username-collisions", "admin-secret", "", http.StatusOK)
+	c.do("GET", "/admin/metrics", "", "", http.StatusUnauthorized)
+	c.do("GET", "/admin/metrics", "admin-secret", "", http.StatusOK)
+
+	// Account
+	c.do("GET", "/account/export", alice, "", http.StatusOK)
+	c.do("DELETE", "/account", alice, `{}`, http.StatusBadRequest)
+	c.do("DELETE", "/account", alice, `{"password":"secret456"}`, http.StatusNoContent)
+	c.do("GET", "/account/export", "", "", http.StatusUnauthorized)
+
+	// The documents themselves
+	c.do("GET", "/openapi.json", "", "", http.StatusOK)
+	c.do("GET", "/asyncapi.json", "", "", http.StatusOK)
+
+	// Requests the router turns away still get documented error bodies
+	errorResponse := map[string]any{"content": map[string]any{"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}}}}
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/v1/nope", nil),
+		httptest.NewRequest(http.MethodDelete, "/v1/register", nil),
+	} {
+		w := httptest.NewRecorder()
+		c.router.ServeHTTP(w, req)
+		c.checkBody(req.Method+" "+req.URL.Path, errorResponse, w)
+	}
+
+	for _, op := range c.doc.operations() {
+		if !c.exercised[op] && op != "GET /ws" {
+			t.Errorf("%s is not exercised by the contract test", op)
+		}
+	}
+
+	// bob saw profile, contact, edit, delete, presence and account events
+	async := loadDoc(t, apispec.AsyncAPI)
+	seen := map[string]bool{}
+	for done := false; !done; {
+		select {
+		case frame, ok := <-bobConn.Send:
+			if !ok {
+				done = true
+				break
+			}
+			var v any = string(frame)
+			if json.Valid(frame) {
+				json.Unmarshal(frame, &v)
+				seen[v.(map[string]any)["type"].(string)] = true
+			}
+			for _, err := range async.validateFrame("subscribe", v) {
+				t.Errorf("server frame %s: %s", frame, err)
+			}
+		default:
+			done = true
+		}
+	}
+	for _, eventType := range []string{EventPresence, EventContact, EventProfile, EventEdit, EventDelete, EventAccountDeleted} {
+		if !seen[eventType] {
+			t.Errorf("expected a %s event for bob", eventType)
+		}
+	}
+}
+
+// validateFrame checks a frame against the messages of the /v1/ws channel
+// for direction, "publish" (client to server) or "subscribe".
+func (d apiDoc) validateFrame(direction string, frame any) []string {
+	channel := d["channels"].(map[string]any)["/v1/ws"].(map[string]any)
+	message := channel[direction].(map[string]any)["message"].(map[string]any)
+	var payloads []any
+	for _, m := range message["oneOf"].([]any) {
+		payloads = append(payloads, d.resolve(m.(map[string]any))["payload"])
+	}
+	return d.validate(map[string]any{"oneOf": payloads}, frame, "frame")
+}
+
+func TestAsyncAPIDescribesFrames(t *testing.T) {
+	doc := loadDoc(t, apispec.AsyncAPI)
+	doc.checkRefs(t, map[string]any(doc))
+
+	// Frames clients send, as the README documents them
+	for _, frame := range []string{
+		`{"token":"t"}`,
+		`{"username":"alice","password":"secret","code":"123456"}`,
+		`{"to":"bob","ciphertext":"..."}`,
+		`{"type":"message","to":"bob","ciphertext":"...","reply_to":41,"attachments":["a1"]}`,
+		`{"type":"edit","id":42,"ciphertext":"..."}`,
+		`{"type":"delete","id":42}`,
+		`{"type":"react","id":42,"ciphertext":"..."}`,
+		`{"type":"unreact","id":42}`,
+		`{"type":"subscribe","users":["bob"]}`,
+		`{"type":"unsubscribe","users":["bob"]}`,
+		`{"type":"heartbeat","state":"idle"}`,
+	} {
+		var v any
+		json.Unmarshal([]byte(frame), &v)
+		for _, err := range doc.validateFrame("publish", v) {
+			t.Errorf("client frame %s: %s", frame, err)
+		}
+	}
+
+	// The validator rejects what the document doesn't describe
+	for _, frame := range []any{
+		map[string]any{"type": "bogus"},
+		map[string]any{"type": "ack", "id": 1, "extra": true},
+		map[string]any{"type": "error", "code": "no_such_code", "message": "x"},
+		"Some text",
+	} {
+		if len(doc.validateFrame("subscribe", frame)) == 0 {
+			t.Errorf("expected %v to be rejected", frame)
+		}
+	}
+
+	// Frames the server builds outside the REST flows
+	c := &Client{Send: make(chan []byte, 8)}
+	c.sendEvent(Event{Type: EventAck, ID: 7})
+	c.sendEvent(Event{Type: EventMessage, ID: 7, From: "alice", To: "bob", Ciphertext: "...", ReplyTo: 3, Attachments: []string{"a1"}})
+	c.sendEvent(Event{Type: EventReact, ID: 7, From: "bob", Ciphertext: "..."})
+	c.sendEvent(Event{Type: EventUnreact, ID: 7, From: "bob"})
+	c.sendError(CodeUndeliverable, "Message could not be delivered")
+	c.sendEvent(Event{Type: EventGoingAway, RetryAfter: 5})
+	c.sendText("Authenticated")
+	c.sendError(CodeRecipientOffline, "Recipient not connected")
+	for i := 0; i < 8; i++ {
+		frame := <-c.Send
+		var v any = string(frame)
+		if json.Valid(frame) {
+			json.Unmarshal(frame, &v)
+		}
+		for _, err := range doc.validateFrame("subscribe", v) {
+			t.Errorf("server frame %s: %s", frame, err)
+		}
+	}
+}
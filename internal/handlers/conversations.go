@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+// ConversationsHandler serves the authenticated user's conversation list.
+//
+//	GET  /conversations              all conversations, most recent first
+//	POST /conversations/:peer/read   reset the unread count for one conversation
+func ConversationsHandler(storeInstance *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := authenticateRequest(r)
+		if err != nil {
+			writeError(w, CodeUnauthorized, "Unauthorized")
+			return
+		}
+		peer := r.PathValue("peer")
+		if peer == "" {
+			conversations, err := storeInstance.ListConversations(claims.Username)
+			if err != nil {
+				writeError(w, CodeInternal, "Failed to fetch conversations")
+				return
+			}
+			for i := range conversations {
+				c := &conversations[i]
+				c.PeerStatus, c.PeerLastSeen = filterPresence(storeInstance, c.Peer, claims.Username, c.PeerStatus, c.PeerLastSeen)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(conversations)
+			return
+		}
+		if err := storeInstance.MarkConversationRead(claims.Username, resolveUsername(storeInstance, peer)); err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
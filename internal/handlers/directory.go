@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+// Directory search paging limits.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	maxSearchQuery     = 64
+)
+
+// UserSearchResponse is one page of directory search results.
+type UserSearchResponse struct {
+	Users      []models.UserSummary `json:"users"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// UserSearchHandler serves GET /users?q=prefix&limit=N&cursor=username,
+// a prefix search over usernames and display names. Requests are
+// rate-limited per authenticated user.
+func UserSearchHandler(storeInstance *store.Store, limiter *RateLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeError(w, CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		claims, err := authenticateRequest(r)
+		if err != nil {
+			writeError(w, CodeUnauthorized, "Unauthorized")
+			return
+		}
+		if ok, retryAfter := limiter.Allow(claims.Username); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeError(w, CodeRateLimited, "Too many requests")
+			return
+		}
+
+		query := r.URL.Query()
+		q := strings.TrimSpace(query.Get("q"))
+		if q == "" || utf8.RuneCountInString(q) > maxSearchQuery {
+			writeError(w, CodeBadRequest, "Query parameter q required (up to 64 characters)")
+			return
+		}
+		limit := defaultSearchLimit
+		if v := query.Get("limit"); v != "" {
+			limit, err = strconv.Atoi(v)
+			if err != nil || limit <= 0 {
+				writeError(w, CodeBadRequest, "Invalid limit")
+				return
+			}
+			limit = min(limit, maxSearchLimit)
+		}
+
+		// Fetch one extra row to know whether there is another page
+		users, err := storeInstance.SearchUsers(q, claims.Username, query.Get("cursor"), limit+1)
+		if err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		resp := UserSearchResponse{Users: users}
+		if len(users) > limit {
+			resp.Users = users[:limit]
+			resp.NextCursor = users[limit-1].Username
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+func TestUserSearchHandler(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	for _, name := range []string{"carol", "carla", "carmen", "dave"} {
+		if err := storeInstance.CreateUser(&models.User{Username: name, Password: "x", PublicKey: "k"}); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	limiter := NewRateLimiter(3, time.Minute)
+	handler := UserSearchHandler(storeInstance, limiter)
+	search := func(query string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/users?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+issueTestToken(t, "dave"))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	var page UserSearchResponse
+	w := search("q=car&limit=2")
+	json.NewDecoder(w.Body).Decode(&page)
+	if w.Code != http.StatusOK || len(page.Users) != 2 || page.NextCursor != "carmen" {
+		t.Fatalf("unexpected first page (%d): %+v", w.Code, page)
+	}
+	cursor := page.NextCursor
+	page = UserSearchResponse{}
+	json.NewDecoder(search("q=car&limit=2&cursor=" + cursor).Body).Decode(&page)
+	if len(page.Users) != 1 || page.Users[0].Username != "carol" || page.NextCursor != "" {
+		t.Errorf("unexpected last page: %+v", page)
+	}
+
+	if w := search("q=car"); w.Code != http.StatusOK {
+		t.Fatalf("expected third request to be allowed, got %d", w.Code)
+	}
+	if w := search("q=car"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 429 with Retry-After, got %d", w.Code)
+	}
+}
+
+func TestRateLimiter_WindowResets(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(1, time.Minute)
+	limiter.now = func() time.Time { return now }
+	if ok, _ := limiter.Allow("alice"); !ok {
+		t.Fatal("first request should be allowed")
+	}
+	if ok, wait := limiter.Allow("alice"); ok || wait != time.Minute {
+		t.Fatalf("expected second request to wait a minute, got %v %v", ok, wait)
+	}
+	if ok, _ := limiter.Allow("bob"); !ok {
+		t.Error("limits are per key")
+	}
+	now = now.Add(time.Minute)
+	if ok, _ := limiter.Allow("alice"); !ok {
+		t.Error("expected window to reset")
+	}
+}
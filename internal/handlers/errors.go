@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+// Error codes. REST error bodies and WebSocket error frames share this
+// catalog; each code always comes with the same HTTP status.
+const (
+	CodeBadRequest         = "bad_request"
+	CodeInvalidUsername    = "invalid_username"
+	CodeUnauthorized       = "unauthorized"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeTwoFactorRequired  = "two_factor_required"
+	CodeInvalidToken       = "invalid_token"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeUsernameTaken      = "username_taken"
+	CodeGone               = "gone"
+	CodePayloadTooLarge    = "payload_too_large"
+	CodeRateLimited        = "rate_limited"
+	CodeUndeliverable      = "undeliverable"
+	CodeRecipientOffline   = "recipient_offline"
+	CodeInternal           = "internal_error"
+	CodeUnavailable        = "unavailable"
+)
+
+// errorStatus is the HTTP status of each error code.
+var errorStatus = map[string]int{
+	CodeBadRequest:         http.StatusBadRequest,
+	CodeInvalidUsername:    http.StatusBadRequest,
+	CodeUnauthorized:       http.StatusUnauthorized,
+	CodeInvalidCredentials: http.StatusUnauthorized,
+	CodeTwoFactorRequired:  http.StatusUnauthorized,
+	CodeInvalidToken:       http.StatusUnauthorized,
+	CodeForbidden:          http.StatusForbidden,
+	CodeNotFound:           http.StatusNotFound,
+	CodeMethodNotAllowed:   http.StatusMethodNotAllowed,
+	CodeConflict:           http.StatusConflict,
+	CodeUsernameTaken:      http.StatusConflict,
+	CodeGone:               http.StatusGone,
+	CodePayloadTooLarge:    http.StatusRequestEntityTooLarge,
+	CodeRateLimited:        http.StatusTooManyRequests,
+	CodeUndeliverable:      http.StatusUnprocessableEntity,
+	CodeRecipientOffline:   http.StatusNotFound,
+	CodeInternal:           http.StatusInternalServerError,
+	CodeUnavailable:        http.StatusServiceUnavailable,
+}
+
+// ErrorResponse is the JSON body of every error response.
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// requestIDHeader carries the request ID; a client-supplied one is kept.
+const requestIDHeader = "X-Request-ID"
+
+// withRequestID tags each request and its response with a request ID, so
+// error bodies can be matched with server logs.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 64 {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newErrorResponse builds the body for code, picking up the request ID
+// that withRequestID put on the response.
+func newErrorResponse(w http.ResponseWriter, code, message string) ErrorResponse {
+	return ErrorResponse{Code: code, Message: message, RequestID: w.Header().Get(requestIDHeader)}
+}
+
+// writeError writes a JSON error response with the status of code.
+func writeError(w http.ResponseWriter, code, message string) {
+	writeJSON(w, errorStatus[code], newErrorResponse(w, code, message))
+}
+
+// storeErrorCode maps an error from the store to an error code and a
+// message that is safe to show to clients.
+func storeErrorCode(err error) (code, message string) {
+	switch {
+	case errors.Is(err, store.ErrUsernameTaken), errors.Is(err, store.ErrUsernameConfusable):
+		return CodeUsernameTaken, err.Error()
+	case errors.Is(err, store.ErrInvalidRefreshToken):
+		return CodeInvalidToken, err.Error()
+	case errors.Is(err, store.ErrQuotaExceeded):
+		return CodePayloadTooLarge, err.Error()
+	case errors.Is(err, store.ErrMessageDeleted):
+		return CodeGone, err.Error()
+	case errors.Is(err, store.ErrNotFound):
+		return CodeNotFound, err.Error()
+	case errors.Is(err, store.ErrForbidden):
+		return CodeForbidden, err.Error()
+	case errors.Is(err, store.ErrConflict):
+		return CodeConflict, err.Error()
+	case errors.Is(err, store.ErrInvalid):
+		return CodeBadRequest, err.Error()
+	default:
+		log.Printf("Internal error: %v", err)
+		return CodeInternal, "Internal server error"
+	}
+}
+
+// writeStoreError answers a request that failed in the store.
+func writeStoreError(w http.ResponseWriter, err error) {
+	code, message := storeErrorCode(err)
+	writeError(w, code, message)
+}
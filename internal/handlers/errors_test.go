@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+func TestStoreErrorCodes(t *testing.T) {
+	cases := []struct {
+		err    error
+		code   string
+		status int
+	}{
+		{store.ErrUsernameTaken, CodeUsernameTaken, http.StatusConflict},
+		{store.ErrUserNotFound, CodeNotFound, http.StatusNotFound},
+		{store.ErrMessageNotFound, CodeNotFound, http.StatusNotFound},
+		{store.ErrEditWindowExpired, CodeForbidden, http.StatusForbidden},
+		{store.ErrMessageDeleted, CodeGone, http.StatusGone},
+		{store.ErrQuotaExceeded, CodePayloadTooLarge, http.StatusRequestEntityTooLarge},
+		{store.ErrInvalidRefreshToken, CodeInvalidToken, http.StatusUnauthorized},
+		{store.ErrInvalidReply, CodeBadRequest, http.StatusBadRequest},
+		{errors.New("disk I/O error"), CodeInternal, http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		w.Header().Set(requestIDHeader, "req-1")
+		writeStoreError(w, tc.err)
+
+		var body ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("%v: failed to decode body: %v", tc.err, err)
+		}
+		if w.Code != tc.status || body.Code != tc.code || body.RequestID != "req-1" {
+			t.Errorf("%v: expected %d %s, got %d %+v", tc.err, tc.status, tc.code, w.Code, body)
+		}
+		if tc.code == CodeInternal && body.Message == tc.err.Error() {
+			t.Errorf("internal errors must not leak their cause, got %q", body.Message)
+		}
+	}
+	for code := range errorStatus {
+		if errorStatus[code] == 0 {
+			t.Errorf("code %s has no status", code)
+		}
+	}
+}
+
+func TestWebSocketErrorFrames(t *testing.T) {
+	c := &Client{Send: make(chan []byte, 1)}
+	c.sendStoreError(store.ErrMessageNotFound)
+	if event := nextEvent(t, c); event.Type != EventError || event.Code != CodeNotFound || event.Message != store.ErrMessageNotFound.Error() {
+		t.Errorf("expected a not_found error event, got %+v", event)
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+// WebSocket event types pushed from the server to clients.
+const (
+	EventMessage = "message"
+	EventAck     = "ack"
+	EventEdit    = "edit"
+	EventDelete  = "delete"
+	EventReact   = "react"
+	EventUnreact = "unreact"
+
+	EventPresence    = "presence"
+	EventSubscribe   = "subscribe"
+	EventUnsubscribe = "unsubscribe"
+	EventHeartbeat   = "heartbeat"
+
+	EventContact        = "contact"
+	EventProfile        = "profile"
+	EventAccountDeleted = "account_deleted"
+
+	EventError     = "error"
+	EventGoingAway = "going_away"
+)
+
+// Event is a JSON frame pushed to WebSocket clients.
+type Event struct {
+	Type       string `json:"type"`
+	ID         int64  `json:"id,omitempty"`
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	ReplyTo    int64  `json:"reply_to,omitempty"`
+
+	Attachments []string `json:"attachments,omitempty"`
+
+	// Presence events
+	Username string `json:"username,omitempty"`
+	Status   string `json:"status,omitempty"`
+	LastSeen string `json:"last_seen,omitempty"`
+
+	// Error events carry a code from the REST error catalog
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// Going-away events tell clients when to reconnect, in seconds
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// messageEvent builds the edit or delete event describing m.
+func messageEvent(eventType string, m *models.Message) Event {
+	return Event{
+		Type:       eventType,
+		ID:         m.ID,
+		From:       m.Username,
+		To:         m.Recipient,
+		Ciphertext: m.Content,
+		Version:    m.Version,
+		ReplyTo:    m.ReplyTo,
+
+		Attachments: m.Attachments,
+	}
+}
+
+// SendToUser queues payload on every authenticated connection of username.
+// It reports whether at least one connection received it.
+func (h *Hub) SendToUser(username string, payload []byte) bool {
+	delivered, _ := h.deliver(username, payload)
+	return delivered
+}
+
+// deliver offers payload to every authenticated connection of username. It
+// reports whether any connection queued it, and whether it was spilled to
+// the offline queue because a connection was too slow to take it.
+func (h *Hub) deliver(username string, payload []byte) (delivered, spilled bool) {
+	h.mu.Lock()
+	var targets []*Client
+	for client := range h.Clients {
+		if client.Authenticated && client.Username == username {
+			targets = append(targets, client)
+		}
+	}
+	h.mu.Unlock()
+	slow := false
+	for _, client := range targets {
+		switch client.offer(payload) {
+		case sendQueued:
+			delivered = true
+		case sendDropped:
+			slow = true
+		}
+	}
+	// The slow device gets the frame when it next connects; other devices
+	// may then see it twice
+	if slow {
+		spilled = spill(username, payload)
+	}
+	return delivered, spilled
+}
+
+// notifyUser pushes an event to username, queueing it in the store when the
+// user has no active connection.
+func notifyUser(hub *Hub, storeInstance *store.Store, username string, event Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	if hub != nil {
+		if delivered, spilled := hub.deliver(username, payload); delivered || spilled {
+			return
+		}
+	}
+	if storeInstance == nil {
+		return
+	}
+	if err := storeInstance.QueueEvent(username, payload); err != nil {
+		log.Printf("Failed to queue %s event for %s: %v", event.Type, username, err)
+	}
+}
+
+// notifyContacts sends event to each of username's accepted contacts.
+func notifyContacts(storeInstance *store.Store, hub *Hub, username string, event Event) {
+	contacts, err := storeInstance.ListContacts(username)
+	if err != nil {
+		return
+	}
+	for _, contact := range contacts {
+		if contact.Status == models.ContactAccepted {
+			notifyUser(hub, storeInstance, contact.Username, event)
+		}
+	}
+}
+
+// flushPendingEvents sends events queued while the client's user was offline.
+func (c *Client) flushPendingEvents(storeInstance *store.Store) {
+	events, err := storeInstance.TakePendingEvents(c.Username)
+	if err != nil {
+		log.Printf("Failed to load pending events for %s: %v", c.Username, err)
+		return
+	}
+	for i, payload := range events {
+		if queued, _ := c.queue(payload, pendingFlushWait); !queued {
+			// Keep what the connection could not take for next time
+			for _, rest := range events[i:] {
+				spill(c.Username, rest)
+			}
+			return
+		}
+	}
+}
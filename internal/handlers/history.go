@@ -3,37 +3,51 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
-	"strings"
+	"strconv"
 
+	"github.com/edpsouza/chatterbox/internal/models"
 	"github.com/edpsouza/chatterbox/internal/store"
 )
 
-// MessageHistoryHandler serves encrypted message history between authenticated user and another user.
-// Endpoint: GET /messages/:with_user
+// MessageHistoryHandler serves encrypted message history between the authenticated user and another user.
+// Endpoint: GET /messages/{with}[?thread=:root_id]
 func MessageHistoryHandler(storeInstance *store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Simple auth: get username from header (replace with JWT/session in production)
-		username := r.Header.Get("X-Username")
-		if username == "" {
-			http.Error(w, "Unauthorized: missing X-Username header", http.StatusUnauthorized)
+		claims, err := authenticateRequest(r)
+		if err != nil {
+			writeError(w, CodeUnauthorized, "Unauthorized")
 			return
 		}
+		username := claims.Username
 
-		// Extract with_user from URL path: /messages/:with_user
-		parts := strings.Split(r.URL.Path, "/")
-		if len(parts) < 3 || parts[2] == "" {
-			http.Error(w, "Missing with_user in path", http.StatusBadRequest)
+		with := r.PathValue("with")
+		if with == "" {
+			writeError(w, CodeBadRequest, "Missing with_user in path")
 			return
 		}
-		withUser := parts[2]
+		withUser := resolveUsername(storeInstance, with)
 
-		// Fetch messages between username and withUser
-		messages, err := storeInstance.GetMessagesBetween(username, withUser)
+		// Fetch messages between username and withUser, optionally limited to one thread
+		var messages []models.Message
+		if thread := r.URL.Query().Get("thread"); thread != "" {
+			rootID, perr := strconv.ParseInt(thread, 10, 64)
+			if perr != nil {
+				writeError(w, CodeBadRequest, "Invalid thread id")
+				return
+			}
+			messages, err = storeInstance.GetThread(username, withUser, rootID)
+		} else {
+			messages, err = storeInstance.GetMessagesBetween(username, withUser)
+		}
 		if err != nil {
-			http.Error(w, "Failed to fetch messages", http.StatusInternalServerError)
+			writeError(w, CodeInternal, "Failed to fetch messages")
 			return
 		}
 
+		// Everything the requester can now see counts as delivered and read
+		_ = storeInstance.MarkMessagesDelivered(withUser, username)
+		_ = storeInstance.MarkConversationRead(username, withUser)
+
 		// Return as JSON, including recipient field
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(messages)
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// heard records that c's peer is alive and extends its read deadline by
+// PongWait, unless the connection is being drained.
+func (h *Hub) heard(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c.lastHeard = time.Now()
+	if h.PongWait > 0 && !c.goingAway {
+		c.Conn.SetReadDeadline(c.lastHeard.Add(h.PongWait))
+	}
+}
+
+// markTimedOut notes that c was dropped because a read or write deadline
+// passed, so its user is last seen when the peer was last heard from
+// rather than when the server noticed.
+func (h *Hub) markTimedOut(c *Client, err error) {
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return
+	}
+	h.mu.Lock()
+	c.timedOut = !c.goingAway
+	h.mu.Unlock()
+}
+
+// writeDeadline is the deadline for a write starting now; zero means none.
+func (c *Client) writeDeadline() time.Time {
+	if c.writeWait <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.writeWait)
+}
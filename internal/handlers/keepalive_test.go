@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+	"github.com/gorilla/websocket"
+)
+
+// stopHubOnCleanup drains hub when the test ends, before its store is removed.
+func stopHubOnCleanup(t *testing.T, hub *Hub) {
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		hub.Shutdown(ctx, time.Second)
+	})
+}
+
+// dialTestServer opens an authenticated WebSocket connection to hub as username.
+func dialTestServer(t *testing.T, hub *Hub, username string) *websocket.Conn {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeWS(hub, w, r)
+	}))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	conn.WriteJSON(map[string]string{"token": issueTestToken(t, username)})
+	if _, frame, err := conn.ReadMessage(); err != nil || string(frame) != "Authenticated" {
+		t.Fatalf("expected %s to authenticate, got %q %v", username, frame, err)
+	}
+	return conn
+}
+
+func TestHub_SilentConnectionTimesOut(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	for _, username := range []string{"alice", "bob", "carol"} {
+		storeInstance.CreateUser(&models.User{Username: username, Password: "x", PublicKey: "k"})
+	}
+	hub := NewHub()
+	hub.PingInterval = 20 * time.Millisecond
+	hub.PongWait = 100 * time.Millisecond
+	go hub.Run()
+	stopHubOnCleanup(t, hub)
+
+	watcher := newTestClient(hub)
+	hub.authenticate(watcher, "1", "alice")
+	hub.subscribe(watcher, []string{"bob", "carol"})
+
+	// carol keeps reading, so her client answers pings and she stays online
+	carol := dialTestServer(t, hub, "carol")
+	go func() {
+		for {
+			carol.SetReadDeadline(time.Now().Add(time.Second))
+			if _, _, err := carol.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	if e := nextEvent(t, watcher); e.Username != "carol" || e.Status != StatusOnline {
+		t.Fatalf("expected carol online, got %+v", e)
+	}
+
+	// bob's client stops reading and never answers a ping
+	dialTestServer(t, hub, "bob")
+	if e := nextEvent(t, watcher); e.Username != "bob" || e.Status != StatusOnline {
+		t.Fatalf("expected bob online, got %+v", e)
+	}
+	e := nextEvent(t, watcher)
+	if e.Username != "bob" || e.Status != StatusOffline || e.LastSeen == "" {
+		t.Fatalf("expected bob offline after the pong wait, got %+v", e)
+	}
+	// The driver reads last_seen back in RFC 3339
+	user, _ := storeInstance.GetUserByUsername("bob")
+	if lastSeen := strings.NewReplacer("T", " ", "Z", "").Replace(user.LastSeen); user.Status != StatusOffline || lastSeen != e.LastSeen {
+		t.Errorf("expected bob stored offline with last_seen %s, got %s %s", e.LastSeen, user.Status, user.LastSeen)
+	}
+
+	hub.mu.Lock()
+	status := hub.presenceLocked("carol")
+	hub.mu.Unlock()
+	if status != StatusOnline {
+		t.Errorf("expected carol to stay online, got %s", status)
+	}
+}
+
+func TestHub_TimedOutClientLastSeen(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	watcher := newTestClient(hub)
+	hub.authenticate(watcher, "1", "alice")
+	hub.subscribe(watcher, []string{"bob"})
+	bob := newTestClient(hub)
+	hub.authenticate(bob, "2", "bob")
+	nextEvent(t, watcher) // online
+
+	// A connection dropped for silence was last seen when last heard from
+	heard := time.Now().Add(-time.Hour)
+	hub.mu.Lock()
+	bob.lastHeard, bob.timedOut = heard, true
+	hub.mu.Unlock()
+	hub.Unregister <- bob
+	if e := nextEvent(t, watcher); e.Status != StatusOffline || e.LastSeen != heard.UTC().Format(sqliteTimestamp) {
+		t.Fatalf("expected bob offline since %s, got %+v", heard.UTC().Format(sqliteTimestamp), e)
+	}
+}
+
+func TestHub_MaxMessageSize(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	setupTestStore(t)
+	hub := NewHub()
+	hub.MaxMessageSize = 1024
+	go hub.Run()
+	stopHubOnCleanup(t, hub)
+
+	conn := dialTestServer(t, hub, "alice")
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"to":"bob","ciphertext":"`+strings.Repeat("x", 2048)+`"}`))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+			t.Errorf("expected a message-too-big close, got %v", err)
+		}
+		break
+	}
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+// LoginLockout holds the failed-login policies per account and per client IP.
+type LoginLockout struct {
+	Account store.LockoutPolicy
+	IP      store.LockoutPolicy
+}
+
+// loginLockout is the active policy; see SetLoginLockout.
+var loginLockout = LoginLockout{
+	Account: store.LockoutPolicy{MaxFailures: 5, Window: 15 * time.Minute, Base: time.Minute, Max: time.Hour},
+	IP:      store.LockoutPolicy{MaxFailures: 20, Window: 15 * time.Minute, Base: time.Minute, Max: time.Hour},
+}
+
+// SetLoginLockout configures brute-force protection for password and 2FA checks.
+func SetLoginLockout(l LoginLockout) {
+	loginLockout = l
+}
+
+// Lockout keys. Accounts are keyed by the canonical form of the name that
+// was tried, whether or not it exists, so lockouts don't reveal which
+// usernames are taken.
+func accountKey(username string) string { return "user:" + models.CanonicalUsername(username) }
+func ipKey(ip string) string            { return "ip:" + ip }
+
+// dummyPasswordHash is verified against for unknown users so that failed
+// logins take as long whether or not the username exists.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := models.HashPassword("dummy password")
+	return hash
+})
+
+// Waiting for other attempts against the same key is bounded, so a flood of
+// guesses can't pile up requests behind it; an attempt that gives up is
+// told to retry after loginBusyRetry.
+const (
+	maxLoginWait   = 5 * time.Second
+	loginBusyRetry = time.Second
+)
+
+// loginGuards holds a lock per lockout key with attempts in progress.
+var loginGuards = struct {
+	sync.Mutex
+	held map[string]*loginGuard
+}{held: make(map[string]*loginGuard)}
+
+// loginGuard serializes attempts against one lockout key: an attempt holds
+// it while it has a value in turn. users counts the attempts holding or
+// waiting for it.
+type loginGuard struct {
+	turn  chan struct{}
+	users int
+}
+
+// lockLoginKey waits for other attempts against key to finish, or until
+// ctx is done, and returns the function that lets the next one in.
+func lockLoginKey(ctx context.Context, key string) (func(), error) {
+	loginGuards.Lock()
+	g := loginGuards.held[key]
+	if g == nil {
+		g = &loginGuard{turn: make(chan struct{}, 1)}
+		loginGuards.held[key] = g
+	}
+	g.users++
+	loginGuards.Unlock()
+
+	leave := func() {
+		loginGuards.Lock()
+		if g.users--; g.users == 0 {
+			delete(loginGuards.held, key)
+		}
+		loginGuards.Unlock()
+	}
+	select {
+	case g.turn <- struct{}{}:
+	case <-ctx.Done():
+		leave()
+		return nil, ctx.Err()
+	}
+	return func() {
+		<-g.turn
+		leave()
+	}, nil
+}
+
+// beginLoginAttempt starts checking a password or code for username from
+// ip and reports how long they must wait before trying again, or zero if
+// they may try now. Attempts against the same account or address run one
+// at a time, so parallel guesses each see the failures recorded before
+// them; one that can't get its turn within maxLoginWait, or before ctx is
+// done, is turned away. The caller must call release, which may be called
+// more than once, when the outcome is recorded.
+func beginLoginAttempt(ctx context.Context, storeInstance *store.Store, username, ip string) (release func(), retryAfter time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, maxLoginWait)
+	defer cancel()
+	// Always account first, then address, so attempts can't deadlock
+	unlockAccount, err := lockLoginKey(ctx, accountKey(username))
+	if err != nil {
+		return func() {}, loginBusyRetry
+	}
+	unlockIP, err := lockLoginKey(ctx, ipKey(ip))
+	if err != nil {
+		unlockAccount()
+		return func() {}, loginBusyRetry
+	}
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			unlockIP()
+			unlockAccount()
+		})
+	}
+
+	now := clock()
+	until, err := storeInstance.LoginLockedUntil(now, accountKey(username), ipKey(ip))
+	if err != nil {
+		log.Printf("Failed to check login lockout for %s: %v", username, err)
+		return release, 0
+	}
+	if until.IsZero() {
+		return release, 0
+	}
+	return release, until.Sub(now)
+}
+
+// recordLoginFailure counts a wrong password or code against username and ip.
+func recordLoginFailure(storeInstance *store.Store, username, ip string) {
+	now := clock()
+	if _, err := storeInstance.RecordLoginFailure(accountKey(username), loginLockout.Account, now); err != nil {
+		log.Printf("Failed to record login failure for %s: %v", username, err)
+	}
+	if _, err := storeInstance.RecordLoginFailure(ipKey(ip), loginLockout.IP, now); err != nil {
+		log.Printf("Failed to record login failure from %s: %v", ip, err)
+	}
+}
+
+// clearLoginFailures resets username's counter after a successful login. The
+// IP counter only decays, so logging in to one account can't reset guesses at others.
+func clearLoginFailures(storeInstance *store.Store, username string) {
+	if err := storeInstance.ClearLoginFailures(accountKey(username)); err != nil {
+		log.Printf("Failed to clear login failures for %s: %v", username, err)
+	}
+}
+
+// writeLockedOut answers a login attempt made during a lockout.
+func writeLockedOut(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeError(w, CodeRateLimited, "Too many failed attempts")
+}
+
+// checkPassword verifies password for username, which may not exist, taking
+// the same time either way. It returns the user only if the password matches.
+func checkPassword(storeInstance *store.Store, username, password string) (*models.User, error) {
+	user, err := storeInstance.GetUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	hash := dummyPasswordHash()
+	if user != nil {
+		hash = user.Password
+	}
+	ok, err := models.VerifyPassword(hash, password)
+	if err != nil || !ok || user == nil {
+		return nil, nil
+	}
+	// Upgrade hashes made with weaker Argon2 parameters while the password is at hand
+	if models.NeedsRehash(user.Password) {
+		if rehashed, err := models.HashPassword(password); err == nil {
+			if err := storeInstance.UpdatePassword(user.Username, rehashed); err == nil {
+				user.Password = rehashed
+			}
+		}
+	}
+	return user, nil
+}
+
+// adminClientCertRequired makes admin endpoints demand a verified TLS
+// client certificate in addition to the admin token.
+var adminClientCertRequired bool
+
+// SetAdminClientCertRequired configures whether admin endpoints require a
+// verified TLS client certificate.
+func SetAdminClientCertRequired(required bool) {
+	adminClientCertRequired = required
+}
+
+// authorizeAdmin checks for "Authorization: Bearer <ADMIN_TOKEN>", and a
+// verified client certificate when those are required, answering the request
+// itself when either is missing or admin endpoints are disabled.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request, adminToken string) bool {
+	if adminToken == "" {
+		writeError(w, CodeNotFound, "No such endpoint")
+		return false
+	}
+	if adminClientCertRequired && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		writeError(w, CodeForbidden, "Client certificate required")
+		return false
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		writeError(w, CodeUnauthorized, "Unauthorized")
+		return false
+	}
+	return true
+}
+
+// AdminUnlockHandler serves POST /admin/unlock {"username":"...","ip":"..."},
+// lifting lockouts early. It requires "Authorization: Bearer <ADMIN_TOKEN>"
+// and is disabled when no admin token is configured.
+func AdminUnlockHandler(storeInstance *store.Store, adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(w, r, adminToken) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		var req struct {
+			Username string `json:"username"`
+			IP       string `json:"ip"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Username == "" && req.IP == "") {
+			writeError(w, CodeBadRequest, "Username or ip required")
+			return
+		}
+		var keys []string
+		if req.Username != "" {
+			keys = append(keys, accountKey(req.Username))
+		}
+		if req.IP != "" {
+			keys = append(keys, ipKey(req.IP))
+		}
+		if err := storeInstance.ClearLoginFailures(keys...); err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+func TestLoginLockout(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	hashed, _ := models.HashPassword("secret")
+	storeInstance.CreateUser(&models.User{Username: "alice", Password: hashed, PublicKey: "k"})
+
+	previous := loginLockout
+	policy := store.LockoutPolicy{MaxFailures: 2, Window: time.Hour, Base: time.Minute, Max: time.Hour}
+	SetLoginLockout(LoginLockout{Account: policy, IP: store.LockoutPolicy{MaxFailures: 10, Window: time.Hour, Base: time.Minute, Max: time.Hour}})
+	t.Cleanup(func() { SetLoginLockout(previous) })
+
+	login := func(username, password string) *httptest.ResponseRecorder {
+		t.Helper()
+		body := `{"username":"` + username + `","password":"` + password + `"}`
+		w := httptest.NewRecorder()
+		LoginHandler(storeInstance)(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body)))
+		return w
+	}
+
+	// Unknown and existing accounts fail and lock out the same way
+	for _, username := range []string{"alice", "nobody"} {
+		for i := 0; i < 2; i++ {
+			if w := login(username, "wrong"); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), `"code":"invalid_credentials"`) {
+				t.Fatalf("expected invalid credentials for %s, got %d %q", username, w.Code, w.Body.String())
+			}
+		}
+		if w := login(username, "secret"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			t.Errorf("expected %s to be locked out, got %d", username, w.Code)
+		}
+	}
+
+	// An admin can lift the lockout
+	unlock := AdminUnlockHandler(storeInstance, "admintoken")
+	req := httptest.NewRequest(http.MethodPost, "/admin/unlock", strings.NewReader(`{"username":"alice"}`))
+	req.Header.Set("Authorization", "Bearer wrong")
+	w := httptest.NewRecorder()
+	unlock(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for wrong admin token, got %d", w.Code)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/admin/unlock", strings.NewReader(`{"username":"ALICE"}`))
+	req.Header.Set("Authorization", "Bearer admintoken")
+	w = httptest.NewRecorder()
+	unlock(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 unlocking, got %d", w.Code)
+	}
+	if w := login("alice", "secret"); w.Code != http.StatusOK {
+		t.Errorf("expected login after unlock, got %d", w.Code)
+	}
+
+	// The client IP is locked out too once it reaches its own limit
+	for i := 0; i < 10; i++ {
+		login("user"+string(rune('a'+i)), "wrong")
+	}
+	if w := login("alice", "secret"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected IP lockout, got %d", w.Code)
+	}
+}
+
+func TestLoginLockout_ParallelAttempts(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	hashed, _ := models.HashPassword("secret")
+	storeInstance.CreateUser(&models.User{Username: "alice", Password: hashed, PublicKey: "k"})
+
+	previous := loginLockout
+	policy := store.LockoutPolicy{MaxFailures: 3, Window: time.Hour, Base: time.Minute, Max: time.Hour}
+	SetLoginLockout(LoginLockout{Account: policy, IP: store.LockoutPolicy{MaxFailures: 100, Window: time.Hour, Base: time.Minute, Max: time.Hour}})
+	t.Cleanup(func() { SetLoginLockout(previous) })
+
+	// Guesses sent all at once get no more tries than guesses sent in turn
+	const attempts = 12
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			LoginHandler(storeInstance)(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"alice","password":"wrong"}`)))
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusUnauthorized] != 3 || counts[http.StatusTooManyRequests] != attempts-3 {
+		t.Errorf("expected 3 checked guesses and %d lockouts, got %v", attempts-3, counts)
+	}
+}
+
+func TestAdminClientCertRequired(t *testing.T) {
+	storeInstance := setupTestStore(t)
+	SetAdminClientCertRequired(true)
+	t.Cleanup(func() { SetAdminClientCertRequired(false) })
+
+	unlock := AdminUnlockHandler(storeInstance, "admintoken")
+	request := func(state *tls.ConnectionState) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/unlock", strings.NewReader(`{"username":"alice"}`))
+		req.Header.Set("Authorization", "Bearer admintoken")
+		req.TLS = state
+		w := httptest.NewRecorder()
+		unlock(w, req)
+		return w
+	}
+
+	// The admin token alone is not enough, nor is an unverified certificate
+	for _, state := range []*tls.ConnectionState{nil, {}} {
+		if w := request(state); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"code":"forbidden"`) {
+			t.Errorf("expected 403 without a verified client certificate, got %d %q", w.Code, w.Body.String())
+		}
+	}
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	if w := request(verified); w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 with a verified client certificate, got %d", w.Code)
+	}
+}
+
+func TestLoginLockout_TwoFactorManagement(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	hashed, _ := models.HashPassword("secret")
+	storeInstance.CreateUser(&models.User{Username: "alice", Password: hashed, PublicKey: "k"})
+	codes, _ := store.NewRecoveryCodes(recoveryCodeCount)
+	storeInstance.StartTwoFactor("alice", "JBSWY3DPEHPK3PXP")
+	storeInstance.EnableTwoFactor("alice", 0, codes)
+
+	previous := loginLockout
+	policy := store.LockoutPolicy{MaxFailures: 2, Window: time.Hour, Base: time.Minute, Max: time.Hour}
+	SetLoginLockout(LoginLockout{Account: policy, IP: policy})
+	t.Cleanup(func() { SetLoginLockout(previous) })
+
+	token := issueTestToken(t, "alice")
+	twoFactor := func(path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		routed(TwoFactorHandler(storeInstance), twoFactorRoutes...)(w, req)
+		return w
+	}
+
+	// Wrong codes and passwords behind an access token count like failed logins
+	if w := twoFactor("/2fa/recovery-codes", `{"code":"000000"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected wrong code to be rejected, got %d", w.Code)
+	}
+	if w := twoFactor("/2fa/disable", `{"password":"wrong","code":"`+codes[0]+`"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected wrong password to be rejected, got %d", w.Code)
+	}
+	if w := twoFactor("/2fa/disable", `{"password":"secret","code":"`+codes[0]+`"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the account to be locked out, got %d", w.Code)
+	}
+}
+
+func TestLoginLockout_BoundedWait(t *testing.T) {
+	storeInstance := setupTestStore(t)
+	release, wait := beginLoginAttempt(context.Background(), storeInstance, "alice", "192.0.2.1")
+	defer release()
+	if wait != 0 {
+		t.Fatalf("expected the first attempt to proceed, got %s", wait)
+	}
+
+	// An attempt queued behind it gives up when its request goes away
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	second, wait := beginLoginAttempt(ctx, storeInstance, "alice", "192.0.2.2")
+	second()
+	if wait != loginBusyRetry {
+		t.Errorf("expected to be told to retry, got %s", wait)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("expected the wait to end with the request, waited %s", waited)
+	}
+	loginGuards.Lock()
+	defer loginGuards.Unlock()
+	if len(loginGuards.held) != 2 {
+		t.Errorf("expected only the first attempt's two keys to be held, got %d", len(loginGuards.held))
+	}
+}
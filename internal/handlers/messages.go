@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+// messageEditWindow bounds how long after sending a message may be edited or deleted.
+var messageEditWindow = 15 * time.Minute
+
+// SetMessageEditWindow configures the edit/delete window. Zero disables the limit.
+func SetMessageEditWindow(d time.Duration) {
+	messageEditWindow = d
+}
+
+// editMessage replaces the ciphertext of a message and notifies its
+// recipient, unless they have since blocked the sender.
+func editMessage(storeInstance *store.Store, hub *Hub, sender string, id int64, ciphertext string) (*models.Message, error) {
+	m, err := storeInstance.EditMessage(id, sender, ciphertext, messageEditWindow)
+	if err != nil {
+		return nil, err
+	}
+	if !hasBlocked(storeInstance, m.Recipient, sender) {
+		notifyUser(hub, storeInstance, m.Recipient, messageEvent(EventEdit, m))
+	}
+	return m, nil
+}
+
+// deleteMessage tombstones a message for everyone and notifies its recipient.
+func deleteMessage(storeInstance *store.Store, hub *Hub, sender string, id int64) (*models.Message, error) {
+	m, err := storeInstance.DeleteMessage(id, sender, messageEditWindow)
+	if err != nil {
+		return nil, err
+	}
+	notifyUser(hub, storeInstance, m.Recipient, messageEvent(EventDelete, m))
+	return m, nil
+}
+
+// reactToMessage sets (or, with remove, clears) reactor's reaction and
+// notifies the other participant, unless they have blocked the reactor. It
+// returns the event for the reactor.
+func reactToMessage(storeInstance *store.Store, hub *Hub, reactor string, id int64, ciphertext string, remove bool) (Event, error) {
+	var m *models.Message
+	var err error
+	event := Event{Type: EventReact, ID: id, From: reactor, Ciphertext: ciphertext}
+	if remove {
+		event = Event{Type: EventUnreact, ID: id, From: reactor}
+		m, err = storeInstance.RemoveReaction(id, reactor)
+	} else {
+		m, err = storeInstance.SetReaction(id, reactor, ciphertext)
+	}
+	if err != nil {
+		return event, err
+	}
+	peer := m.Recipient
+	if peer == reactor {
+		peer = m.Username
+	}
+	if !hasBlocked(storeInstance, peer, reactor) {
+		notifyUser(hub, storeInstance, peer, event)
+	}
+	return event, nil
+}
+
+// MessagesHandler dispatches /messages/{with} (history) and
+// /messages/{with}/{id} (PATCH to edit, DELETE to unsend).
+func MessagesHandler(storeInstance *store.Store, hub *Hub) http.HandlerFunc {
+	history := MessageHistoryHandler(storeInstance)
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if id == "" {
+			history(w, r)
+			return
+		}
+		handleMessageChange(storeInstance, hub, w, r, resolveUsername(storeInstance, r.PathValue("with")), id)
+	}
+}
+
+// resolveUsername maps a username from a request to the account it names.
+// GetUserByUsername prefers an exact match, so accounts the username
+// migration left in their original case stay reachable; names without an
+// account come back canonical.
+func resolveUsername(storeInstance *store.Store, username string) string {
+	if storeInstance != nil {
+		if user, err := storeInstance.GetUserByUsername(username); err == nil && user != nil {
+			return user.Username
+		}
+	}
+	return models.CanonicalUsername(username)
+}
+
+// handleMessageChange edits or deletes a message the authenticated user sent to withUser.
+func handleMessageChange(storeInstance *store.Store, hub *Hub, w http.ResponseWriter, r *http.Request, withUser, rawID string) {
+	claims, err := authenticateRequest(r)
+	if err != nil {
+		writeError(w, CodeUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		writeError(w, CodeBadRequest, "Invalid message id")
+		return
+	}
+	existing, err := storeInstance.GetMessageByID(id)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return
+	}
+	if existing == nil || existing.Recipient != withUser || existing.Username != claims.Username {
+		writeError(w, CodeNotFound, "Message not found")
+		return
+	}
+
+	var m *models.Message
+	switch r.Method {
+	case http.MethodPatch, http.MethodPut:
+		var req struct {
+			Ciphertext string `json:"ciphertext"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Ciphertext == "" {
+			writeError(w, CodeBadRequest, "Ciphertext required")
+			return
+		}
+		m, err = editMessage(storeInstance, hub, claims.Username, id, req.Ciphertext)
+	case http.MethodDelete:
+		m, err = deleteMessage(storeInstance, hub, claims.Username, id)
+	default:
+		w.Header().Set("Allow", "PATCH, PUT, DELETE")
+		writeError(w, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// issueTestToken signs an access token for username the way LoginHandler does.
+func issueTestToken(t *testing.T, username string) string {
+	t.Helper()
+	claims := Claims{
+		Username: username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        username + "-" + strconv.FormatInt(time.Now().UnixNano(), 10),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+// routed serves h on patterns, so handlers see path values as they do
+// behind the router.
+func routed(h http.HandlerFunc, patterns ...string) http.HandlerFunc {
+	mux := http.NewServeMux()
+	for _, pattern := range patterns {
+		mux.HandleFunc(pattern, h)
+	}
+	return mux.ServeHTTP
+}
+
+// These mirror the router's patterns.
+var (
+	messagesRoutes    = []string{"/messages/{with}", "/messages/{with}/{id}"}
+	userRoutes        = []string{"/users/{username}/public_key", "/users/{username}/presence", "/users/{username}/profile"}
+	twoFactorRoutes   = []string{"GET /2fa", "POST /2fa/{action}"}
+	attachmentsRoutes = []string{"POST /attachments", "HEAD /attachments/{id}", "PATCH /attachments/{id}", "GET /attachments/{id}", "POST /attachments/{id}/token"}
+)
+
+func TestMessagesHandler_EditAndDelete(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	hub := NewHub()
+	handler := routed(MessagesHandler(storeInstance, hub), messagesRoutes...)
+
+	id, err := storeInstance.CreateMessage(1, "alice", "bob", "original")
+	if err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+	path := "/messages/bob/" + strconv.FormatInt(id, 10)
+
+	// Only the sender may edit
+	body, _ := json.Marshal(map[string]string{"ciphertext": "edited"})
+	req := httptest.NewRequest(http.MethodPatch, path, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+issueTestToken(t, "bob"))
+	w := httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for non-sender, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPatch, path, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+issueTestToken(t, "alice"))
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 on edit, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, path, nil)
+	req.Header.Set("Authorization", "Bearer "+issueTestToken(t, "alice"))
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 on delete, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// bob is offline, so both events wait in his queue
+	events, err := storeInstance.TakePendingEvents("bob")
+	if err != nil {
+		t.Fatalf("failed to read pending events: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 queued events, got %d", len(events))
+	}
+	var event Event
+	if err := json.Unmarshal(events[1], &event); err != nil {
+		t.Fatalf("failed to decode event: %v", err)
+	}
+	if event.Type != EventDelete || event.ID != id || event.Ciphertext != "" {
+		t.Errorf("unexpected delete event: %+v", event)
+	}
+}
+
+func TestMessagesHandler_BlockedSenderChangesAreNotPushed(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	handler := routed(MessagesHandler(storeInstance, NewHub()), messagesRoutes...)
+
+	sent, _ := storeInstance.CreateMessage(1, "alice", "bob", "original")
+	received, _ := storeInstance.CreateMessage(2, "bob", "alice", "hello")
+	storeInstance.Block("bob", "alice")
+
+	// alice can still change her own message, but bob isn't told
+	body, _ := json.Marshal(map[string]string{"ciphertext": "edited"})
+	req := httptest.NewRequest(http.MethodPatch, "/messages/bob/"+strconv.FormatInt(sent, 10), bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+issueTestToken(t, "alice"))
+	w := httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 on edit, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := reactToMessage(storeInstance, nil, "alice", received, "reaction", false); err != nil {
+		t.Fatalf("failed to react: %v", err)
+	}
+	if events, _ := storeInstance.TakePendingEvents("bob"); len(events) != 0 {
+		t.Errorf("expected nothing pushed to bob, got %q", events)
+	}
+}
+
+func TestMessagesHandler_HistoryRequiresBearerToken(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	handler := routed(MessagesHandler(storeInstance, NewHub()), messagesRoutes...)
+	if _, err := storeInstance.CreateMessage(1, "alice", "bob", "hello"); err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+
+	// The legacy header no longer identifies anyone
+	req := httptest.NewRequest(http.MethodGet, "/messages/alice", nil)
+	req.Header.Set("X-Username", "bob")
+	w := httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a bearer token, got %d", w.Code)
+	}
+	// and an anonymous request leaves bob's unread messages alone
+	if conversations, _ := storeInstance.ListConversations("bob"); len(conversations) != 1 || conversations[0].UnreadCount != 1 {
+		t.Fatalf("expected bob's conversation to stay unread, got %+v", conversations)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/messages/alice", nil)
+	req.Header.Set("Authorization", "Bearer "+issueTestToken(t, "bob"))
+	w = httptest.NewRecorder()
+	handler(w, req)
+	var history []json.RawMessage
+	if w.Code != http.StatusOK || json.NewDecoder(w.Body).Decode(&history) != nil || len(history) != 1 {
+		t.Fatalf("expected bob's history with alice, got %d: %s", w.Code, w.Body.String())
+	}
+	if conversations, _ := storeInstance.ListConversations("bob"); len(conversations) != 1 || conversations[0].UnreadCount != 0 {
+		t.Errorf("expected reading the history to mark it read, got %+v", conversations)
+	}
+}
+
+func TestMessagesHandler_LegacyMixedCaseAccount(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	handler := routed(MessagesHandler(storeInstance, NewHub()), messagesRoutes...)
+	// An account the username migration kept as "Alice" because it collided
+	for _, username := range []string{"Alice", "bob"} {
+		if err := storeInstance.CreateUser(&models.User{Username: username, Password: "x", PublicKey: "k"}); err != nil {
+			t.Fatalf("failed to create %s: %v", username, err)
+		}
+	}
+	if _, err := storeInstance.CreateMessage(1, "Alice", "bob", "hello"); err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+
+	for path, want := range map[string]int{"/messages/Alice": 1, "/messages/alice": 0} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+issueTestToken(t, "bob"))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		var history []json.RawMessage
+		if w.Code != http.StatusOK || json.NewDecoder(w.Body).Decode(&history) != nil || len(history) != want {
+			t.Errorf("%s: expected %d messages, got %d: %s", path, want, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+// minPasswordLength is the shortest new password accepted on change or reset.
+const minPasswordLength = 8
+
+// passwordResetTTL is how long admin-issued reset tokens stay valid.
+var passwordResetTTL = time.Hour
+
+// SetPasswordResetTTL configures the lifetime of password reset tokens.
+func SetPasswordResetTTL(d time.Duration) {
+	passwordResetTTL = d
+}
+
+// PasswordRequest is the body of POST /password and POST /password/reset.
+type PasswordRequest struct {
+	CurrentPassword string `json:"current_password,omitempty"` // change only
+	Token           string `json:"token,omitempty"`            // reset only
+	NewPassword     string `json:"new_password"`
+}
+
+// setPassword hashes and stores a new password for username.
+func setPassword(storeInstance *store.Store, w http.ResponseWriter, username, password string) bool {
+	if len(password) < minPasswordLength {
+		writeError(w, CodeBadRequest, "Password too short")
+		return false
+	}
+	hashed, err := models.HashPassword(password)
+	if err != nil {
+		writeError(w, CodeInternal, "Failed to hash password")
+		return false
+	}
+	if err := storeInstance.UpdatePassword(username, hashed); err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return false
+	}
+	return true
+}
+
+// PasswordHandler serves POST /password, changing the caller's password.
+// Every other session is revoked and its connections closed.
+func PasswordHandler(storeInstance *store.Store, hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := authenticateRequest(r)
+		if err != nil {
+			writeError(w, CodeUnauthorized, "Unauthorized")
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		var req PasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CurrentPassword == "" || req.NewPassword == "" {
+			writeError(w, CodeBadRequest, "Current and new password required")
+			return
+		}
+		// Wrong current passwords count towards the lockout like failed logins
+		ip := clientIP(r)
+		release, wait := beginLoginAttempt(r.Context(), storeInstance, claims.Username, ip)
+		defer release()
+		if wait > 0 {
+			writeLockedOut(w, wait)
+			return
+		}
+		user, err := checkPassword(storeInstance, claims.Username, req.CurrentPassword)
+		if err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		if user == nil {
+			recordLoginFailure(storeInstance, claims.Username, ip)
+			writeError(w, CodeInvalidCredentials, "Invalid credentials")
+			return
+		}
+		if !setPassword(storeInstance, w, user.Username, req.NewPassword) {
+			return
+		}
+		revoked, err := storeInstance.RevokeUserSessions(user.Username, claims.SessionID)
+		if err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		if hub != nil {
+			for _, id := range revoked {
+				hub.disconnectSession(id)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// PasswordResetHandler serves POST /password/reset {"token","new_password"},
+// setting a new password with an admin-issued reset token. All of the
+// user's sessions are revoked and any lockout on the account is lifted.
+func PasswordResetHandler(storeInstance *store.Store, hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		var req PasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || req.NewPassword == "" {
+			writeError(w, CodeBadRequest, "Token and new password required")
+			return
+		}
+		if len(req.NewPassword) < minPasswordLength {
+			writeError(w, CodeBadRequest, "Password too short")
+			return
+		}
+		username, err := storeInstance.ConsumePasswordResetToken(req.Token)
+		if errors.Is(err, store.ErrInvalidResetToken) {
+			writeError(w, CodeBadRequest, "Invalid or expired reset token")
+			return
+		}
+		if err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		if !setPassword(storeInstance, w, username, req.NewPassword) {
+			return
+		}
+		if _, err := storeInstance.RevokeUserSessions(username, ""); err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		if hub != nil {
+			hub.disconnectUser(username)
+		}
+		clearLoginFailures(storeInstance, username)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// AdminPasswordResetHandler serves POST /admin/password-reset {"username"},
+// issuing a single-use reset token for the admin to hand over out of band.
+// It requires "Authorization: Bearer <ADMIN_TOKEN>".
+func AdminPasswordResetHandler(storeInstance *store.Store, adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(w, r, adminToken) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		var req struct {
+			Username string `json:"username"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+			writeError(w, CodeBadRequest, "Username required")
+			return
+		}
+		user, err := storeInstance.GetUserByUsername(req.Username)
+		if err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		if user == nil {
+			writeError(w, CodeNotFound, "User not found")
+			return
+		}
+		token, err := storeInstance.CreatePasswordResetToken(user.Username, passwordResetTTL)
+		if err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{
+			"username":   user.Username,
+			"token":      token,
+			"expires_at": time.Now().Add(passwordResetTTL).UTC().Format(time.RFC3339),
+		})
+	}
+}
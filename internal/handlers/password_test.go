@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+func TestPasswordChangeAndReset(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	hashed, _ := models.HashPassword("secret123")
+	storeInstance.CreateUser(&models.User{Username: "alice", Password: hashed, PublicKey: "k"})
+
+	login := func(password string) (TokenResponse, int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		LoginHandler(storeInstance)(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"alice","password":"`+password+`"}`)))
+		var tokens TokenResponse
+		json.NewDecoder(w.Body).Decode(&tokens)
+		return tokens, w.Code
+	}
+	laptop, _ := login("secret123")
+	phone, _ := login("secret123")
+
+	hub := NewHub()
+	go hub.Run()
+	change := func(token, body string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/password", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		PasswordHandler(storeInstance, hub)(w, req)
+		return w.Code
+	}
+
+	if code := change(laptop.Token, `{"current_password":"wrong","new_password":"newsecret"}`); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for wrong current password, got %d", code)
+	}
+	if code := change(laptop.Token, `{"current_password":"secret123","new_password":"short"}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for short password, got %d", code)
+	}
+
+	// The phone's WebSocket connection is closed by the change
+	claims, _ := parseAccessToken(phone.Token)
+	client := newTestClient(hub)
+	client.sessionID = claims.SessionID
+	hub.authenticate(client, claims.UserID, claims.Username)
+
+	if code := change(laptop.Token, `{"current_password":"secret123","new_password":"newsecret"}`); code != http.StatusNoContent {
+		t.Fatalf("expected 204 changing password, got %d", code)
+	}
+	for range client.Send {
+		// drain until the hub closes the channel
+	}
+	if _, err := parseAccessToken(phone.Token); err == nil {
+		t.Error("other sessions should be revoked after a password change")
+	}
+	if _, err := parseAccessToken(laptop.Token); err != nil {
+		t.Errorf("current session should keep working: %v", err)
+	}
+	if _, code := login("secret123"); code != http.StatusUnauthorized {
+		t.Errorf("old password should no longer work, got %d", code)
+	}
+	if _, code := login("newsecret"); code != http.StatusOK {
+		t.Errorf("new password should work, got %d", code)
+	}
+
+	// An admin issues a reset token
+	issue := func(adminToken, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/admin/password-reset", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		w := httptest.NewRecorder()
+		AdminPasswordResetHandler(storeInstance, "admintoken")(w, req)
+		return w
+	}
+	if w := issue("wrong", `{"username":"alice"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for wrong admin token, got %d", w.Code)
+	}
+	if w := issue("admintoken", `{"username":"nobody"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown user, got %d", w.Code)
+	}
+	w := issue("admintoken", `{"username":"alice"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 issuing reset token, got %d", w.Code)
+	}
+	var issued struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(w.Body).Decode(&issued)
+
+	reset := func(body string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		PasswordResetHandler(storeInstance, hub)(w, httptest.NewRequest(http.MethodPost, "/password/reset", strings.NewReader(body)))
+		return w.Code
+	}
+	if code := reset(`{"token":"bogus","new_password":"resetsecret"}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown token, got %d", code)
+	}
+	if code := reset(`{"token":"` + issued.Token + `","new_password":"resetsecret"}`); code != http.StatusNoContent {
+		t.Fatalf("expected 204 resetting password, got %d", code)
+	}
+	if code := reset(`{"token":"` + issued.Token + `","new_password":"again12345"}`); code != http.StatusBadRequest {
+		t.Errorf("reset token should be single use, got %d", code)
+	}
+	if _, err := parseAccessToken(laptop.Token); err == nil {
+		t.Error("all sessions should be revoked after a reset")
+	}
+	if _, code := login("resetsecret"); code != http.StatusOK {
+		t.Errorf("reset password should work, got %d", code)
+	}
+}
+
+func TestLoginRehashesWeakPasswords(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	t.Cleanup(func() { models.SetArgon2Params(models.DefaultArgon2Params) })
+
+	models.SetArgon2Params(models.Argon2Params{Time: 1, Memory: 8 * 1024, Threads: 1})
+	weak, _ := models.HashPassword("secret123")
+	storeInstance.CreateUser(&models.User{Username: "alice", Password: weak, PublicKey: "k"})
+
+	models.SetArgon2Params(models.DefaultArgon2Params)
+	w := httptest.NewRecorder()
+	LoginHandler(storeInstance)(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"alice","password":"secret123"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected login with old params to succeed, got %d", w.Code)
+	}
+	user, _ := storeInstance.GetUserByUsername("alice")
+	if user.Password == weak || models.NeedsRehash(user.Password) {
+		t.Errorf("expected hash to be upgraded, got %s", user.Password)
+	}
+	if ok, _ := models.VerifyPassword(user.Password, "secret123"); !ok {
+		t.Error("upgraded hash should verify")
+	}
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Presence statuses. A user is online while any of their connections is
+// active, away while all of them are idle, and offline once the last one leaves.
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+// Client heartbeat states sent in {"type":"heartbeat","state":"..."} frames.
+const (
+	HeartbeatActive = "active"
+	HeartbeatIdle   = "idle"
+)
+
+// maxSubscriptions caps how many users a single connection may watch.
+const maxSubscriptions = 500
+
+// presenceCheckInterval is how often the hub looks for connections that went quiet.
+const presenceCheckInterval = 30 * time.Second
+
+// sqliteTimestamp matches the format SQLite uses for CURRENT_TIMESTAMP.
+const sqliteTimestamp = "2006-01-02 15:04:05"
+
+// authenticate marks c as logged in as username and publishes the user's presence.
+func (h *Hub) authenticate(c *Client, userID, username string) {
+	h.mu.Lock()
+	// Register is asynchronous; make sure the connection counts towards presence
+	h.Clients[c] = true
+	c.UserID = userID
+	c.Username = username
+	c.Authenticated = true
+	c.lastActive = time.Now()
+	h.mu.Unlock()
+	h.refreshPresence(username)
+}
+
+// touch records activity on c, optionally changing its heartbeat state, and
+// republishes presence when that could change the user's status.
+func (h *Hub) touch(c *Client, state string) {
+	h.mu.Lock()
+	wasQuiet := c.idle || time.Since(c.lastActive) >= h.AwayAfter
+	switch state {
+	case HeartbeatIdle:
+		c.idle = true
+	case HeartbeatActive:
+		c.idle = false
+	}
+	c.lastActive = time.Now()
+	changed := wasQuiet != c.idle
+	h.mu.Unlock()
+	if changed {
+		h.refreshPresence(c.Username)
+	}
+}
+
+// presenceLocked computes username's status from its connections. Caller holds h.mu.
+func (h *Hub) presenceLocked(username string) string {
+	status := StatusOffline
+	for client := range h.Clients {
+		if !client.Authenticated || client.Username != username {
+			continue
+		}
+		if !client.idle && time.Since(client.lastActive) < h.AwayAfter {
+			return StatusOnline
+		}
+		status = StatusAway
+	}
+	return status
+}
+
+// refreshPresence recomputes username's status and, when it changed,
+// persists it and pushes a presence event to every subscriber.
+func (h *Hub) refreshPresence(username string) {
+	h.refreshPresenceSeen(username, time.Now())
+}
+
+// refreshPresenceSeen is refreshPresence for a user last seen at seen,
+// which is recorded if they went offline.
+func (h *Hub) refreshPresenceSeen(username string, seen time.Time) {
+	if username == "" {
+		return
+	}
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+
+	h.mu.Lock()
+	status := h.presenceLocked(username)
+	previous, known := h.presence[username]
+	if (known && previous == status) || (!known && status == StatusOffline) {
+		h.mu.Unlock()
+		return
+	}
+	if status == StatusOffline {
+		delete(h.presence, username)
+	} else {
+		h.presence[username] = status
+	}
+	h.mu.Unlock()
+
+	lastSeen := ""
+	if status == StatusOffline {
+		lastSeen = seen.UTC().Format(sqliteTimestamp)
+	}
+	invisible := false
+	if storeInstance, err := getStoreInstance(); err == nil {
+		if settings, err := storeInstance.GetPrivacySettings(username); err == nil {
+			invisible = settings.Invisible
+		}
+		_ = storeInstance.SetUserStatus(username, status)
+		// An invisible user's last_seen stays frozen at when they went invisible
+		if status == StatusOffline && !invisible {
+			_ = storeInstance.SetUserLastSeen(username, lastSeen)
+		}
+	}
+	// Subscribers already see an invisible user as offline
+	if invisible {
+		return
+	}
+	h.pushPresence(username, status, lastSeen)
+}
+
+// publishPresence re-sends username's current presence to every subscriber,
+// e.g. after the user changed their privacy settings.
+func (h *Hub) publishPresence(username string) {
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+	h.mu.Lock()
+	status, ok := h.presence[username]
+	h.mu.Unlock()
+	lastSeen := ""
+	if !ok {
+		status = StatusOffline
+		if storeInstance, err := getStoreInstance(); err == nil {
+			if user, err := storeInstance.GetUserByUsername(username); err == nil && user != nil {
+				lastSeen = user.LastSeen
+			}
+		}
+	}
+	h.pushPresence(username, status, lastSeen)
+}
+
+// pushPresence sends a presence event to each subscriber of username, showing
+// each one only what username's privacy settings allow. Presence only
+// matters live, so a subscriber that can't take the event right away misses
+// it rather than holding up the others.
+func (h *Hub) pushPresence(username, status, lastSeen string) {
+	h.mu.Lock()
+	subscribers := make([]*Client, 0, len(h.subscriptions[username]))
+	for client := range h.subscriptions[username] {
+		subscribers = append(subscribers, client)
+	}
+	h.mu.Unlock()
+
+	storeInstance, _ := getStoreInstance()
+	for _, client := range subscribers {
+		event := Event{Type: EventPresence, Username: username, Status: status, LastSeen: lastSeen}
+		if storeInstance != nil {
+			event.Status, event.LastSeen = filterPresence(storeInstance, username, client.Username, status, lastSeen)
+		}
+		if event.Status == "" {
+			continue
+		}
+		if payload, err := json.Marshal(event); err == nil {
+			client.offerNow(payload)
+		}
+	}
+}
+
+// refreshAllPresence re-evaluates every connected user, turning quiet connections away.
+func (h *Hub) refreshAllPresence() {
+	h.mu.Lock()
+	usernames := make(map[string]bool)
+	for client := range h.Clients {
+		if client.Authenticated {
+			usernames[client.Username] = true
+		}
+	}
+	h.mu.Unlock()
+	for username := range usernames {
+		h.refreshPresence(username)
+	}
+}
+
+// subscribe starts pushing presence events for usernames to c and returns
+// the current presence of each existing user.
+func (h *Hub) subscribe(c *Client, usernames []string) []Event {
+	storeInstance, _ := getStoreInstance()
+	var snapshot []Event
+	for _, username := range usernames {
+		if username == "" {
+			continue
+		}
+		event := Event{Type: EventPresence, Username: username, Status: StatusOffline}
+		if storeInstance != nil {
+			user, err := storeInstance.GetUserByUsername(username)
+			if err != nil || user == nil {
+				continue
+			}
+			username = user.Username
+			event.Username = user.Username
+			event.LastSeen = user.LastSeen
+		}
+
+		h.mu.Lock()
+		if len(c.subscriptions) >= maxSubscriptions {
+			h.mu.Unlock()
+			break
+		}
+		if h.subscriptions[username] == nil {
+			h.subscriptions[username] = make(map[*Client]bool)
+		}
+		h.subscriptions[username][c] = true
+		c.subscriptions[username] = true
+		if status, ok := h.presence[username]; ok {
+			event.Status = status
+			event.LastSeen = ""
+		}
+		h.mu.Unlock()
+		if storeInstance != nil {
+			event.Status, event.LastSeen = filterPresence(storeInstance, username, c.Username, event.Status, event.LastSeen)
+		}
+		snapshot = append(snapshot, event)
+	}
+	return snapshot
+}
+
+// unsubscribe stops presence events for usernames to c.
+func (h *Hub) unsubscribe(c *Client, usernames []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, username := range usernames {
+		h.removeSubscriptionLocked(c, username)
+	}
+}
+
+// removeSubscriptionLocked drops one subscription. Caller holds h.mu.
+func (h *Hub) removeSubscriptionLocked(c *Client, username string) {
+	delete(c.subscriptions, username)
+	if subs := h.subscriptions[username]; subs != nil {
+		delete(subs, c)
+		if len(subs) == 0 {
+			delete(h.subscriptions, username)
+		}
+	}
+}
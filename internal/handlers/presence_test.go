@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// newTestClient registers an unauthenticated client without a network connection.
+func newTestClient(hub *Hub) *Client {
+	c := &Client{Send: make(chan []byte, 16), subscriptions: make(map[string]bool), hub: hub}
+	hub.Register <- c
+	return c
+}
+
+// nextEvent reads the next JSON event queued for c.
+func nextEvent(t *testing.T, c *Client) Event {
+	t.Helper()
+	select {
+	case payload := <-c.Send:
+		var event Event
+		if err := json.Unmarshal(payload, &event); err != nil {
+			t.Fatalf("failed to decode event %q: %v", payload, err)
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+		return Event{}
+	}
+}
+
+func TestHub_PresenceSubscriptions(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	alice := newTestClient(hub)
+	hub.authenticate(alice, "1", "alice")
+	snapshot := hub.subscribe(alice, []string{"bob"})
+	if len(snapshot) != 1 || snapshot[0].Status != StatusOffline {
+		t.Fatalf("expected bob offline in snapshot, got %+v", snapshot)
+	}
+
+	// bob connects from two devices
+	phone := newTestClient(hub)
+	hub.authenticate(phone, "2", "bob")
+	if e := nextEvent(t, alice); e.Type != EventPresence || e.Username != "bob" || e.Status != StatusOnline {
+		t.Fatalf("expected bob online, got %+v", e)
+	}
+	laptop := newTestClient(hub)
+	hub.authenticate(laptop, "2", "bob")
+
+	// Both devices idle: bob is away
+	hub.touch(phone, HeartbeatIdle)
+	hub.touch(laptop, HeartbeatIdle)
+	if e := nextEvent(t, alice); e.Status != StatusAway {
+		t.Fatalf("expected bob away, got %+v", e)
+	}
+
+	// Closing one device keeps bob around; closing the last one takes him offline
+	hub.Unregister <- phone
+	hub.touch(laptop, HeartbeatActive)
+	if e := nextEvent(t, alice); e.Status != StatusOnline {
+		t.Fatalf("expected bob online again, got %+v", e)
+	}
+	hub.Unregister <- laptop
+	if e := nextEvent(t, alice); e.Status != StatusOffline || e.LastSeen == "" {
+		t.Fatalf("expected bob offline with last_seen, got %+v", e)
+	}
+
+	// No more events after unsubscribing
+	hub.unsubscribe(alice, []string{"bob"})
+	tablet := newTestClient(hub)
+	hub.authenticate(tablet, "2", "bob")
+	select {
+	case payload := <-alice.Send:
+		t.Errorf("unexpected event after unsubscribe: %s", payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHub_QuietConnectionGoesAway(t *testing.T) {
+	hub := NewHub()
+	hub.AwayAfter = 20 * time.Millisecond
+	go hub.Run()
+
+	alice := newTestClient(hub)
+	hub.authenticate(alice, "1", "alice")
+	hub.subscribe(alice, []string{"bob"})
+	bob := newTestClient(hub)
+	hub.authenticate(bob, "2", "bob")
+	nextEvent(t, alice) // online
+
+	time.Sleep(30 * time.Millisecond)
+	hub.refreshAllPresence()
+	if e := nextEvent(t, alice); e.Status != StatusAway {
+		t.Fatalf("expected bob away after going quiet, got %+v", e)
+	}
+}
+
+func TestHub_PresenceDoesNotWaitForSlowSubscribers(t *testing.T) {
+	hub := NewHub()
+	hub.SlowConsumer = SlowConsumerBlock
+	hub.SlowConsumerTimeout = time.Second
+	go hub.Run()
+	stopHubOnCleanup(t, hub)
+
+	// alice watches bob but never reads her one-frame queue
+	alice := &Client{Send: make(chan []byte, 1), subscriptions: make(map[string]bool), hub: hub}
+	hub.Register <- alice
+	hub.authenticate(alice, "1", "alice")
+	hub.subscribe(alice, []string{"bob"})
+	bob := newTestClient(hub)
+	hub.authenticate(bob, "2", "bob") // fills alice's queue
+
+	dropped := sendCount("dropped")
+	start := time.Now()
+	hub.Unregister <- bob
+	hub.Register <- newTestClient(hub)
+	if waited := time.Since(start); waited > 500*time.Millisecond {
+		t.Errorf("expected the hub to keep serving while presence is pushed, waited %s", waited)
+	}
+	deadline := time.Now().Add(time.Second)
+	for sendCount("dropped") == dropped && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("expected the offline event to be dropped without waiting")
+	}
+}
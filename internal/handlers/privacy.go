@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+// filterPresence returns what viewer may see of target's status and last_seen
+// under target's privacy settings and block list. An empty viewer is an anonymous request.
+// Hidden fields come back empty.
+func filterPresence(storeInstance *store.Store, target, viewer, status, lastSeen string) (string, string) {
+	if viewer != "" && viewer == target {
+		return status, lastSeen
+	}
+	settings, err := storeInstance.GetPrivacySettings(target)
+	if err != nil {
+		return "", ""
+	}
+	if settings.HideFromBlocked && viewer != "" {
+		if blocked, err := storeInstance.IsBlocked(target, viewer); err != nil || blocked {
+			return "", ""
+		}
+	}
+	contact, checked := false, false
+	allowed := func(visibility string) bool {
+		switch visibility {
+		case models.VisibilityEveryone:
+			return true
+		case models.VisibilityContacts:
+			if viewer == "" {
+				return false
+			}
+			if !checked {
+				contact, _ = storeInstance.IsContact(target, viewer)
+				checked = true
+			}
+			return contact
+		default:
+			return false
+		}
+	}
+	if settings.Invisible && status != "" {
+		status = StatusOffline
+	}
+	if !allowed(settings.OnlineStatus) {
+		status = ""
+	}
+	if !allowed(settings.LastSeen) {
+		lastSeen = ""
+	}
+	return status, lastSeen
+}
+
+// hiddenFromViewer reports whether target hides their public key and profile
+// from viewer because viewer is blocked.
+func hiddenFromViewer(storeInstance *store.Store, target, viewer string) bool {
+	if viewer == "" || viewer == target {
+		return false
+	}
+	blocked, err := storeInstance.IsBlocked(target, viewer)
+	if err != nil || !blocked {
+		return false
+	}
+	settings, err := storeInstance.GetPrivacySettings(target)
+	return err != nil || settings.HideFromBlocked
+}
+
+// PrivacyHandler serves GET and PUT /settings/privacy for the authenticated user.
+// PUT accepts a partial object; omitted fields keep their current value.
+func PrivacyHandler(storeInstance *store.Store, hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := authenticateRequest(r)
+		if err != nil {
+			writeError(w, CodeUnauthorized, "Unauthorized")
+			return
+		}
+		settings, err := storeInstance.GetPrivacySettings(claims.Username)
+		if err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPatch:
+			if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+				writeError(w, CodeBadRequest, "Invalid request")
+				return
+			}
+			if !models.ValidVisibility(settings.LastSeen) || !models.ValidVisibility(settings.OnlineStatus) {
+				writeError(w, CodeBadRequest, "Visibility must be everyone, contacts or nobody")
+				return
+			}
+			if err := storeInstance.SetPrivacySettings(claims.Username, settings); err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			// Subscribers may now see more or less than before
+			if hub != nil {
+				hub.publishPresence(claims.Username)
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT, PATCH")
+			writeError(w, CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+	}
+}
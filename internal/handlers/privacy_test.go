@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+func TestPresencePrivacy(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	for _, name := range []string{"alice", "bob", "mallory"} {
+		if err := storeInstance.CreateUser(&models.User{Username: name, Password: "x", PublicKey: "k"}); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	storeInstance.SetUserStatus("alice", StatusOnline)
+	storeInstance.SetUserLastSeen("alice", "2024-06-10 12:00:00")
+	storeInstance.RequestContact("bob", "alice") // bob is one of alice's contacts
+	storeInstance.AcceptContact("alice", "bob")
+
+	fetch := func(viewer string) PresenceResponse {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/users/alice/presence", nil)
+		if viewer != "" {
+			req.Header.Set("Authorization", "Bearer "+issueTestToken(t, viewer))
+		}
+		w := httptest.NewRecorder()
+		routed(UserHandler(storeInstance), userRoutes...)(w, req)
+		var resp PresenceResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+
+	// Defaults: everyone sees everything
+	if resp := fetch("mallory"); resp.Status != StatusOnline || resp.LastSeen == "" {
+		t.Errorf("expected public presence by default, got %+v", resp)
+	}
+
+	// Update settings through the endpoint
+	req := httptest.NewRequest(http.MethodPut, "/settings/privacy", strings.NewReader(`{"online_status":"contacts","last_seen":"nobody"}`))
+	req.Header.Set("Authorization", "Bearer "+issueTestToken(t, "alice"))
+	w := httptest.NewRecorder()
+	PrivacyHandler(storeInstance, nil)(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 updating settings, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if resp := fetch("mallory"); resp.Status != "" {
+		t.Errorf("stranger should not see status, got %+v", resp)
+	}
+	if resp := fetch("bob"); resp.Status != StatusOnline || resp.LastSeen != "" {
+		t.Errorf("contact should see status only, got %+v", resp)
+	}
+	if resp := fetch("alice"); resp.Status != StatusOnline || resp.LastSeen == "" {
+		t.Errorf("users always see their own presence, got %+v", resp)
+	}
+
+	// Invisible users appear offline even to contacts
+	storeInstance.SetPrivacySettings("alice", models.PrivacySettings{
+		LastSeen: models.VisibilityEveryone, OnlineStatus: models.VisibilityEveryone, Invisible: true, HideFromBlocked: true,
+	})
+	if resp := fetch("bob"); resp.Status != StatusOffline {
+		t.Errorf("expected invisible user to appear offline, got %+v", resp)
+	}
+
+	// Blocked users see neither presence nor the key bundle
+	storeInstance.Block("alice", "mallory")
+	req = httptest.NewRequest(http.MethodGet, "/users/alice/presence", nil)
+	req.Header.Set("Authorization", "Bearer "+issueTestToken(t, "mallory"))
+	w = httptest.NewRecorder()
+	routed(UserHandler(storeInstance), userRoutes...)(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 presence for blocked viewer, got %d", w.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/users/alice/public_key", nil)
+	req.Header.Set("Authorization", "Bearer "+issueTestToken(t, "mallory"))
+	w = httptest.NewRecorder()
+	routed(UserHandler(storeInstance), userRoutes...)(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 key for blocked viewer, got %d", w.Code)
+	}
+	// Anonymous requests can't slip past the block
+	for _, path := range []string{"/users/alice/presence", "/users/alice/public_key"} {
+		w = httptest.NewRecorder()
+		routed(UserHandler(storeInstance), userRoutes...)(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 for anonymous %s, got %d", path, w.Code)
+		}
+	}
+
+	// Invalid visibility values are rejected
+	req = httptest.NewRequest(http.MethodPut, "/settings/privacy", strings.NewReader(`{"online_status":"friends"}`))
+	req.Header.Set("Authorization", "Bearer "+issueTestToken(t, "alice"))
+	w = httptest.NewRecorder()
+	PrivacyHandler(storeInstance, nil)(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid visibility, got %d", w.Code)
+	}
+}
+
+func TestHub_InvisibleUserSendsNoPresence(t *testing.T) {
+	storeInstance := setupTestStore(t)
+	for _, name := range []string{"alice", "bob"} {
+		storeInstance.CreateUser(&models.User{Username: name, Password: "x", PublicKey: "k"})
+	}
+	storeInstance.SetUserLastSeen("bob", "2024-06-10 12:00:00")
+	storeInstance.SetPrivacySettings("bob", models.PrivacySettings{
+		LastSeen: models.VisibilityEveryone, OnlineStatus: models.VisibilityEveryone, Invisible: true, HideFromBlocked: true,
+	})
+	hub := NewHub()
+	go hub.Run()
+	stopHubOnCleanup(t, hub)
+
+	alice := newTestClient(hub)
+	hub.authenticate(alice, "1", "alice")
+	hub.subscribe(alice, []string{"bob"})
+
+	// bob comes and goes without his subscribers hearing about it
+	bob := newTestClient(hub)
+	hub.authenticate(bob, "2", "bob")
+	hub.touch(bob, HeartbeatIdle)
+	hub.Unregister <- bob
+	select {
+	case payload := <-alice.Send:
+		t.Errorf("unexpected presence event for an invisible user: %s", payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// and his last_seen doesn't move
+	user, _ := storeInstance.GetUserByUsername("bob")
+	if lastSeen := strings.NewReplacer("T", " ", "Z", "").Replace(user.LastSeen); lastSeen != "2024-06-10 12:00:00" {
+		t.Errorf("expected last_seen to stay frozen, got %s", user.LastSeen)
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+// canViewProfile reports whether viewer may read target's profile fields:
+// plaintext profiles are public, encrypted ones are for accepted contacts.
+func canViewProfile(storeInstance *store.Store, p *models.Profile, viewer string) bool {
+	if !p.Encrypted || viewer == p.Username {
+		return true
+	}
+	ok, err := storeInstance.IsContact(p.Username, viewer)
+	return err == nil && ok
+}
+
+// validateProfile checks field lengths; encrypted fields are limited in bytes.
+func validateProfile(p models.Profile) error {
+	if p.Encrypted {
+		if len(p.DisplayName) > models.MaxEncryptedProfileSize || len(p.Bio) > models.MaxEncryptedProfileSize {
+			return errors.New("Encrypted profile fields too large")
+		}
+		return nil
+	}
+	if utf8.RuneCountInString(p.DisplayName) > models.MaxDisplayNameLength {
+		return errors.New("Display name too long")
+	}
+	if utf8.RuneCountInString(p.Bio) > models.MaxBioLength {
+		return errors.New("Bio too long")
+	}
+	return nil
+}
+
+// handleProfile serves GET /users/:username/profile. Viewers who may not
+// read an encrypted profile only learn that it exists.
+func handleProfile(storeInstance *store.Store, w http.ResponseWriter, r *http.Request, username string) {
+	claims, err := authenticateRequest(r)
+	if err != nil {
+		writeError(w, CodeUnauthorized, "Unauthorized")
+		return
+	}
+	profile, err := storeInstance.GetProfile(username)
+	if err != nil || profile == nil || hiddenFromViewer(storeInstance, profile.Username, claims.Username) {
+		writeError(w, CodeNotFound, "User not found")
+		return
+	}
+	if !canViewProfile(storeInstance, profile, claims.Username) {
+		profile = &models.Profile{Username: profile.Username, Encrypted: true, Version: profile.Version}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// ProfileHandler serves GET and PUT /profile for the authenticated user.
+// PUT accepts a partial object; omitted fields keep their current value.
+// Contacts are told about changes with a profile event carrying the new version.
+func ProfileHandler(storeInstance *store.Store, hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := authenticateRequest(r)
+		if err != nil {
+			writeError(w, CodeUnauthorized, "Unauthorized")
+			return
+		}
+		profile, err := storeInstance.GetProfile(claims.Username)
+		if err != nil || profile == nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPatch:
+			update := *profile
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				writeError(w, CodeBadRequest, "Invalid request")
+				return
+			}
+			update.DisplayName = strings.TrimSpace(update.DisplayName)
+			if err := validateProfile(update); err != nil {
+				writeError(w, CodeBadRequest, err.Error())
+				return
+			}
+			profile, err = storeInstance.UpdateProfile(claims.Username, update)
+			if errors.Is(err, store.ErrInvalidAvatar) {
+				writeError(w, CodeBadRequest, "Avatar must be a completed attachment you uploaded")
+				return
+			}
+			if err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			notifyContacts(storeInstance, hub, claims.Username, Event{Type: EventProfile, Username: claims.Username, Version: profile.Version})
+		default:
+			w.Header().Set("Allow", "GET, PUT, PATCH")
+			writeError(w, CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(profile)
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+func TestProfiles(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if err := storeInstance.CreateUser(&models.User{Username: name, Password: "x", PublicKey: "k"}); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	storeInstance.RequestContact("alice", "bob")
+	storeInstance.AcceptContact("bob", "alice")
+
+	avatar, err := storeInstance.CreateAttachment("alice", 4, 0)
+	if err != nil {
+		t.Fatalf("failed to create attachment: %v", err)
+	}
+	storeInstance.SetAttachmentReceived(avatar.ID, 4)
+	storeInstance.CompleteAttachment(avatar.ID, strings.Repeat("a", 64))
+
+	update := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, "/profile", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+issueTestToken(t, "alice"))
+		w := httptest.NewRecorder()
+		ProfileHandler(storeInstance, nil)(w, req)
+		return w
+	}
+	fetch := func(viewer string) models.Profile {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/users/alice/profile", nil)
+		req.Header.Set("Authorization", "Bearer "+issueTestToken(t, viewer))
+		w := httptest.NewRecorder()
+		routed(UserHandler(storeInstance), userRoutes...)(w, req)
+		var p models.Profile
+		json.NewDecoder(w.Body).Decode(&p)
+		return p
+	}
+
+	if w := update(`{"avatar":"missing"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown avatar, got %d", w.Code)
+	}
+	if w := update(`{"bio":"` + strings.Repeat("x", models.MaxBioLength+1) + `"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for long bio, got %d", w.Code)
+	}
+
+	w := update(`{"display_name":"ciphertext-name","bio":"ciphertext-bio","avatar":"` + avatar.ID + `","encrypted":true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 updating profile, got %d: %s", w.Code, w.Body.String())
+	}
+	if p := fetch("bob"); p.DisplayName != "ciphertext-name" || p.Avatar != avatar.ID || p.Version != 1 {
+		t.Errorf("contact should see encrypted profile, got %+v", p)
+	}
+	if p := fetch("carol"); !p.Encrypted || p.DisplayName != "" || p.Avatar != "" {
+		t.Errorf("stranger should not see encrypted fields, got %+v", p)
+	}
+
+	// The avatar is downloadable by those who can see the profile
+	if ok, _ := storeInstance.CanAccessAttachment(avatar.ID, "bob"); !ok {
+		t.Error("contact should be able to download the avatar")
+	}
+	if ok, _ := storeInstance.CanAccessAttachment(avatar.ID, "carol"); ok {
+		t.Error("stranger should not be able to download the avatar")
+	}
+
+	// bob was offline, so the profile event waits in his queue
+	events, _ := storeInstance.TakePendingEvents("bob")
+	var event Event
+	if len(events) != 1 || json.Unmarshal(events[0], &event) != nil || event.Type != EventProfile || event.Version != 1 {
+		t.Errorf("expected one profile event for bob, got %s", events)
+	}
+	if events, _ := storeInstance.TakePendingEvents("carol"); len(events) != 0 {
+		t.Errorf("non-contacts should not be notified, got %s", events)
+	}
+}
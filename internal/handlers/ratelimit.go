@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter allows each key a fixed number of requests per window.
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// rateLimiterSweepSize is how many keys may accumulate before expired windows are dropped.
+const rateLimiterSweepSize = 1024
+
+// NewRateLimiter allows limit requests per key per window. A limit of zero
+// or less disables limiting.
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{limit: limit, window: window, now: time.Now, windows: make(map[string]*rateWindow)}
+}
+
+// Allow records a request for key and reports whether it is within the
+// limit; if not, it also returns how long until the window resets.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil || l.limit <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	w := l.windows[key]
+	if w == nil || now.Sub(w.start) >= l.window {
+		if len(l.windows) >= rateLimiterSweepSize {
+			for k, old := range l.windows {
+				if now.Sub(old.start) >= l.window {
+					delete(l.windows, k)
+				}
+			}
+		}
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/edpsouza/chatterbox/internal/apispec"
+	"github.com/edpsouza/chatterbox/internal/blobstore"
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+// APIPrefix is the path prefix of the current API version.
+const APIPrefix = "/v1"
+
+// RouterConfig holds the dependencies of the HTTP API.
+type RouterConfig struct {
+	Store         *store.Store
+	Hub           *Hub
+	Blobs         blobstore.BlobStore
+	AdminToken    string
+	SearchLimiter *RateLimiter
+	Attachments   AttachmentConfig
+}
+
+// NewRouter returns the HTTP API. Every route is served under /v1 and only
+// for its methods; other methods get 405 and unknown paths 404, both as
+// JSON. The unversioned paths remain as deprecated aliases.
+func NewRouter(cfg RouterConfig) http.Handler {
+	s, hub := cfg.Store, cfg.Hub
+	api := http.NewServeMux()
+
+	api.HandleFunc("GET /ws", func(w http.ResponseWriter, r *http.Request) {
+		ServeWS(hub, w, r)
+	})
+
+	api.HandleFunc("POST /register", RegisterHandler(s))
+	api.HandleFunc("POST /login", LoginHandler(s))
+	api.HandleFunc("POST /login/2fa", LoginTwoFactorHandler(s))
+	api.HandleFunc("POST /token/refresh", RefreshHandler(s))
+	api.HandleFunc("POST /logout", LogoutHandler(s, hub))
+
+	twoFactor := TwoFactorHandler(s)
+	api.HandleFunc("GET /2fa", twoFactor)
+	api.HandleFunc("POST /2fa/{action}", twoFactor)
+
+	api.HandleFunc("POST /password", PasswordHandler(s, hub))
+	api.HandleFunc("POST /password/reset", PasswordResetHandler(s, hub))
+
+	api.HandleFunc("POST /admin/unlock", AdminUnlockHandler(s, cfg.AdminToken))
+	api.HandleFunc("POST /admin/password-reset", AdminPasswordResetHandler(s, cfg.AdminToken))
+	api.HandleFunc("GET /admin/username-collisions", AdminUsernameCollisionsHandler(s, cfg.AdminToken))
+	api.HandleFunc("GET /admin/metrics", AdminMetricsHandler(cfg.AdminToken))
+
+	sessions := SessionsHandler(s, hub)
+	api.HandleFunc("GET /sessions", sessions)
+	api.HandleFunc("DELETE /sessions", sessions)
+	api.HandleFunc("DELETE /sessions/{id}", sessions)
+
+	account := AccountHandler(s, hub, cfg.Blobs)
+	api.HandleFunc("DELETE /account", account)
+	api.HandleFunc("GET /account/export", account)
+
+	api.HandleFunc("GET /users", UserSearchHandler(s, cfg.SearchLimiter))
+	users := UserHandler(s)
+	api.HandleFunc("GET /users/{username}/public_key", users)
+	api.HandleFunc("GET /users/{username}/presence", users)
+	api.HandleFunc("GET /users/{username}/profile", users)
+
+	messages := MessagesHandler(s, hub)
+	api.HandleFunc("GET /messages/{with}", messages)
+	api.HandleFunc("PATCH /messages/{with}/{id}", messages)
+	api.HandleFunc("PUT /messages/{with}/{id}", messages)
+	api.HandleFunc("DELETE /messages/{with}/{id}", messages)
+
+	conversations := ConversationsHandler(s)
+	api.HandleFunc("GET /conversations", conversations)
+	api.HandleFunc("POST /conversations/{peer}/read", conversations)
+
+	contacts := ContactsHandler(s, hub)
+	api.HandleFunc("GET /contacts", contacts)
+	api.HandleFunc("POST /contacts/{username}", contacts)
+	api.HandleFunc("DELETE /contacts/{username}", contacts)
+
+	blocks := BlocksHandler(s)
+	api.HandleFunc("GET /blocks", blocks)
+	api.HandleFunc("POST /blocks/{username}", blocks)
+	api.HandleFunc("DELETE /blocks/{username}", blocks)
+
+	profile := ProfileHandler(s, hub)
+	api.HandleFunc("GET /profile", profile)
+	api.HandleFunc("PUT /profile", profile)
+	api.HandleFunc("PATCH /profile", profile)
+
+	privacy := PrivacyHandler(s, hub)
+	api.HandleFunc("GET /settings/privacy", privacy)
+	api.HandleFunc("PUT /settings/privacy", privacy)
+	api.HandleFunc("PATCH /settings/privacy", privacy)
+
+	attachments := AttachmentsHandler(s, cfg.Blobs, cfg.Attachments)
+	api.HandleFunc("POST /attachments", attachments)
+	api.HandleFunc("HEAD /attachments/{id}", attachments)
+	api.HandleFunc("PATCH /attachments/{id}", attachments)
+	api.HandleFunc("GET /attachments/{id}", attachments)
+	api.HandleFunc("POST /attachments/{id}/token", attachments)
+
+	api.HandleFunc("GET /openapi.json", SpecHandler(apispec.OpenAPI))
+	api.HandleFunc("GET /asyncapi.json", SpecHandler(apispec.AsyncAPI))
+
+	root := http.NewServeMux()
+	root.Handle(APIPrefix+"/", http.StripPrefix(APIPrefix, apiRouter{mux: api}))
+	root.Handle("/", apiRouter{mux: api, deprecated: true})
+	return withRequestID(root)
+}
+
+// apiRouter dispatches to mux, answering unmatched requests with JSON errors.
+// Deprecated routers flag every response as coming from an old alias.
+type apiRouter struct {
+	mux        *http.ServeMux
+	deprecated bool
+}
+
+func (a apiRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, pattern := a.mux.Handler(r)
+	if pattern == "" {
+		// Let the mux decide between 404 and 405, then answer in JSON
+		rec := &fallbackRecorder{header: http.Header{}}
+		h.ServeHTTP(rec, r)
+		if rec.status == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", rec.header.Get("Allow"))
+			writeError(w, CodeMethodNotAllowed, "Method "+r.Method+" not allowed")
+			return
+		}
+		writeError(w, CodeNotFound, "No such endpoint")
+		return
+	}
+	if a.deprecated {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+APIPrefix+r.URL.Path+`>; rel="successor-version"`)
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+// fallbackRecorder captures the status and headers of the mux's own 404 and
+// 405 responses so they can be rewritten.
+type fallbackRecorder struct {
+	header http.Header
+	status int
+}
+
+func (f *fallbackRecorder) Header() http.Header { return f.header }
+
+func (f *fallbackRecorder) WriteHeader(status int) { f.status = status }
+
+func (f *fallbackRecorder) Write(b []byte) (int, error) {
+	if f.status == 0 {
+		f.status = http.StatusOK
+	}
+	return len(b), nil
+}
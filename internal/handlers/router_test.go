@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	router := NewRouter(RouterConfig{Store: storeInstance, Hub: NewHub(), SearchLimiter: NewRateLimiter(0, 0)})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	jsonError := func(w *httptest.ResponseRecorder) string {
+		t.Helper()
+		var body struct {
+			Code      string `json:"code"`
+			RequestID string `json:"request_id"`
+		}
+		if w.Header().Get("Content-Type") != "application/json" || json.NewDecoder(w.Body).Decode(&body) != nil {
+			t.Fatalf("expected a JSON error body, got %q", w.Body.String())
+		}
+		if body.RequestID == "" || body.RequestID != w.Header().Get("X-Request-ID") {
+			t.Errorf("expected the error to carry the request ID, got %q", body.RequestID)
+		}
+		return body.Code
+	}
+
+	register := `{"username":"alice","password":"secret123","public_key":"k"}`
+	w := do(http.MethodDelete, "/v1/register", register)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST" || jsonError(w) != "method_not_allowed" {
+		t.Errorf("expected JSON 405 allowing POST, got %d %q", w.Code, w.Header().Get("Allow"))
+	}
+	if user, _ := storeInstance.GetUserByUsername("alice"); user != nil {
+		t.Fatal("a DELETE must not register a user")
+	}
+
+	if w := do(http.MethodPost, "/v1/register", register); w.Code != http.StatusCreated || w.Header().Get("Deprecation") != "" {
+		t.Errorf("expected 201 without deprecation from /v1, got %d", w.Code)
+	}
+
+	// Old paths still work, marked as deprecated
+	w = do(http.MethodPost, "/login", `{"username":"alice","password":"secret123"}`)
+	if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "true" || !strings.Contains(w.Header().Get("Link"), "</v1/login>") {
+		t.Errorf("expected deprecated alias to log in, got %d %v", w.Code, w.Header())
+	}
+	if w := do(http.MethodGet, "/register", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 on alias too, got %d", w.Code)
+	}
+
+	for _, path := range []string{"/v1/nope", "/nope", "/v1/users/alice/unknown", "/v2/register"} {
+		if w := do(http.MethodGet, path, ""); w.Code != http.StatusNotFound || jsonError(w) != "not_found" {
+			t.Errorf("expected JSON 404 for %s, got %d", path, w.Code)
+		}
+	}
+
+	// Path parameters reach the handlers unchanged
+	req := httptest.NewRequest(http.MethodGet, "/v1/users/alice/public_key", nil)
+	req.Header.Set("Authorization", "Bearer "+issueTestToken(t, "bob"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"public_key":"k"`) {
+		t.Errorf("expected public key, got %d %s", w.Code, w.Body.String())
+	}
+}
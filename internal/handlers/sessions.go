@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+// clientIP returns the remote address of r without its port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// SessionsHandler lets users see and revoke their signed-in devices:
+//
+//	GET    /sessions      active sessions; the caller's own is marked current
+//	DELETE /sessions/:id  revoke a session and close its WebSocket connections
+//	DELETE /sessions      revoke every session except the current one
+func SessionsHandler(storeInstance *store.Store, hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := authenticateRequest(r)
+		if err != nil {
+			writeError(w, CodeUnauthorized, "Unauthorized")
+			return
+		}
+		id := r.PathValue("id")
+		switch {
+		case r.Method == http.MethodGet:
+			sessions, err := storeInstance.ListSessions(claims.Username)
+			if err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			for i := range sessions {
+				sessions[i].Current = sessions[i].ID == claims.SessionID
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(sessions)
+		case id == "":
+			revoked, err := storeInstance.RevokeUserSessions(claims.Username, claims.SessionID)
+			if err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			if hub != nil {
+				for _, id := range revoked {
+					hub.disconnectSession(id)
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			err := storeInstance.RevokeUserSession(claims.Username, id)
+			if errors.Is(err, store.ErrSessionNotFound) {
+				writeError(w, CodeNotFound, "Session not found")
+				return
+			}
+			if err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			if hub != nil {
+				hub.disconnectSession(id)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
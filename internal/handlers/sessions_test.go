@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+func TestSessionsHandler(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	hashed, _ := models.HashPassword("secret")
+	storeInstance.CreateUser(&models.User{Username: "alice", Password: hashed, PublicKey: "k"})
+	storeInstance.CreateUser(&models.User{Username: "bob", Password: hashed, PublicKey: "k"})
+
+	login := func(username, device, userAgent string) TokenResponse {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"`+username+`","password":"secret","device_name":"`+device+`"}`))
+		req.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		LoginHandler(storeInstance)(w, req)
+		var tokens TokenResponse
+		json.NewDecoder(w.Body).Decode(&tokens)
+		return tokens
+	}
+	laptop := login("alice", "laptop", "Firefox")
+	phone := login("alice", "phone", "Android")
+	bobs := login("bob", "desktop", "Chrome")
+
+	hub := NewHub()
+	go hub.Run()
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("User-Agent", "Android")
+		w := httptest.NewRecorder()
+		routed(SessionsHandler(storeInstance, hub), "GET /sessions", "DELETE /sessions", "DELETE /sessions/{id}")(w, req)
+		return w
+	}
+
+	var sessions []models.Session
+	json.NewDecoder(do(http.MethodGet, "/sessions", phone.Token).Body).Decode(&sessions)
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %+v", sessions)
+	}
+	var laptopSession models.Session
+	for _, s := range sessions {
+		if s.DeviceName == "laptop" {
+			laptopSession = s
+		} else if !s.Current || s.UserAgent != "Android" || s.IP == "" {
+			t.Errorf("unexpected phone session: %+v", s)
+		}
+	}
+
+	// The lost laptop's WebSocket connection is closed on revocation
+	claims, _ := parseAccessToken(laptop.Token)
+	client := newTestClient(hub)
+	client.sessionID = claims.SessionID
+	hub.authenticate(client, claims.UserID, claims.Username)
+
+	if w := do(http.MethodDelete, "/sessions/"+laptopSession.ID, bobs.Token); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 revoking someone else's session, got %d", w.Code)
+	}
+	if w := do(http.MethodDelete, "/sessions/"+laptopSession.ID, phone.Token); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 revoking session, got %d", w.Code)
+	}
+	for range client.Send {
+		// drain until the hub closes the channel
+	}
+	if _, err := parseAccessToken(laptop.Token); err == nil {
+		t.Error("revoked session's access token should be rejected")
+	}
+	if _, err := parseAccessToken(phone.Token); err != nil {
+		t.Errorf("other sessions should keep working: %v", err)
+	}
+	json.NewDecoder(do(http.MethodGet, "/sessions", phone.Token).Body).Decode(&sessions)
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("expected only the current session left, got %+v", sessions)
+	}
+}
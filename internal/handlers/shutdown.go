@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// shutdownPollInterval is how often Shutdown checks for drained connections.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// goingAwayWriteWait bounds writing the goodbye frames to a client.
+const goingAwayWriteWait = 5 * time.Second
+
+// Shutdown drains the hub when the server stops. New connections are
+// refused, and every client stops reading once the frame in hand is
+// handled, so in-flight messages are stored. Each client then receives its
+// queued frames, a going_away event carrying retryAfter and a going-away
+// close frame. Shutdown returns once every connection is closed, Run has
+// stopped and its presence updates are done, or when ctx is done, in which
+// case the remaining connections are closed abruptly.
+func (h *Hub) Shutdown(ctx context.Context, retryAfter time.Duration) error {
+	h.mu.Lock()
+	h.draining = true
+	h.retryAfter = retryAfter
+	var detached []*Client
+	for client := range h.Clients {
+		h.goAwayLocked(client)
+		if client.Conn == nil {
+			detached = append(detached, client)
+		}
+	}
+	h.mu.Unlock()
+
+	// Clients without a connection have no pumps to finish
+	for _, client := range detached {
+		select {
+		case h.Unregister <- client:
+		case <-ctx.Done():
+		}
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for h.pumps.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			h.closeAll()
+			h.quitOnce.Do(func() { close(h.quit) })
+			return fmt.Errorf("draining WebSocket connections: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	h.quitOnce.Do(func() { close(h.quit) })
+	select {
+	case <-h.stopped:
+	case <-ctx.Done():
+		return fmt.Errorf("stopping the hub: %w", ctx.Err())
+	}
+
+	// Run has stopped, so no more presence refreshes start
+	refreshed := make(chan struct{})
+	go func() {
+		h.background.Wait()
+		close(refreshed)
+	}()
+	select {
+	case <-refreshed:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("finishing presence updates: %w", ctx.Err())
+	}
+}
+
+// goAwayLocked interrupts c's readPump, which then unregisters the client so
+// writePump can flush and say goodbye. Callers hold h.mu.
+func (h *Hub) goAwayLocked(c *Client) {
+	c.goingAway = true
+	c.retryAfter = h.retryAfter
+	if c.Conn != nil {
+		c.Conn.SetReadDeadline(time.Now())
+	}
+}
+
+// closeAll closes every remaining connection without a goodbye.
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.Clients {
+		if client.Conn != nil {
+			client.Conn.Close()
+		}
+	}
+}
+
+// sayGoingAway tells the client the server is going away and when to
+// reconnect: a going_away event, then a going-away close frame.
+func (c *Client) sayGoingAway() {
+	seconds := retrySeconds(c.retryAfter)
+	deadline := time.Now().Add(goingAwayWriteWait)
+	c.Conn.SetWriteDeadline(deadline)
+	payload, err := json.Marshal(Event{Type: EventGoingAway, RetryAfter: seconds})
+	if err != nil {
+		return
+	}
+	if err := c.Conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+		return
+	}
+	reason := fmt.Sprintf("Server shutting down, retry in %ds", seconds)
+	c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, reason), deadline)
+}
+
+// retrySeconds rounds a retry hint up to whole seconds, at least one.
+func retrySeconds(d time.Duration) int {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHub_ShutdownDrainsConnections(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	setupTestStore(t)
+	hub := NewHub()
+	go hub.Run()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeWS(hub, w, r)
+	}))
+	defer server.Close()
+
+	dial := func() *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	bob := dial()
+	defer bob.Close()
+	bob.WriteJSON(map[string]string{"token": issueTestToken(t, "bob")})
+	if _, frame, err := bob.ReadMessage(); err != nil || string(frame) != "Authenticated" {
+		t.Fatalf("expected bob to authenticate, got %q %v", frame, err)
+	}
+	// A connection that never authenticated is told to go away too
+	anonymous := dial()
+	defer anonymous.Close()
+
+	// Frames queued before the shutdown are delivered before the goodbye
+	const queued = 20
+	for i := 1; i <= queued; i++ {
+		payload, _ := json.Marshal(Event{Type: EventMessage, ID: int64(i), From: "alice", To: "bob", Ciphertext: "..."})
+		if !hub.SendToUser("bob", payload) {
+			t.Fatal("expected bob to be connected")
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- hub.Shutdown(ctx, 3*time.Second)
+	}()
+
+	for i := 1; i <= queued; i++ {
+		var event Event
+		if err := bob.ReadJSON(&event); err != nil || event.ID != int64(i) {
+			t.Fatalf("expected queued message %d, got %+v %v", i, event, err)
+		}
+	}
+	for name, conn := range map[string]*websocket.Conn{"bob": bob, "anonymous": anonymous} {
+		var event Event
+		if err := conn.ReadJSON(&event); err != nil || event.Type != EventGoingAway || event.RetryAfter != 3 {
+			t.Fatalf("expected going_away with retry_after 3 for %s, got %+v %v", name, event, err)
+		}
+		_, _, err := conn.ReadMessage()
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("expected a going-away close frame for %s, got %v", name, err)
+		}
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("shutdown failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not finish")
+	}
+	select {
+	case <-hub.stopped:
+	default:
+		t.Error("expected Run to have stopped")
+	}
+
+	// Late connections are turned away with a retry hint
+	w := httptest.NewRecorder()
+	ServeWS(hub, w, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "3" {
+		t.Errorf("expected 503 with Retry-After 3, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestHub_ShutdownDeadline(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	// A connection whose pumps never finish holds the shutdown up
+	hub.pumps.Add(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := hub.Shutdown(ctx, time.Second); err == nil {
+		t.Error("expected Shutdown to give up at the deadline")
+	}
+}
+
+func TestHub_PumpsFinishAfterRunStops(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	setupTestStore(t)
+	hub := NewHub()
+	go hub.Run()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeWS(hub, w, r)
+	}))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+
+	// Run stops, as when Shutdown gives up, while the connection is still open
+	hub.quitOnce.Do(func() { close(hub.quit) })
+	<-hub.stopped
+	conn.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for hub.pumps.Load() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the pumps to finish, %d still running", hub.pumps.Load())
+		}
+		time.Sleep(shutdownPollInterval)
+	}
+}
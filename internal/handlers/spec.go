@@ -0,0 +1,11 @@
+package handlers
+
+import "net/http"
+
+// SpecHandler serves an embedded API description document.
+func SpecHandler(doc []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	}
+}
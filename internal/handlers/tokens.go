@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/store"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token lifetimes. Access tokens are short-lived; clients renew them with a
+// rotating refresh token.
+var (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// SetTokenLifetimes configures access and refresh token lifetimes.
+func SetTokenLifetimes(access, refresh time.Duration) {
+	accessTokenTTL = access
+	refreshTokenTTL = refresh
+}
+
+// TokenResponse is returned by login and refresh.
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // access token lifetime in seconds
+}
+
+// signAccessToken issues a short-lived access token for a session.
+func signAccessToken(userID int64, username, sessionID string) (string, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return "", errors.New("JWT secret not set")
+	}
+	jti, err := store.NewTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := Claims{
+		UserID:    strconv.FormatInt(userID, 10),
+		Username:  username,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
+
+// writeTokens sends an access/refresh token pair to the client.
+func writeTokens(w http.ResponseWriter, access, refresh string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TokenResponse{
+		Token:        access,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(accessTokenTTL / time.Second),
+	})
+}
+
+// parseAccessToken validates an access token's signature, expiry and
+// audience, and checks it against the revocation denylist.
+func parseAccessToken(tokenString string) (*Claims, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return nil, errors.New("JWT secret not set")
+	}
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	// Scoped tokens (e.g. attachment downloads) carry an audience and are not access tokens
+	if len(claims.Audience) > 0 {
+		return nil, errors.New("token is not an access token")
+	}
+	// Tokens without a jti cannot be revoked, so they are not accepted
+	if claims.ID == "" {
+		return nil, errors.New("token has no ID")
+	}
+	storeInstance, err := getStoreInstance()
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := storeInstance.IsTokenRevoked(claims.ID, claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("token revoked")
+	}
+	return claims, nil
+}
+
+// RefreshHandler serves POST /token/refresh {"refresh_token":"..."}, rotating
+// the refresh token and issuing a new access token for the same session.
+func RefreshHandler(storeInstance *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			writeError(w, CodeBadRequest, "Refresh token required")
+			return
+		}
+		username, sessionID, next, err := storeInstance.RotateRefreshToken(req.RefreshToken, refreshTokenTTL)
+		if errors.Is(err, store.ErrInvalidRefreshToken) {
+			writeError(w, CodeInvalidToken, "Invalid refresh token")
+			return
+		}
+		if err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		user, err := storeInstance.GetUserByUsername(username)
+		if err != nil || user == nil {
+			writeError(w, CodeInvalidToken, "Invalid refresh token")
+			return
+		}
+		access, err := signAccessToken(user.ID, user.Username, sessionID)
+		if err != nil {
+			writeError(w, CodeInternal, "JWT error")
+			return
+		}
+		writeTokens(w, access, next)
+	}
+}
+
+// LogoutHandler serves POST /logout: it revokes the caller's session and
+// access token and closes the session's WebSocket connections.
+func LogoutHandler(storeInstance *store.Store, hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		claims, err := authenticateRequest(r)
+		if err != nil {
+			writeError(w, CodeUnauthorized, "Unauthorized")
+			return
+		}
+		if claims.SessionID != "" {
+			if err := storeInstance.RevokeSession(claims.SessionID); err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+		}
+		if err := storeInstance.RevokeAccessToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		if hub != nil && claims.SessionID != "" {
+			hub.disconnectSession(claims.SessionID)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
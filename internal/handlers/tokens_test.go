@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+func TestRefreshAndLogout(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	hashed, _ := models.HashPassword("secret")
+	if err := storeInstance.CreateUser(&models.User{Username: "alice", Password: hashed, PublicKey: "k"}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	post := func(handler http.HandlerFunc, path, body, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	var login TokenResponse
+	w := post(LoginHandler(storeInstance), "/login", `{"username":"alice","password":"secret"}`, "")
+	json.NewDecoder(w.Body).Decode(&login)
+	if login.Token == "" || login.RefreshToken == "" || login.ExpiresIn <= 0 {
+		t.Fatalf("expected token pair, got %+v", login)
+	}
+
+	// Refreshing rotates the refresh token
+	var refreshed TokenResponse
+	w = post(RefreshHandler(storeInstance), "/token/refresh", `{"refresh_token":"`+login.RefreshToken+`"}`, "")
+	json.NewDecoder(w.Body).Decode(&refreshed)
+	if w.Code != http.StatusOK || refreshed.RefreshToken == login.RefreshToken {
+		t.Fatalf("expected rotated tokens, got %d %+v", w.Code, refreshed)
+	}
+
+	// A session-bound WebSocket connection is closed on logout
+	hub := NewHub()
+	go hub.Run()
+	client := newTestClient(hub)
+	claims, err := parseAccessToken(refreshed.Token)
+	if err != nil {
+		t.Fatalf("refreshed access token rejected: %v", err)
+	}
+	client.sessionID = claims.SessionID
+	hub.authenticate(client, claims.UserID, claims.Username)
+
+	if w := post(LogoutHandler(storeInstance, hub), "/logout", "", refreshed.Token); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 on logout, got %d", w.Code)
+	}
+	for range client.Send {
+		// drain until the hub closes the channel
+	}
+	for _, token := range []string{login.Token, refreshed.Token} {
+		if _, err := parseAccessToken(token); err == nil {
+			t.Error("access tokens of a logged-out session should be rejected")
+		}
+	}
+	w = post(RefreshHandler(storeInstance), "/token/refresh", `{"refresh_token":"`+refreshed.RefreshToken+`"}`, "")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected revoked refresh token to be rejected, got %d", w.Code)
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	sessionID, err := storeInstance.CreateSession("alice", "", "test", "127.0.0.1", refreshTokenTTL)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	first, err := storeInstance.CreateRefreshToken("alice", sessionID, refreshTokenTTL)
+	if err != nil {
+		t.Fatalf("failed to create refresh token: %v", err)
+	}
+	_, _, second, err := storeInstance.RotateRefreshToken(first, refreshTokenTTL)
+	if err != nil {
+		t.Fatalf("failed to rotate: %v", err)
+	}
+	// Replaying the first token looks like theft: the whole session goes
+	if _, _, _, err := storeInstance.RotateRefreshToken(first, refreshTokenTTL); err == nil {
+		t.Fatal("expected reused refresh token to be rejected")
+	}
+	if _, _, _, err := storeInstance.RotateRefreshToken(second, refreshTokenTTL); err == nil {
+		t.Error("expected session to be revoked after reuse")
+	}
+	if revoked, _ := storeInstance.IsTokenRevoked("any", sessionID); !revoked {
+		t.Error("expected access tokens of the session to be revoked")
+	}
+}
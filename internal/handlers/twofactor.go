@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/store"
+	"github.com/edpsouza/chatterbox/internal/totp"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// clock returns the current time for TOTP and lockout checks; tests replace it.
+var clock = time.Now
+
+// Two-factor settings.
+const (
+	totpIssuer        = "Chatterbox"
+	totpSkew          = 1 // accept codes one step either side of now
+	recoveryCodeCount = 10
+	loginChallengeTTL = 5 * time.Minute
+
+	// loginChallengeAudience keeps challenges from being used as access tokens.
+	loginChallengeAudience = "login-2fa"
+)
+
+// LoginChallengeClaims identify a login that passed the password check and
+// still needs a second factor.
+type LoginChallengeClaims struct {
+	Username   string `json:"username"`
+	DeviceName string `json:"device_name,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// twoFactorEnabled reports whether username must present a second factor.
+func twoFactorEnabled(storeInstance *store.Store, username string) (bool, error) {
+	tf, err := storeInstance.GetTwoFactor(username)
+	if err != nil {
+		return false, err
+	}
+	return tf != nil && tf.Enabled, nil
+}
+
+// verifySecondFactor accepts a current TOTP code, once, or an unused recovery code.
+func verifySecondFactor(storeInstance *store.Store, username, code string) (bool, error) {
+	tf, err := storeInstance.GetTwoFactor(username)
+	if err != nil || tf == nil || !tf.Enabled {
+		return false, err
+	}
+	if len(strings.TrimSpace(code)) == totp.Digits {
+		step, ok := totp.Validate(tf.Secret, code, clock(), totpSkew)
+		if !ok {
+			return false, nil
+		}
+		return storeInstance.UseTOTPStep(username, step)
+	}
+	return storeInstance.UseRecoveryCode(username, code)
+}
+
+// signLoginChallenge issues the token a client trades, with a code, for a session.
+func signLoginChallenge(username, deviceName string) (string, error) {
+	now := time.Now()
+	claims := LoginChallengeClaims{
+		Username:   username,
+		DeviceName: deviceName,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{loginChallengeAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(loginChallengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(os.Getenv("JWT_SECRET")))
+}
+
+// LoginTwoFactorHandler serves POST /login/2fa {"challenge":"...","code":"..."},
+// the second login step for users with two-factor authentication.
+func LoginTwoFactorHandler(storeInstance *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		var req struct {
+			Challenge string `json:"challenge"`
+			Code      string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Challenge == "" || req.Code == "" {
+			writeError(w, CodeBadRequest, "Challenge and code required")
+			return
+		}
+		claims := &LoginChallengeClaims{}
+		_, err := jwt.ParseWithClaims(req.Challenge, claims, func(t *jwt.Token) (interface{}, error) {
+			return []byte(os.Getenv("JWT_SECRET")), nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(loginChallengeAudience))
+		if err != nil {
+			writeError(w, CodeInvalidToken, "Invalid or expired challenge")
+			return
+		}
+		ip := clientIP(r)
+		release, wait := beginLoginAttempt(r.Context(), storeInstance, claims.Username, ip)
+		defer release()
+		if wait > 0 {
+			writeLockedOut(w, wait)
+			return
+		}
+		user, err := storeInstance.GetUserByUsername(claims.Username)
+		if err != nil || user == nil {
+			writeError(w, CodeInvalidCredentials, "Invalid credentials")
+			return
+		}
+		ok, err := verifySecondFactor(storeInstance, user.Username, req.Code)
+		if err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		if !ok {
+			recordLoginFailure(storeInstance, claims.Username, ip)
+			writeError(w, CodeInvalidCredentials, "Invalid two-factor code")
+			return
+		}
+		clearLoginFailures(storeInstance, claims.Username)
+		startSession(storeInstance, w, r, user, claims.DeviceName)
+	}
+}
+
+// TwoFactorStatus is returned by GET /2fa.
+type TwoFactorStatus struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// TwoFactorHandler manages TOTP enrollment for the authenticated user:
+//
+//	GET  /2fa                 whether 2FA is on and how many recovery codes are left
+//	POST /2fa/enroll          new pending secret and otpauth:// URI for a QR code
+//	POST /2fa/confirm         {"code"}: turn 2FA on; returns recovery codes once
+//	POST /2fa/recovery-codes  {"code"}: replace the recovery codes
+//	POST /2fa/disable         {"password","code"}: turn 2FA off
+func TwoFactorHandler(storeInstance *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := authenticateRequest(r)
+		if err != nil {
+			writeError(w, CodeUnauthorized, "Unauthorized")
+			return
+		}
+		action := r.PathValue("action")
+		var req struct {
+			Code     string `json:"code"`
+			Password string `json:"password"`
+		}
+		if r.Method == http.MethodPost && action != "enroll" {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+				writeError(w, CodeBadRequest, "Code required")
+				return
+			}
+		}
+		// Passwords and codes checked here count towards the lockout like failed logins
+		ip := clientIP(r)
+		if action == "recovery-codes" || action == "disable" {
+			release, wait := beginLoginAttempt(r.Context(), storeInstance, claims.Username, ip)
+			defer release()
+			if wait > 0 {
+				writeLockedOut(w, wait)
+				return
+			}
+		}
+
+		switch action {
+		case "":
+			status := TwoFactorStatus{}
+			status.Enabled, err = twoFactorEnabled(storeInstance, claims.Username)
+			if err == nil && status.Enabled {
+				status.RecoveryCodesRemaining, err = storeInstance.RemainingRecoveryCodes(claims.Username)
+			}
+			if err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			writeJSON(w, http.StatusOK, status)
+		case "enroll":
+			secret, err := totp.NewSecret()
+			if err != nil {
+				writeError(w, CodeInternal, "Failed to generate secret")
+				return
+			}
+			err = storeInstance.StartTwoFactor(claims.Username, secret)
+			if errors.Is(err, store.ErrTwoFactorEnabled) {
+				writeError(w, CodeConflict, "Two-factor authentication already enabled")
+				return
+			}
+			if err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{
+				"secret": secret,
+				"uri":    totp.ProvisioningURI(totpIssuer, claims.Username, secret),
+			})
+		case "confirm":
+			tf, err := storeInstance.GetTwoFactor(claims.Username)
+			if err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			if tf == nil || tf.Enabled {
+				writeError(w, CodeConflict, "No pending enrollment")
+				return
+			}
+			step, ok := totp.Validate(tf.Secret, req.Code, clock(), totpSkew)
+			if !ok {
+				writeError(w, CodeInvalidCredentials, "Invalid two-factor code")
+				return
+			}
+			codes, err := store.NewRecoveryCodes(recoveryCodeCount)
+			if err == nil {
+				err = storeInstance.EnableTwoFactor(claims.Username, step, codes)
+			}
+			if err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+		case "recovery-codes":
+			if !checkSecondFactor(storeInstance, w, claims.Username, ip, req.Code) {
+				return
+			}
+			codes, err := store.NewRecoveryCodes(recoveryCodeCount)
+			if err == nil {
+				err = storeInstance.SetRecoveryCodes(claims.Username, codes)
+			}
+			if err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+		case "disable":
+			user, err := checkPassword(storeInstance, claims.Username, req.Password)
+			if err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			if user == nil {
+				recordLoginFailure(storeInstance, claims.Username, ip)
+				writeError(w, CodeInvalidCredentials, "Invalid credentials")
+				return
+			}
+			if !checkSecondFactor(storeInstance, w, claims.Username, ip, req.Code) {
+				return
+			}
+			if err := storeInstance.DisableTwoFactor(claims.Username); err != nil {
+				writeError(w, CodeInternal, "Database error")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, CodeNotFound, "Unknown two-factor endpoint")
+		}
+	}
+}
+
+// checkSecondFactor verifies code for username, writing an error response
+// and returning false if it is not accepted. Wrong codes are counted against
+// username and ip.
+func checkSecondFactor(storeInstance *store.Store, w http.ResponseWriter, username, ip, code string) bool {
+	ok, err := verifySecondFactor(storeInstance, username, code)
+	if err != nil {
+		writeError(w, CodeInternal, "Database error")
+		return false
+	}
+	if !ok {
+		recordLoginFailure(storeInstance, username, ip)
+		writeError(w, CodeInvalidCredentials, "Invalid two-factor code")
+		return false
+	}
+	return true
+}
+
+// writeJSON sends v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
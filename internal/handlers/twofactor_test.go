@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+	"github.com/edpsouza/chatterbox/internal/totp"
+)
+
+func TestTwoFactorEnrollmentAndLogin(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	storeInstance := setupTestStore(t)
+	hashed, _ := models.HashPassword("secret")
+	storeInstance.CreateUser(&models.User{Username: "alice", Password: hashed, PublicKey: "k"})
+
+	now := time.Unix(1700000000, 0)
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = time.Now })
+	codeAt := func(secret string, at time.Time) string {
+		code, _ := totp.Code(secret, totp.Step(at))
+		return code
+	}
+
+	token := issueTestToken(t, "alice")
+	twoFactor := func(path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		routed(TwoFactorHandler(storeInstance), twoFactorRoutes...)(w, req)
+		return w
+	}
+	login := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		LoginHandler(storeInstance)(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body)))
+		return w
+	}
+
+	var enroll map[string]string
+	json.NewDecoder(twoFactor("/2fa/enroll", "").Body).Decode(&enroll)
+	secret := enroll["secret"]
+	if secret == "" || !strings.HasPrefix(enroll["uri"], "otpauth://totp/") {
+		t.Fatalf("unexpected enrollment: %v", enroll)
+	}
+	if w := twoFactor("/2fa/confirm", `{"code":"000000"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected wrong code to be rejected, got %d", w.Code)
+	}
+	var confirmed map[string][]string
+	w := twoFactor("/2fa/confirm", `{"code":"`+codeAt(secret, now)+`"}`)
+	json.NewDecoder(w.Body).Decode(&confirmed)
+	recovery := confirmed["recovery_codes"]
+	if w.Code != http.StatusOK || len(recovery) != recoveryCodeCount {
+		t.Fatalf("expected recovery codes, got %d %v", w.Code, confirmed)
+	}
+
+	// The password alone now yields a challenge instead of tokens
+	var challenge map[string]string
+	w = login(`{"username":"alice","password":"secret"}`)
+	json.NewDecoder(w.Body).Decode(&challenge)
+	if w.Code != http.StatusUnauthorized || challenge["code"] != "two_factor_required" || challenge["challenge"] == "" {
+		t.Fatalf("expected 2FA challenge, got %d %v", w.Code, challenge)
+	}
+	if _, err := parseAccessToken(challenge["challenge"]); err == nil {
+		t.Fatal("challenge must not work as an access token")
+	}
+	secondStep := func(code string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body := `{"challenge":"` + challenge["challenge"] + `","code":"` + code + `"}`
+		LoginTwoFactorHandler(storeInstance)(w, httptest.NewRequest(http.MethodPost, "/login/2fa", strings.NewReader(body)))
+		return w
+	}
+
+	// The code used to confirm enrollment cannot be replayed
+	if w := secondStep(codeAt(secret, now)); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected replayed code to be rejected, got %d", w.Code)
+	}
+	now = now.Add(totp.Period)
+	if w := secondStep(codeAt(secret, now)); w.Code != http.StatusOK {
+		t.Errorf("expected second step to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Inline codes and recovery codes also work, each recovery code once
+	now = now.Add(totp.Period)
+	if w := login(`{"username":"alice","password":"secret","code":"` + codeAt(secret, now) + `"}`); w.Code != http.StatusOK {
+		t.Errorf("expected inline code login to succeed, got %d", w.Code)
+	}
+	if w := login(`{"username":"alice","password":"secret","code":"` + strings.ToUpper(recovery[0]) + `"}`); w.Code != http.StatusOK {
+		t.Errorf("expected recovery code login to succeed, got %d", w.Code)
+	}
+	if w := login(`{"username":"alice","password":"secret","code":"` + recovery[0] + `"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected used recovery code to be rejected, got %d", w.Code)
+	}
+
+	// Disabling needs the password and a code
+	if w := twoFactor("/2fa/disable", `{"password":"wrong","code":"`+recovery[1]+`"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected wrong password to be rejected, got %d", w.Code)
+	}
+	if w := twoFactor("/2fa/disable", `{"password":"secret","code":"`+recovery[1]+`"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 2FA to be disabled, got %d", w.Code)
+	}
+	if w := login(`{"username":"alice","password":"secret"}`); w.Code != http.StatusOK {
+		t.Errorf("expected password-only login after disabling, got %d", w.Code)
+	}
+}
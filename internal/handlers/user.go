@@ -3,39 +3,46 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
-	"strings"
+	"path"
 
 	"github.com/edpsouza/chatterbox/internal/store"
 )
 
-// UserHandler dispatches /users/:username/public_key and /users/:username/presence endpoints.
+// UserHandler dispatches /users/{username}/public_key, /presence and /profile endpoints.
 func UserHandler(storeInstance *store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Path: /users/:username/public_key or /users/:username/presence
-		parts := strings.FieldsFunc(r.URL.Path, func(r rune) bool { return r == '/' })
-		if len(parts) < 3 || parts[1] == "" {
-			http.Error(w, "Invalid path. Use /users/:username/public_key or /users/:username/presence", http.StatusBadRequest)
+		username := r.PathValue("username")
+		if username == "" {
+			writeError(w, CodeBadRequest, "Invalid path. Use /users/{username}/public_key, /presence or /profile")
 			return
 		}
-		username := parts[1]
-		action := parts[2]
 
-		switch action {
+		// The routes differ only in their last segment
+		switch path.Base(r.URL.Path) {
 		case "public_key":
 			handlePublicKey(storeInstance, w, r, username)
 		case "presence":
 			handlePresence(storeInstance, w, r, username)
+		case "profile":
+			handleProfile(storeInstance, w, r, username)
 		default:
-			http.Error(w, "Unknown action. Use /public_key or /presence", http.StatusNotFound)
+			writeError(w, CodeNotFound, "Unknown action. Use /public_key, /presence or /profile")
 		}
 	}
 }
 
-// handlePublicKey serves the user's public key.
+// handlePublicKey serves the user's public key to an authenticated
+// requester. Users who blocked the requester may hide it; the response is
+// then indistinguishable from an unknown user.
 func handlePublicKey(storeInstance *store.Store, w http.ResponseWriter, r *http.Request, username string) {
+	claims, err := authenticateRequest(r)
+	if err != nil {
+		writeError(w, CodeUnauthorized, "Unauthorized")
+		return
+	}
 	user, err := storeInstance.GetUserByUsername(username)
-	if err != nil || user == nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	if err != nil || user == nil || hiddenFromViewer(storeInstance, user.Username, claims.Username) {
+		writeError(w, CodeNotFound, "User not found")
 		return
 	}
 	resp := map[string]string{
@@ -49,22 +56,52 @@ func handlePublicKey(storeInstance *store.Store, w http.ResponseWriter, r *http.
 // PresenceResponse represents the user's presence info.
 type PresenceResponse struct {
 	Username string `json:"username"`
-	Status   string `json:"status"`
+	Status   string `json:"status,omitempty"`
 	LastSeen string `json:"last_seen,omitempty"`
 }
 
-// handlePresence serves user presence info (status and last_seen).
+// handlePresence serves user presence info (status and last_seen) to an
+// authenticated requester, hiding whatever the user's privacy settings keep
+// from them.
 func handlePresence(storeInstance *store.Store, w http.ResponseWriter, r *http.Request, username string) {
+	claims, err := authenticateRequest(r)
+	if err != nil {
+		writeError(w, CodeUnauthorized, "Unauthorized")
+		return
+	}
 	user, err := storeInstance.GetUserByUsername(username)
-	if err != nil || user == nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	if err != nil || user == nil || hiddenFromViewer(storeInstance, user.Username, claims.Username) {
+		writeError(w, CodeNotFound, "User not found")
 		return
 	}
+	status, lastSeen := filterPresence(storeInstance, user.Username, claims.Username, user.Status, user.LastSeen)
 	resp := PresenceResponse{
 		Username: user.Username,
-		Status:   user.Status,
-		LastSeen: user.LastSeen,
+		Status:   status,
+		LastSeen: lastSeen,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+// AdminUsernameCollisionsHandler serves GET /admin/username-collisions,
+// listing accounts whose names clashed with another account when usernames
+// were normalized. It requires "Authorization: Bearer <ADMIN_TOKEN>".
+func AdminUsernameCollisionsHandler(storeInstance *store.Store, adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(w, r, adminToken) {
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeError(w, CodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		collisions, err := storeInstance.UsernameCollisions()
+		if err != nil {
+			writeError(w, CodeInternal, "Database error")
+			return
+		}
+		writeJSON(w, http.StatusOK, collisions)
+	}
+}
@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/edpsouza/chatterbox/internal/models"
 	"github.com/edpsouza/chatterbox/internal/store"
@@ -28,6 +31,45 @@ type Client struct {
 	UserID        string
 	Username      string
 	Authenticated bool
+
+	// Frames go through offer, which holds sendMu for reading while it
+	// sends on Send; closeSend holds it for writing, so Send is closed once
+	// and never while a frame is being queued. closeSend first closes
+	// sendStop so offers waiting for room let go of sendMu at once.
+	hub          *Hub
+	sendMu       sync.RWMutex
+	sendClosed   bool
+	sendStop     chan struct{}
+	sendStopInit sync.Once
+	sendStopOnce sync.Once
+
+	// sessionID is the login session the connection belongs to, so revoking
+	// the session can close it. Guarded by the hub's mutex. Password logins
+	// get an ephemeral session that ends with the connection.
+	sessionID        string
+	ephemeralSession bool
+	userAgent        string
+	ip               string
+
+	// Presence tracking, guarded by the hub's mutex
+	idle          bool
+	lastActive    time.Time
+	subscriptions map[string]bool
+
+	// Keepalive, guarded by the hub's mutex: lastHeard is when the peer last
+	// sent a frame or pong, timedOut whether it was dropped for going silent.
+	lastHeard time.Time
+	timedOut  bool
+	writeWait time.Duration
+
+	// goingAway is set, under the hub's mutex, when the server shuts down;
+	// writePump then says goodbye with a retryAfter hint.
+	goingAway  bool
+	retryAfter time.Duration
+
+	// rejected is set by readPump, and only read there, when it ends the
+	// connection with an error event that writePump has yet to send.
+	rejected bool
 }
 
 // Hub maintains the set of active clients and broadcasts messages.
@@ -37,50 +79,172 @@ type Hub struct {
 	Register   chan *Client
 	Unregister chan *Client
 	mu         sync.Mutex
+
+	// AwayAfter is how long a connection may stay quiet before its user shows as away.
+	AwayAfter time.Duration
+
+	// SlowConsumer decides what happens to a frame for a connection whose
+	// send queue is full: SlowConsumerDrop spills it to the user's offline
+	// queue, SlowConsumerDisconnect also closes the connection, and
+	// SlowConsumerBlock first waits up to SlowConsumerTimeout for room.
+	SlowConsumer        string
+	SlowConsumerTimeout time.Duration
+
+	// Connection keepalive and limits: the server pings every PingInterval
+	// and drops connections not heard from for PongWait, writes fail after
+	// WriteWait, and frames over MaxMessageSize bytes close the connection.
+	// Zero disables each.
+	PingInterval   time.Duration
+	PongWait       time.Duration
+	WriteWait      time.Duration
+	MaxMessageSize int64
+
+	presence      map[string]string           // username -> status, for connected users
+	subscriptions map[string]map[*Client]bool // watched username -> subscribers
+	presenceMu    sync.Mutex                  // serializes presence transitions
+
+	// Shutdown state: draining and retryAfter are guarded by mu, pumps
+	// counts running read and write pumps, and closing quit stops Run.
+	draining   bool
+	retryAfter time.Duration
+	pumps      atomic.Int64
+	background sync.WaitGroup // presence refreshes started by Run
+	quit       chan struct{}
+	quitOnce   sync.Once
+	stopped    chan struct{}
 }
 
 // NewHub initializes a new Hub.
 func NewHub() *Hub {
 	return &Hub{
-		Clients:    make(map[*Client]bool),
-		Broadcast:  make(chan []byte),
-		Register:   make(chan *Client),
-		Unregister: make(chan *Client),
+		Clients:             make(map[*Client]bool),
+		Broadcast:           make(chan []byte),
+		Register:            make(chan *Client),
+		Unregister:          make(chan *Client),
+		AwayAfter:           5 * time.Minute,
+		SlowConsumer:        SlowConsumerBlock,
+		SlowConsumerTimeout: 5 * time.Second,
+		PingInterval:        30 * time.Second,
+		PongWait:            60 * time.Second,
+		WriteWait:           10 * time.Second,
+		MaxMessageSize:      64 << 10,
+		presence:            make(map[string]string),
+		subscriptions:       make(map[string]map[*Client]bool),
+		quit:                make(chan struct{}),
+		stopped:             make(chan struct{}),
 	}
 }
 
-// Run starts the Hub's main loop.
+// Run starts the Hub's main loop. It returns once Shutdown has drained
+// every connection.
 func (h *Hub) Run() {
+	defer close(h.stopped)
+	ticker := time.NewTicker(presenceCheckInterval)
+	defer ticker.Stop()
 	for {
 		select {
+		case <-h.quit:
+			return
 		case client := <-h.Register:
 			h.mu.Lock()
 			h.Clients[client] = true
+			if h.draining {
+				h.goAwayLocked(client)
+			}
 			h.mu.Unlock()
 		case client := <-h.Unregister:
 			h.mu.Lock()
-			if _, ok := h.Clients[client]; ok {
+			_, ok := h.Clients[client]
+			if ok {
 				delete(h.Clients, client)
-				close(client.Send)
+			}
+			for username := range client.subscriptions {
+				h.removeSubscriptionLocked(client, username)
+			}
+			// A connection that went silent was last seen when last heard from
+			seen := time.Now()
+			if client.timedOut {
+				seen = client.lastHeard
 			}
 			h.mu.Unlock()
+			if ok {
+				client.closeSend()
+			}
+			if ok && client.Authenticated {
+				username := client.Username
+				h.inBackground(func() { h.refreshPresenceSeen(username, seen) })
+			}
+		case <-ticker.C:
+			h.inBackground(h.refreshAllPresence)
 		case message := <-h.Broadcast:
 			h.mu.Lock()
+			clients := make([]*Client, 0, len(h.Clients))
 			for client := range h.Clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.Clients, client)
-				}
+				clients = append(clients, client)
 			}
 			h.mu.Unlock()
+			for _, client := range clients {
+				client.offerNow(message)
+			}
 		}
 	}
 }
 
-// ServeWS handles WebSocket requests from clients, authenticating with username/password as first message.
+// inBackground runs f outside the Run loop, so the store queries and sends
+// of a presence refresh never hold up registrations or broadcasts.
+// Shutdown waits for it.
+func (h *Hub) inBackground(f func()) {
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		f()
+	}()
+}
+
+// disconnect closes every authenticated connection matching match. The
+// read pumps then unregister them and presence is updated as usual.
+func (h *Hub) disconnect(match func(c *Client) bool) {
+	h.mu.Lock()
+	var matched []*Client
+	for client := range h.Clients {
+		if client.Authenticated && match(client) {
+			matched = append(matched, client)
+		}
+	}
+	h.mu.Unlock()
+	for _, client := range matched {
+		if client.Conn != nil {
+			client.Conn.Close()
+		} else {
+			select {
+			case h.Unregister <- client:
+			case <-h.quit:
+			}
+		}
+	}
+}
+
+// disconnectUser closes all of username's connections, e.g. after a password change.
+func (h *Hub) disconnectUser(username string) {
+	h.disconnect(func(c *Client) bool { return c.Username == username })
+}
+
+// disconnectSession closes the connections opened with a revoked session's tokens.
+func (h *Hub) disconnectSession(sessionID string) {
+	h.disconnect(func(c *Client) bool { return c.sessionID == sessionID })
+}
+
+// ServeWS handles WebSocket requests from clients, authenticating with an
+// access token or username/password as the first message.
 func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	hub.mu.Lock()
+	draining, retryAfter := hub.draining, hub.retryAfter
+	hub.mu.Unlock()
+	if draining {
+		w.Header().Set("Retry-After", strconv.Itoa(retrySeconds(retryAfter)))
+		writeError(w, CodeUnavailable, "Server is shutting down")
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
@@ -90,154 +254,446 @@ func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request) {
 		Conn:          conn,
 		Send:          make(chan []byte, 256),
 		Authenticated: false,
+		subscriptions: make(map[string]bool),
+		userAgent:     r.UserAgent(),
+		ip:            clientIP(r),
+		writeWait:     hub.WriteWait,
+		hub:           hub,
+	}
+	hub.pumps.Add(2)
+	select {
+	case hub.Register <- client:
+	case <-hub.quit:
+		hub.pumps.Add(-2)
+		conn.Close()
+		return
 	}
-	hub.Register <- client
 
 	// Start goroutines for reading and writing
-	go client.writePump()
+	go client.writePump(hub)
 	go client.readPump(hub)
 }
 
 // readPump reads messages from the WebSocket connection and broadcasts them.
 func (c *Client) readPump(hub *Hub) {
 	defer func() {
-		hub.Unregister <- c
-		c.Conn.Close()
-		// Set user status to offline and update last_seen
-		storeInstance, err := getStoreInstance()
-		if err == nil && c.Username != "" {
-			_ = storeInstance.SetUserStatus(c.Username, "offline")
-			_ = storeInstance.SetUserLastSeenNow(c.Username)
+		// The hub marks the user offline once their last connection is gone.
+		// If Shutdown gave up and Run has stopped, close the queue here so
+		// writePump can finish.
+		select {
+		case hub.Unregister <- c:
+		case <-hub.quit:
+			c.closeSend()
 		}
+		hub.mu.Lock()
+		goingAway := c.goingAway
+		hub.mu.Unlock()
+		if !goingAway && !c.rejected {
+			// When draining or rejecting, writePump closes the connection
+			// after flushing
+			c.Conn.Close()
+		}
+		if c.ephemeralSession {
+			if storeInstance, err := getStoreInstance(); err == nil {
+				_ = storeInstance.RevokeSession(c.sessionID)
+			}
+		}
+		hub.pumps.Add(-1)
 	}()
 
+	c.Conn.SetReadLimit(hub.MaxMessageSize)
+	c.Conn.SetPongHandler(func(string) error {
+		hub.heard(c)
+		return nil
+	})
+	hub.heard(c)
+
 	authChecked := false
 
 	for {
 		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
+			hub.markTimedOut(c, err)
 			break
 		}
+		hub.heard(c)
 
 		if !authChecked {
-			// Expect first message to be JSON: {"username":"...","password":"..."}
+			// Expect first message to be JSON: {"token":"<access token>"} or
+			// {"username":"...","password":"..."}
 			type AuthMsg struct {
+				Token    string `json:"token"`
 				Username string `json:"username"`
 				Password string `json:"password"`
+				Code     string `json:"code"` // required once 2FA is enabled
 			}
 			var auth AuthMsg
 			if err := json.Unmarshal(message, &auth); err != nil {
-				c.Conn.WriteMessage(websocket.TextMessage, []byte("Invalid auth message format"))
+				c.reject(CodeBadRequest, "Invalid auth message format")
 				break
 			}
+			if auth.Token != "" {
+				claims, err := parseAccessToken(auth.Token)
+				if err != nil {
+					c.reject(CodeInvalidCredentials, "Invalid credentials")
+					break
+				}
+				storeInstance, err := getStoreInstance()
+				if err != nil {
+					c.reject(CodeInternal, "Server error")
+					break
+				}
+				if claims.SessionID != "" {
+					_ = storeInstance.TouchSession(claims.SessionID, c.userAgent, c.ip)
+				}
+				hub.mu.Lock()
+				c.sessionID = claims.SessionID
+				hub.mu.Unlock()
+				hub.authenticate(c, claims.UserID, claims.Username)
+				c.sendText("Authenticated")
+				authChecked = true
+				c.flushPendingEvents(storeInstance)
+				continue
+			}
 			if auth.Username == "" || auth.Password == "" {
-				c.Conn.WriteMessage(websocket.TextMessage, []byte("Username and password required"))
+				c.reject(CodeBadRequest, "Username and password required")
 				break
 			}
 
 			// Authenticate user
 			storeInstance, err := getStoreInstance()
 			if err != nil {
-				c.Conn.WriteMessage(websocket.TextMessage, []byte("Server error"))
+				c.reject(CodeInternal, "Server error")
 				break
 			}
-			user, err := storeInstance.GetUserByUsername(auth.Username)
-			if err != nil || user == nil {
-				c.Conn.WriteMessage(websocket.TextMessage, []byte("Invalid credentials"))
+			// Every failure below ends the connection, which releases the attempt
+			release, wait := beginLoginAttempt(context.Background(), storeInstance, auth.Username, c.ip)
+			defer release()
+			if wait > 0 {
+				c.reject(CodeRateLimited, "Too many failed attempts")
 				break
 			}
-			ok, err := models.VerifyPassword(user.Password, auth.Password)
-			if err != nil || !ok {
-				c.Conn.WriteMessage(websocket.TextMessage, []byte("Invalid credentials"))
+			user, err := checkPassword(storeInstance, auth.Username, auth.Password)
+			if err != nil {
+				c.reject(CodeInternal, "Server error")
 				break
 			}
-			c.UserID = strconv.FormatInt(user.ID, 10)
-			c.Username = user.Username
-			c.Authenticated = true
-
-			// Set user status to online
-			if storeInstance != nil {
-				_ = storeInstance.SetUserStatus(c.Username, "online")
+			if user == nil {
+				recordLoginFailure(storeInstance, auth.Username, c.ip)
+				c.reject(CodeInvalidCredentials, "Invalid credentials")
+				break
 			}
+			enabled, err := twoFactorEnabled(storeInstance, user.Username)
+			if err != nil {
+				c.reject(CodeInternal, "Server error")
+				break
+			}
+			if enabled {
+				if auth.Code == "" {
+					c.reject(CodeTwoFactorRequired, "Two-factor code required")
+					break
+				}
+				if ok, err := verifySecondFactor(storeInstance, user.Username, auth.Code); err != nil || !ok {
+					recordLoginFailure(storeInstance, auth.Username, c.ip)
+					c.reject(CodeInvalidCredentials, "Invalid credentials")
+					break
+				}
+			}
+			clearLoginFailures(storeInstance, auth.Username)
+			release()
+			// List the connection as a device until it closes
+			sessionID, err := storeInstance.CreateSession(user.Username, "", c.userAgent, c.ip, 0)
+			if err != nil {
+				c.reject(CodeInternal, "Server error")
+				break
+			}
+			hub.mu.Lock()
+			c.sessionID = sessionID
+			c.ephemeralSession = true
+			hub.mu.Unlock()
 
-			c.Conn.WriteMessage(websocket.TextMessage, []byte("Authenticated"))
+			// Mark the connection authenticated and the user online
+			hub.authenticate(c, strconv.FormatInt(user.ID, 10), user.Username)
+
+			c.sendText("Authenticated")
 			authChecked = true
+			c.flushPendingEvents(storeInstance)
 			continue
 		}
 
 		if !c.Authenticated {
-			c.Conn.WriteMessage(websocket.TextMessage, []byte("Not authenticated"))
+			c.reject(CodeUnauthorized, "Not authenticated")
 			break
 		}
 
-		// Parse message as JSON: {"to":"recipient_username","ciphertext":"..."}
+		// Parse message as JSON: {"type":"message","to":"recipient_username","ciphertext":"...","reply_to":123}
+		// Edits send {"type":"edit","id":123,"ciphertext":"..."}, deletes {"type":"delete","id":123}.
+		// Reactions send {"type":"react","id":123,"ciphertext":"..."} or {"type":"unreact","id":123}.
+		// Presence: {"type":"subscribe","users":["..."]}, {"type":"unsubscribe","users":["..."]},
+		// and {"type":"heartbeat","state":"active"|"idle"}.
+		// Attachments are referenced by ID: {"to":"...","ciphertext":"...","attachments":["<id>"]};
+		// the key for each attachment travels inside the ciphertext.
 		type ChatMsg struct {
-			To         string `json:"to"`
-			Ciphertext string `json:"ciphertext"`
+			Type       string   `json:"type"`
+			ID         int64    `json:"id"`
+			To         string   `json:"to"`
+			Ciphertext string   `json:"ciphertext"`
+			ReplyTo    int64    `json:"reply_to"`
+			Users      []string `json:"users"`
+			State      string   `json:"state"`
+
+			Attachments []string `json:"attachments"`
 		}
 		var chatMsg ChatMsg
 		if err := json.Unmarshal(message, &chatMsg); err != nil {
-			c.Conn.WriteMessage(websocket.TextMessage, []byte("Invalid chat message format"))
+			c.sendError(CodeBadRequest, "Invalid chat message format")
+			continue
+		}
+
+		storeInstance, err := getStoreInstance()
+		switch chatMsg.Type {
+		case EventHeartbeat:
+			if chatMsg.State != "" && chatMsg.State != HeartbeatActive && chatMsg.State != HeartbeatIdle {
+				c.sendError(CodeBadRequest, "Unknown heartbeat state")
+				continue
+			}
+			hub.touch(c, chatMsg.State)
+			continue
+		case EventSubscribe:
+			for _, event := range hub.subscribe(c, chatMsg.Users) {
+				c.sendEvent(event)
+			}
+			continue
+		case EventUnsubscribe:
+			hub.unsubscribe(c, chatMsg.Users)
 			continue
 		}
+
+		// Any other frame counts as activity
+		hub.touch(c, HeartbeatActive)
+		switch chatMsg.Type {
+		case "", EventMessage:
+		case EventEdit, EventDelete:
+			if err != nil {
+				c.sendError(CodeInternal, "Server error")
+				continue
+			}
+			c.handleMessageChange(storeInstance, hub, chatMsg.Type, chatMsg.ID, chatMsg.Ciphertext)
+			continue
+		case EventReact, EventUnreact:
+			if err != nil {
+				c.sendError(CodeInternal, "Server error")
+				continue
+			}
+			c.handleReaction(storeInstance, hub, chatMsg.Type, chatMsg.ID, chatMsg.Ciphertext)
+			continue
+		default:
+			c.sendError(CodeBadRequest, "Unknown message type")
+			continue
+		}
+
+		chatMsg.To = resolveUsername(storeInstance, chatMsg.To)
 		if chatMsg.To == "" || chatMsg.Ciphertext == "" {
-			c.Conn.WriteMessage(websocket.TextMessage, []byte("Recipient and ciphertext required"))
+			c.sendError(CodeBadRequest, "Recipient and ciphertext required")
 			continue
 		}
 
-		// Store ciphertext to DB
-		storeInstance, err := getStoreInstance()
-		if err == nil {
-			_ = storeInstance.CreateMessage(
-				func() int64 {
-					id, _ := strconv.ParseInt(c.UserID, 10, 64)
-					return id
-				}(),
-				c.Username,
-				chatMsg.To,
-				chatMsg.Ciphertext,
-			)
+		// Store ciphertext to DB; unstored messages are neither acked nor relayed
+		if err != nil {
+			c.sendError(CodeInternal, "Server error")
+			continue
 		}
+		if !canMessage(storeInstance, c.Username, chatMsg.To) {
+			// Same reply for unknown and blocking recipients, so blocks stay private
+			c.sendError(CodeUndeliverable, "Message could not be delivered")
+			continue
+		}
+		if err := storeInstance.CheckAttachments(c.Username, chatMsg.Attachments); err != nil {
+			c.sendStoreError(err)
+			continue
+		}
+		messageID, err := storeInstance.CreateReplyWithAttachments(
+			func() int64 {
+				id, _ := strconv.ParseInt(c.UserID, 10, 64)
+				return id
+			}(),
+			c.Username,
+			chatMsg.To,
+			chatMsg.Ciphertext,
+			chatMsg.ReplyTo,
+			chatMsg.Attachments,
+		)
+		if err != nil {
+			c.sendStoreError(err)
+			continue
+		}
+		c.sendEvent(Event{Type: EventAck, ID: messageID})
 
 		// Route message only to intended recipient
-		hub.mu.Lock()
-		var recipientClient *Client
-		for client := range hub.Clients {
-			if client.Username == chatMsg.To && client.Authenticated {
-				recipientClient = client
-				break
-			}
-		}
-		hub.mu.Unlock()
-		if recipientClient != nil {
-			recipientClient.Send <- []byte(chatMsg.Ciphertext)
+		payload, _ := json.Marshal(Event{
+			Type:       EventMessage,
+			ID:         messageID,
+			From:       c.Username,
+			To:         chatMsg.To,
+			Ciphertext: chatMsg.Ciphertext,
+			ReplyTo:    chatMsg.ReplyTo,
+
+			Attachments: chatMsg.Attachments,
+		})
+		if hub.SendToUser(chatMsg.To, payload) {
+			_ = storeInstance.MarkMessageDelivered(messageID)
 		} else {
-			c.Conn.WriteMessage(websocket.TextMessage, []byte("Recipient not connected"))
+			// The message is stored and acked; this only says it wasn't pushed
+			c.sendError(CodeRecipientOffline, "Recipient not connected")
+		}
+	}
+}
+
+// canMessage reports whether sender may message recipient: the recipient
+// must exist and must not have blocked the sender.
+func canMessage(storeInstance *store.Store, sender, recipient string) bool {
+	user, err := storeInstance.GetUserByUsername(recipient)
+	if err != nil || user == nil || user.Username != recipient {
+		return false
+	}
+	return !hasBlocked(storeInstance, recipient, sender)
+}
+
+// hasBlocked reports whether username has blocked other. When that can't
+// be checked it errs on the side of the block.
+func hasBlocked(storeInstance *store.Store, username, other string) bool {
+	blocked, err := storeInstance.IsBlocked(username, other)
+	return err != nil || blocked
+}
+
+// handleMessageChange applies an edit or delete frame and echoes the resulting event to the sender.
+func (c *Client) handleMessageChange(storeInstance *store.Store, hub *Hub, eventType string, id int64, ciphertext string) {
+	if id == 0 {
+		c.sendError(CodeBadRequest, "Message id required")
+		return
+	}
+	var m *models.Message
+	var err error
+	if eventType == EventEdit {
+		if ciphertext == "" {
+			c.sendError(CodeBadRequest, "Ciphertext required")
+			return
 		}
+		m, err = editMessage(storeInstance, hub, c.Username, id, ciphertext)
+	} else {
+		m, err = deleteMessage(storeInstance, hub, c.Username, id)
+	}
+	if err != nil {
+		c.sendStoreError(err)
+		return
+	}
+	c.sendEvent(messageEvent(eventType, m))
+}
+
+// handleReaction applies a react or unreact frame and echoes the resulting event to the reactor.
+func (c *Client) handleReaction(storeInstance *store.Store, hub *Hub, eventType string, id int64, ciphertext string) {
+	if id == 0 {
+		c.sendError(CodeBadRequest, "Message id required")
+		return
 	}
+	remove := eventType == EventUnreact
+	if !remove && ciphertext == "" {
+		c.sendError(CodeBadRequest, "Ciphertext required")
+		return
+	}
+	event, err := reactToMessage(storeInstance, hub, c.Username, id, ciphertext, remove)
+	if err != nil {
+		c.sendStoreError(err)
+		return
+	}
+	c.sendEvent(event)
+}
+
+// sendText queues a plain-text status frame for this client. Once a client is
+// authenticated all writes go through Send so writePump stays the only writer.
+// Status frames only matter live, so they are not spilled.
+func (c *Client) sendText(text string) {
+	c.offer([]byte(text))
+}
+
+// sendError queues an error event for this client.
+func (c *Client) sendError(code, message string) {
+	c.sendEvent(Event{Type: EventError, Code: code, Message: message})
 }
 
-// writePump writes messages from the hub to the WebSocket connection.
-func (c *Client) writePump() {
+// sendStoreError queues the error event for a failed store call.
+func (c *Client) sendStoreError(err error) {
+	c.sendError(storeErrorCode(err))
+}
+
+// reject queues an error event for a connection readPump is about to end,
+// e.g. after failed authentication. writePump stays the only writer: it
+// sends the event and then closes the connection.
+func (c *Client) reject(code, message string) {
+	c.sendError(code, message)
+	c.rejected = true
+}
+
+// sendEvent queues a JSON event for this client. These are replies to the
+// client's own frames, such as acks and errors, which only matter live, so
+// they are not spilled; durable events go through Hub.deliver.
+func (c *Client) sendEvent(event Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	c.offer(payload)
+}
+
+// writePump writes messages from the hub to the WebSocket connection and
+// pings the peer every PingInterval. When the server shuts down it flushes
+// the queue and then says goodbye.
+func (c *Client) writePump(hub *Hub) {
+	defer hub.pumps.Add(-1)
 	defer c.Conn.Close()
-	for msg := range c.Send {
-		if err := c.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-			break
+	var ping <-chan time.Time
+	if hub.PingInterval > 0 {
+		ticker := time.NewTicker(hub.PingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+	for {
+		select {
+		case msg, ok := <-c.Send:
+			if !ok {
+				// Send is closed by now, after goingAway was set
+				if c.goingAway {
+					c.sayGoingAway()
+				}
+				return
+			}
+			c.Conn.SetWriteDeadline(c.writeDeadline())
+			if err := c.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				hub.markTimedOut(c, err)
+				return
+			}
+		case <-ping:
+			if err := c.Conn.WriteControl(websocket.PingMessage, nil, c.writeDeadline()); err != nil {
+				hub.markTimedOut(c, err)
+				return
+			}
 		}
 	}
 }
 
 // getStoreInstance returns the global store instance from main package via a package-level variable.
-// This is a workaround for accessing the store from the handler.
-var storeInstanceGlobal *store.Store
+// This is a workaround for accessing the store from the handler. It is read
+// from hub and connection goroutines, hence atomic.
+var storeInstanceGlobal atomic.Pointer[store.Store]
 
 func SetStoreInstance(s *store.Store) {
-	storeInstanceGlobal = s
+	storeInstanceGlobal.Store(s)
 }
 
 func getStoreInstance() (*store.Store, error) {
-	if storeInstanceGlobal == nil {
+	s := storeInstanceGlobal.Load()
+	if s == nil {
 		return nil, errors.New("store instance not set")
 	}
-	return storeInstanceGlobal, nil
+	return s, nil
 }
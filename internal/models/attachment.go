@@ -0,0 +1,17 @@
+package models
+
+// Attachment is a client-encrypted file uploaded for use in messages.
+// The decryption key travels inside the message ciphertext, never to the server.
+type Attachment struct {
+	ID        string `json:"id"`
+	Owner     string `json:"owner"`
+	Size      int64  `json:"size"`     // Declared total size in bytes
+	Received  int64  `json:"received"` // Bytes uploaded so far
+	Digest    string `json:"digest,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Complete reports whether the upload has finished and the blob is stored.
+func (a *Attachment) Complete() bool {
+	return a.Digest != ""
+}
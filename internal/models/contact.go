@@ -0,0 +1,21 @@
+package models
+
+// Contact relationship states, from the owner's point of view.
+const (
+	ContactOutgoing = "outgoing" // owner sent a request
+	ContactIncoming = "incoming" // owner received a request
+	ContactAccepted = "accepted"
+)
+
+// Contact is an entry in a user's contact list.
+type Contact struct {
+	Username  string `json:"username"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Block is an entry in a user's block list.
+type Block struct {
+	Username  string `json:"username"`
+	CreatedAt string `json:"created_at"`
+}
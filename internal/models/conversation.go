@@ -0,0 +1,15 @@
+package models
+
+// ConversationDirect is the only conversation type until group chats exist.
+const ConversationDirect = "direct"
+
+// Conversation summarizes one of a user's conversations for the conversation list.
+type Conversation struct {
+	Type          string `json:"type"`
+	Peer          string `json:"peer"`
+	LastMessageID int64  `json:"last_message_id"`
+	LastMessageAt string `json:"last_message_at"`
+	UnreadCount   int    `json:"unread_count"`
+	PeerStatus    string `json:"peer_status,omitempty"`
+	PeerLastSeen  string `json:"peer_last_seen,omitempty"`
+}
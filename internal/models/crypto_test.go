@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -35,3 +36,57 @@ func TestHashAndVerifyPassword(t *testing.T) {
 		t.Error("VerifyPassword verified incorrect password")
 	}
 }
+
+func TestVerifyPasswordHonorsStoredParams(t *testing.T) {
+	t.Cleanup(func() { SetArgon2Params(DefaultArgon2Params) })
+
+	SetArgon2Params(Argon2Params{Time: 2, Memory: 8 * 1024, Threads: 1})
+	hashed, err := HashPassword("supersecret123")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	if !strings.Contains(hashed, "$m=8192,t=2,p=1$") {
+		t.Fatalf("expected configured params in hash, got %s", hashed)
+	}
+
+	// Still verifies after the configured params change
+	SetArgon2Params(DefaultArgon2Params)
+	if ok, err := VerifyPassword(hashed, "supersecret123"); err != nil || !ok {
+		t.Errorf("expected hash with stored params to verify, got %v, %v", ok, err)
+	}
+	if ok, _ := VerifyPassword(hashed, "wrongpassword"); ok {
+		t.Error("VerifyPassword verified incorrect password")
+	}
+
+	if _, err := VerifyPassword("$argon2i$v=19$m=65536,t=1,p=2$c2FsdA$aGFzaA", "x"); err == nil {
+		t.Error("expected error for non-argon2id hash")
+	}
+	if _, err := VerifyPassword("$argon2id$v=19$m=0,t=1,p=2$c2FsdA$aGFzaA", "x"); err == nil {
+		t.Error("expected error for zero memory parameter")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	t.Cleanup(func() { SetArgon2Params(DefaultArgon2Params) })
+
+	SetArgon2Params(Argon2Params{Time: 1, Memory: 8 * 1024, Threads: 1})
+	weak, _ := HashPassword("supersecret123")
+	if NeedsRehash(weak) {
+		t.Error("hash with current params should not need rehash")
+	}
+
+	SetArgon2Params(Argon2Params{Time: 2, Memory: 8 * 1024, Threads: 1})
+	if !NeedsRehash(weak) {
+		t.Error("expected rehash when time cost increased")
+	}
+	strong, _ := HashPassword("supersecret123")
+
+	// Lowering the configured params never downgrades existing hashes
+	SetArgon2Params(Argon2Params{Time: 1, Memory: 8 * 1024, Threads: 1})
+	if NeedsRehash(strong) {
+		t.Error("stronger hash should not need rehash")
+	}
+	if NeedsRehash("not a hash") {
+		t.Error("unparseable hash should not need rehash")
+	}
+}
@@ -6,6 +6,22 @@ type Message struct {
 	UserID    int64  `json:"user_id"`
 	Username  string `json:"username"` // Sender
 	Recipient string `json:"recipient"`
-	Content   string `json:"content"` // Ciphertext
+	Content   string `json:"content"` // Ciphertext, empty for deleted messages
 	CreatedAt string `json:"created_at"`
+	Version   int    `json:"version"` // Incremented on every edit or delete
+	EditedAt  string `json:"edited_at,omitempty"`
+	Deleted   bool   `json:"deleted,omitempty"`  // Tombstone: deleted for everyone
+	ReplyTo   int64  `json:"reply_to,omitempty"` // ID of the quoted message, if any
+
+	Reactions   []Reaction `json:"reactions,omitempty"`
+	Attachments []string   `json:"attachments,omitempty"` // Attachment IDs
+}
+
+// Reaction is a participant's reaction to a message. The emoji is encrypted
+// client-side, so the server only stores ciphertext.
+type Reaction struct {
+	MessageID  int64  `json:"message_id"`
+	Reactor    string `json:"reactor"`
+	Ciphertext string `json:"ciphertext"`
+	CreatedAt  string `json:"created_at"`
 }
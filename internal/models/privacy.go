@@ -0,0 +1,36 @@
+package models
+
+// Visibility levels for presence information. "contacts" means accepted contacts.
+const (
+	VisibilityEveryone = "everyone"
+	VisibilityContacts = "contacts"
+	VisibilityNobody   = "nobody"
+)
+
+// PrivacySettings control who can see a user's presence.
+type PrivacySettings struct {
+	LastSeen     string `json:"last_seen"`     // who can see last_seen
+	OnlineStatus string `json:"online_status"` // who can see online/away
+	Invisible    bool   `json:"invisible"`     // appear offline to everyone
+
+	// HideFromBlocked hides presence and the public key from blocked users entirely.
+	HideFromBlocked bool `json:"hide_from_blocked"`
+
+	// Discoverable lists the user in directory search results.
+	Discoverable bool `json:"discoverable"`
+}
+
+// DefaultPrivacySettings returns the settings for users who never changed them.
+func DefaultPrivacySettings() PrivacySettings {
+	return PrivacySettings{
+		LastSeen:        VisibilityEveryone,
+		OnlineStatus:    VisibilityEveryone,
+		HideFromBlocked: true,
+		Discoverable:    true,
+	}
+}
+
+// ValidVisibility reports whether v is a known visibility level.
+func ValidVisibility(v string) bool {
+	return v == VisibilityEveryone || v == VisibilityContacts || v == VisibilityNobody
+}
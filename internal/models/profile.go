@@ -0,0 +1,23 @@
+package models
+
+// Profile limits. Encrypted profiles carry ciphertext, so their fields are
+// bounded in bytes rather than characters.
+const (
+	MaxBioLength            = 500
+	MaxEncryptedProfileSize = 4096
+)
+
+// Profile is a user's public-facing profile. When Encrypted is set,
+// DisplayName and Bio hold ciphertext under a profile key the user shares
+// with contacts in end-to-end encrypted messages, and the profile is only
+// served to the user's accepted contacts. Avatar is the ID of an uploaded
+// (client-encrypted) attachment.
+type Profile struct {
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	Avatar      string `json:"avatar"`
+	Encrypted   bool   `json:"encrypted"`
+	Version     int    `json:"version"`
+	UpdatedAt   string `json:"updated_at,omitempty"`
+}
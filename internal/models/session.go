@@ -0,0 +1,12 @@
+package models
+
+// Session is a login session, i.e. one signed-in device.
+type Session struct {
+	ID         string `json:"id"`
+	DeviceName string `json:"device_name,omitempty"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at"`
+	Current    bool   `json:"current"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// TwoFactor is a user's TOTP enrollment. It is pending until the user
+// confirms it with a valid code.
+type TwoFactor struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64 // last accepted time step, so codes can't be replayed
+}
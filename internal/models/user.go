@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"strings"
 
 	"golang.org/x/crypto/argon2"
@@ -17,6 +18,17 @@ type User struct {
 	PublicKey string `json:"public_key"` // ECC public key (base64 or hex encoded)
 	Status    string `json:"status"`
 	LastSeen  string `json:"last_seen"`
+
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+// MaxDisplayNameLength is the longest display name accepted, in characters.
+const MaxDisplayNameLength = 64
+
+// UserSummary is a user as listed in directory search results.
+type UserSummary struct {
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
 }
 
 // Argon2id parameters
@@ -28,50 +40,109 @@ const (
 	ArgonSaltLen        = 16
 )
 
+// Argon2Params are the cost parameters of an Argon2id hash. Memory is in KiB.
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+}
+
+// DefaultArgon2Params are the parameters used unless SetArgon2Params is called.
+var DefaultArgon2Params = Argon2Params{Time: ArgonTime, Memory: ArgonMemory, Threads: ArgonThreads, KeyLen: ArgonKeyLen}
+
+// argon2Params are the parameters new hashes are made with.
+var argon2Params = DefaultArgon2Params
+
+// SetArgon2Params sets the parameters used for new password hashes. Zero
+// fields keep their defaults.
+func SetArgon2Params(p Argon2Params) {
+	if p.Time == 0 {
+		p.Time = ArgonTime
+	}
+	if p.Memory == 0 {
+		p.Memory = ArgonMemory
+	}
+	if p.Threads == 0 {
+		p.Threads = ArgonThreads
+	}
+	if p.KeyLen == 0 {
+		p.KeyLen = ArgonKeyLen
+	}
+	argon2Params = p
+}
+
 // HashPassword hashes a plain password using Argon2id.
 func HashPassword(password string) (string, error) {
+	p := argon2Params
 	salt := make([]byte, ArgonSaltLen)
 	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
-	hash := argon2.IDKey([]byte(password), salt, ArgonTime, ArgonMemory, ArgonThreads, ArgonKeyLen)
+	hash := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
 	b64Salt := base64.RawStdEncoding.EncodeToString(salt)
 	b64Hash := base64.RawStdEncoding.EncodeToString(hash)
 	// Format: $argon2id$v=19$m=65536,t=1,p=2$<salt>$<hash>
-	return "$argon2id$v=19$m=65536,t=1,p=2$" + b64Salt + "$" + b64Hash, nil
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads, b64Salt, b64Hash), nil
 }
 
-// VerifyPassword checks if the provided password matches the Argon2id hash.
+// VerifyPassword checks if the provided password matches the Argon2id hash,
+// using the parameters encoded in the hash.
 func VerifyPassword(hash, password string) (bool, error) {
-	parts := splitHash(hash)
-	if parts == nil {
-		return false, errors.New("invalid hash format")
-	}
-	if len(parts) != 2 {
-		return false, errors.New("invalid hash format")
-	}
-	salt, err := base64.RawStdEncoding.DecodeString(parts[0])
+	p, salt, expectedHash, err := decodeHash(hash)
 	if err != nil {
 		return false, err
 	}
-	expectedHash, err := base64.RawStdEncoding.DecodeString(parts[1])
+	computed := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return subtleCompare(computed, expectedHash), nil
+}
+
+// NeedsRehash reports whether hash was made with weaker parameters than the
+// configured ones, so it should be replaced next time the password is known.
+func NeedsRehash(hash string) bool {
+	p, _, _, err := decodeHash(hash)
 	if err != nil {
-		return false, err
+		return false
 	}
-	computed := argon2.IDKey([]byte(password), salt, ArgonTime, ArgonMemory, ArgonThreads, ArgonKeyLen)
-	return subtleCompare(computed, expectedHash), nil
+	want := argon2Params
+	return p.Time < want.Time || p.Memory < want.Memory || p.Threads < want.Threads || p.KeyLen < want.KeyLen
 }
 
-// splitHash splits the hash string into its components.
-func splitHash(hash string) []string {
+// decodeHash parses an encoded Argon2id hash into its parameters, salt and key.
+func decodeHash(hash string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	invalid := errors.New("invalid hash format")
 	// Format: $argon2id$v=19$m=65536,t=1,p=2$<salt>$<hash>
-	hash = strings.TrimSpace(hash)
-	parts := strings.Split(hash, "$")
-	if len(parts) != 6 {
-		return nil
+	parts := strings.Split(strings.TrimSpace(hash), "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return p, nil, nil, invalid
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, invalid
+	}
+	if version != argon2.Version {
+		return p, nil, nil, errors.New("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, invalid
+	}
+	if p.Memory == 0 || p.Time == 0 || p.Threads == 0 {
+		return p, nil, nil, invalid
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	if len(key) == 0 {
+		return p, nil, nil, invalid
 	}
-	// parts[4] = salt, parts[5] = hash
-	return []string{parts[4], parts[5]}
+	p.KeyLen = uint32(len(key))
+	return p, salt, key, nil
 }
 
 // subtleCompare compares two byte slices for equality without leaking timing info.
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Username length limits, in characters.
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 32
+)
+
+// Username policy violations returned by NormalizeUsername.
+var (
+	ErrUsernameLength      = errors.New("username must be 3 to 32 characters")
+	ErrUsernameCharset     = errors.New("username may only contain letters, digits, '.', '_' and '-', and must start and end with a letter or digit")
+	ErrUsernameMixedScript = errors.New("username may not mix letters from different scripts")
+	ErrUsernameReserved    = errors.New("username is reserved")
+)
+
+// reservedUsernames can't be registered, nor can names that look like them.
+var reservedUsernames = []string{
+	"admin", "administrator", "root", "system", "support", "help", "security",
+	"staff", "moderator", "mod", "official", "chatterbox", "api", "www",
+	"anonymous", "deleted", "everyone", "null", "undefined", "me", "self",
+	"login", "logout", "register", "settings", "profile",
+}
+
+// usernameScripts are the scripts a username's letters may come from; all
+// letters must share one.
+var usernameScripts = []*unicode.RangeTable{
+	unicode.Latin, unicode.Cyrillic, unicode.Greek, unicode.Arabic, unicode.Hebrew,
+	unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Thai,
+	unicode.Devanagari, unicode.Armenian, unicode.Georgian,
+}
+
+// CanonicalUsername returns the form usernames are stored and compared in:
+// NFKC-normalized and lowercased. It doesn't check the username policy, so
+// it is safe to apply to names of accounts created before the policy existed.
+func CanonicalUsername(username string) string {
+	return norm.NFKC.String(strings.ToLower(norm.NFKC.String(strings.TrimSpace(username))))
+}
+
+// NormalizeUsername canonicalizes a username for registration and checks it
+// against the username policy.
+func NormalizeUsername(username string) (string, error) {
+	name := CanonicalUsername(username)
+	if n := utf8.RuneCountInString(name); n < MinUsernameLength || n > MaxUsernameLength {
+		return "", ErrUsernameLength
+	}
+	var script *unicode.RangeTable
+	for i, r := range name {
+		switch {
+		case r >= '0' && r <= '9':
+		case unicode.IsLetter(r):
+			s := scriptOf(r)
+			if s == nil {
+				return "", ErrUsernameCharset
+			}
+			if script != nil && s != script {
+				return "", ErrUsernameMixedScript
+			}
+			script = s
+		case r == '.' || r == '_' || r == '-':
+			if i == 0 || i == len(name)-1 {
+				return "", ErrUsernameCharset
+			}
+		default:
+			return "", ErrUsernameCharset
+		}
+	}
+	if IsReservedUsername(name) {
+		return "", ErrUsernameReserved
+	}
+	return name, nil
+}
+
+// IsReservedUsername reports whether username is, or looks like, a reserved
+// name. Digits standing in for letters count too, so "adm1n" is reserved.
+func IsReservedUsername(username string) bool {
+	skeleton := reservedLookalikes.Replace(UsernameSkeleton(username))
+	for _, reserved := range reservedUsernames {
+		if skeleton == reservedLookalikes.Replace(UsernameSkeleton(reserved)) {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedLookalikes folds digits into the letters they stand in for. It
+// only applies to reserved names: between ordinary accounts, "bob1" and
+// "bobl" are different people.
+var reservedLookalikes = strings.NewReplacer("0", "o", "1", "l", "i", "l", "3", "e", "5", "s")
+
+// scriptOf returns the allowed script r belongs to, or nil.
+func scriptOf(r rune) *unicode.RangeTable {
+	for _, s := range usernameScripts {
+		if unicode.Is(s, r) {
+			return s
+		}
+	}
+	return nil
+}
+
+// confusables maps Cyrillic and Greek letters to the Latin letter they are
+// drawn the same as in lowercase. Letters that merely resemble one, or
+// Latin letters and digits that resemble each other, are left alone so
+// that distinct names stay available.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x', 'ѕ': 's',
+	'і': 'i', 'ј': 'j', 'ԁ': 'd', 'һ': 'h', 'ӏ': 'l', 'ԛ': 'q', 'ԝ': 'w',
+	// Greek
+	'α': 'a', 'γ': 'y', 'ο': 'o', 'ν': 'v', 'ι': 'i', 'χ': 'x',
+}
+
+// UsernameSkeleton maps a username to a form in which names that look alike
+// are equal, e.g. "alice" and "аlice" with a Cyrillic "а". Separators are
+// ignored, so "al.ice" matches too. Two accounts may not share a skeleton.
+func UsernameSkeleton(username string) string {
+	var b strings.Builder
+	for _, r := range CanonicalUsername(username) {
+		if r == '.' || r == '_' || r == '-' {
+			continue
+		}
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// UsernameCollision records an existing account whose name clashes with an
+// earlier one under the normalization rules, found while migrating.
+type UsernameCollision struct {
+	Username      string    `json:"username"`
+	ConflictsWith string    `json:"conflicts_with"`
+	Reason        string    `json:"reason"` // "case" or "confusable"
+	DetectedAt    time.Time `json:"detected_at"`
+}
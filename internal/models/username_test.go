@@ -0,0 +1,65 @@
+package models
+
+import "testing"
+
+func TestNormalizeUsername(t *testing.T) {
+	valid := map[string]string{
+		"Alice":       "alice",
+		"  bob_smith": "bob_smith",
+		"ＡＬＩＣＥ2":      "alice2", // fullwidth forms fold under NFKC
+		"jürgen.m":    "jürgen.m",
+		"дмитрий":     "дмитрий",
+	}
+	for in, want := range valid {
+		got, err := NormalizeUsername(in)
+		if err != nil || got != want {
+			t.Errorf("NormalizeUsername(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+
+	invalid := map[string]error{
+		"al":                                ErrUsernameLength,
+		"abcdefghijklmnopqrstuvwxyz0123456": ErrUsernameLength,
+		"bob smith":                         ErrUsernameCharset,
+		"_bob":                              ErrUsernameCharset,
+		"bob-":                              ErrUsernameCharset,
+		"bob@example":                       ErrUsernameCharset,
+		"pаypal":                            ErrUsernameMixedScript, // Cyrillic "а"
+		"Admin":                             ErrUsernameReserved,
+		"adm1n":                             ErrUsernameReserved,
+		"r00t":                              ErrUsernameReserved,
+	}
+	for in, want := range invalid {
+		if _, err := NormalizeUsername(in); err != want {
+			t.Errorf("NormalizeUsername(%q) error = %v; want %v", in, err, want)
+		}
+	}
+}
+
+func TestUsernameSkeleton(t *testing.T) {
+	alike := [][2]string{
+		{"alice", "ALICE"},
+		{"alice", "al.ice"},
+		{"alice", "аlice"}, // Cyrillic "а"
+		{"paypal", "pаypаl"},
+		{"olivia", "olιvια"}, // Greek "ι" and "α"
+	}
+	for _, pair := range alike {
+		if UsernameSkeleton(pair[0]) != UsernameSkeleton(pair[1]) {
+			t.Errorf("expected %q and %q to look alike", pair[0], pair[1])
+		}
+	}
+	// Only letters from other scripts are folded, not Latin look-alikes
+	distinct := [][2]string{
+		{"alice", "alicia"},
+		{"clara", "dara"},
+		{"modern", "rnodern"},
+		{"alice", "a1ice"},
+		{"emil", "emll"},
+	}
+	for _, pair := range distinct {
+		if UsernameSkeleton(pair[0]) == UsernameSkeleton(pair[1]) {
+			t.Errorf("expected %q and %q to differ", pair[0], pair[1])
+		}
+	}
+}
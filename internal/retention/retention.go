@@ -0,0 +1,127 @@
+package retention
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/blobstore"
+	"github.com/edpsouza/chatterbox/internal/config"
+	"github.com/edpsouza/chatterbox/internal/store"
+)
+
+// Job periodically purges messages according to a retention policy and
+// garbage-collects attachment blobs no message references any more.
+type Job struct {
+	Store       *store.Store
+	Policy      store.RetentionPolicy
+	Interval    time.Duration
+	DryRun      bool
+	VacuumPages int
+
+	Blobs           blobstore.BlobStore // nil disables attachment GC
+	AttachmentGrace time.Duration
+}
+
+// NewJob builds a retention job from the server configuration.
+func NewJob(s *store.Store, blobs blobstore.BlobStore, cfg config.Config) *Job {
+	return &Job{
+		Store: s,
+		Policy: store.RetentionPolicy{
+			MaxAge:             days(cfg.RetentionMaxAgeDays),
+			MaxPerConversation: cfg.RetentionMaxPerConversation,
+			DeliveredMaxAge:    days(cfg.RetentionDeliveredDays),
+		},
+		Interval:    cfg.RetentionInterval,
+		DryRun:      cfg.RetentionDryRun,
+		VacuumPages: cfg.RetentionVacuumPages,
+
+		Blobs:           blobs,
+		AttachmentGrace: cfg.AttachmentGCGrace,
+	}
+}
+
+// Run executes the job once per interval until ctx is cancelled.
+func (j *Job) Run(ctx context.Context) {
+	if j.Interval <= 0 || (!j.Policy.Enabled() && j.Blobs == nil) {
+		return
+	}
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := j.RunOnce(); err != nil {
+			log.Printf("retention: purge failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce purges messages, collects unreferenced attachments, logs the
+// counts and reclaims freed pages.
+func (j *Job) RunOnce() (store.PurgeReport, error) {
+	if !j.Policy.Enabled() {
+		return store.PurgeReport{}, j.collectAttachments()
+	}
+	report, err := j.Store.PurgeMessages(j.Policy, j.DryRun)
+	if err != nil {
+		return report, err
+	}
+	if report.DryRun {
+		log.Printf("retention: dry run would purge %d messages (expired=%d delivered=%d over_limit=%d)",
+			report.Total(), report.Expired, report.Delivered, report.OverLimit)
+		return report, nil
+	}
+	log.Printf("retention: purged %d messages (expired=%d delivered=%d over_limit=%d)",
+		report.Total(), report.Expired, report.Delivered, report.OverLimit)
+	if err := j.collectAttachments(); err != nil {
+		return report, err
+	}
+	if report.Total() > 0 {
+		if err := j.Store.IncrementalVacuum(j.VacuumPages); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// collectAttachments deletes stale uploads and any blob left without an owner.
+func (j *Job) collectAttachments() error {
+	if j.Blobs == nil || j.DryRun {
+		return nil
+	}
+	stale, err := j.Store.StaleAttachments(j.AttachmentGrace)
+	if err != nil {
+		return err
+	}
+	var blobsDeleted int
+	for _, a := range stale {
+		inUse, err := j.Store.DeleteAttachment(a.ID)
+		if err != nil {
+			return err
+		}
+		if !a.Complete() {
+			if err := j.Blobs.Abort(a.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		if !inUse {
+			if err := j.Blobs.Delete(a.Digest); err != nil {
+				return err
+			}
+			blobsDeleted++
+		}
+	}
+	if len(stale) > 0 {
+		log.Printf("retention: collected %d attachments (%d blobs deleted)", len(stale), blobsDeleted)
+	}
+	return nil
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
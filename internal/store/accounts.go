@@ -0,0 +1,124 @@
+package store
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+// DeletedUsername is the name a deleted account goes by in the histories
+// of the people it talked to. It can't be registered.
+func DeletedUsername(id int64) string {
+	return "deleted:" + strconv.FormatInt(id, 10)
+}
+
+// DeleteAccount permanently removes username's account: the user row and
+// key, their own conversation list, queued events, contacts, blocks,
+// settings, credentials and attachments no message refers to. Messages and
+// reactions stay in the histories of the people the user talked to, under
+// DeletedUsername instead of the user's name, along with the attachments
+// they refer to. The username is retired so nobody can register it, or a
+// name that looks like it, and pose as the deleted user. Sessions are kept,
+// revoked and detached from the name, so tokens issued to them stay
+// rejected until they expire.
+//
+// It returns the removed attachments whose blobs are no longer referenced,
+// for the caller to delete from blob storage.
+func (s *Store) DeleteAccount(username string) ([]models.Attachment, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`SELECT id FROM users WHERE username = ?`, username).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO retired_usernames (skeleton, username) VALUES (?, ?)`, models.UsernameSkeleton(username), username); err != nil {
+		return nil, err
+	}
+
+	// Attachments of messages that stay are kept; the rest go
+	rows, err := tx.Query(`SELECT id, owner, size, received, digest, created_at FROM attachments
+		WHERE owner = ? AND id NOT IN (SELECT attachment_id FROM message_attachments)`, username)
+	if err != nil {
+		return nil, err
+	}
+	var attachments []models.Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	deleted := DeletedUsername(id)
+	stmts := []struct {
+		query string
+		args  []any
+	}{
+		{`DELETE FROM attachments WHERE owner = ? AND id NOT IN (SELECT attachment_id FROM message_attachments)`, []any{username}},
+		{`UPDATE attachments SET owner = ? WHERE owner = ?`, []any{deleted, username}},
+		{`UPDATE messages SET username = ? WHERE username = ?`, []any{deleted, username}},
+		{`UPDATE messages SET recipient = ? WHERE recipient = ?`, []any{deleted, username}},
+		{`UPDATE reactions SET reactor = ? WHERE reactor = ?`, []any{deleted, username}},
+		{`DELETE FROM conversations WHERE owner = ?`, []any{username}},
+		{`UPDATE conversations SET peer = ? WHERE peer = ?`, []any{deleted, username}},
+		{`DELETE FROM pending_events WHERE recipient = ?`, []any{username}},
+		{`DELETE FROM contacts WHERE owner = ? OR contact = ?`, []any{username, username}},
+		{`DELETE FROM blocks WHERE blocker = ? OR blocked = ?`, []any{username, username}},
+		{`DELETE FROM privacy_settings WHERE username = ?`, []any{username}},
+		{`DELETE FROM two_factor WHERE username = ?`, []any{username}},
+		{`DELETE FROM recovery_codes WHERE username = ?`, []any{username}},
+		{`DELETE FROM password_resets WHERE username = ?`, []any{username}},
+		{`DELETE FROM refresh_tokens WHERE username = ?`, []any{username}},
+		{`UPDATE sessions SET username = '', revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP) WHERE username = ?`, []any{username}},
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			return nil, err
+		}
+	}
+
+	// Blobs are shared between identical uploads; only report unused ones
+	var orphaned []models.Attachment
+	for _, a := range attachments {
+		if a.Complete() {
+			var inUse bool
+			if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM attachments WHERE digest = ?)`, a.Digest).Scan(&inUse); err != nil {
+				return nil, err
+			}
+			if inUse {
+				continue
+			}
+		}
+		orphaned = append(orphaned, a)
+	}
+	return orphaned, tx.Commit()
+}
+
+// GetUserMessages returns every message sent to or by username, oldest first.
+func (s *Store) GetUserMessages(username string) ([]models.Message, error) {
+	stmt := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE username = ? OR recipient = ?
+		ORDER BY created_at ASC, id ASC
+	`
+	return s.queryMessages(stmt, username, username)
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+func TestStore_DeleteAccount(t *testing.T) {
+	dbPath := "test_accounts.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	for _, name := range []string{"alice", "bob", "carol"} {
+		store.CreateUser(&models.User{Username: name, Password: "x", PublicKey: "k"})
+	}
+	toBob, _ := store.CreateMessage(1, "alice", "bob", "to bob")
+	toAlice, _ := store.CreateMessage(2, "bob", "alice", "to alice")
+	store.SetReaction(toAlice, "alice", "reaction")
+	store.CreateMessage(2, "bob", "carol", "to carol")
+	store.RequestContact("alice", "bob")
+	store.AcceptContact("bob", "alice")
+	store.Block("carol", "alice")
+	store.QueueEvent("alice", []byte(`{}`))
+	sessionID, _ := store.CreateSession("alice", "laptop", "", "", time.Hour)
+
+	// Two identical uploads share a blob; alice's unfinished upload has none
+	shared, _ := store.CreateAttachment("alice", 3, 0)
+	store.CompleteAttachment(shared.ID, "digest-shared")
+	other, _ := store.CreateAttachment("bob", 3, 0)
+	store.CompleteAttachment(other.ID, "digest-shared")
+	own, _ := store.CreateAttachment("alice", 3, 0)
+	store.CompleteAttachment(own.ID, "digest-own")
+	partial, _ := store.CreateAttachment("alice", 3, 0)
+	// An attachment bob received stays with the message
+	sent, _ := store.CreateAttachment("alice", 3, 0)
+	store.CompleteAttachment(sent.ID, "digest-sent")
+	store.LinkAttachments(toBob, []string{sent.ID})
+	alice, _ := store.GetUserByUsername("alice")
+
+	orphaned, err := store.DeleteAccount("alice")
+	if err != nil {
+		t.Fatalf("DeleteAccount failed: %v", err)
+	}
+	ids := map[string]bool{}
+	for _, a := range orphaned {
+		ids[a.ID] = true
+	}
+	if len(orphaned) != 2 || !ids[own.ID] || !ids[partial.ID] {
+		t.Errorf("expected own and partial uploads to be orphaned, got %+v", orphaned)
+	}
+
+	if user, _ := store.GetUserByUsername("alice"); user != nil {
+		t.Error("expected user to be deleted")
+	}
+	// bob keeps the conversation, with alice anonymized
+	deleted := DeletedUsername(alice.ID)
+	if messages, _ := store.GetMessagesBetween("alice", "bob"); len(messages) != 0 {
+		t.Errorf("expected alice's name to be gone from bob's history, got %d messages", len(messages))
+	}
+	messages, _ := store.GetMessagesBetween("bob", deleted)
+	if len(messages) != 2 || messages[0].Username != deleted || messages[1].Recipient != deleted {
+		t.Fatalf("expected bob's history with %s to remain, got %+v", deleted, messages)
+	}
+	if len(messages[1].Reactions) != 1 || messages[1].Reactions[0].Reactor != deleted {
+		t.Errorf("expected alice's reaction to be anonymized, got %+v", messages[1].Reactions)
+	}
+	if a, _ := store.GetAttachment(sent.ID); a == nil || a.Owner != deleted {
+		t.Errorf("expected the sent attachment to stay with the message, got %+v", a)
+	}
+	peers := map[string]bool{}
+	conversations, _ := store.ListConversations("bob")
+	for _, c := range conversations {
+		peers[c.Peer] = true
+	}
+	if len(peers) != 2 || !peers[deleted] || !peers["carol"] {
+		t.Errorf("expected bob's conversation to follow the rename, got %+v", conversations)
+	}
+	if conversations, _ := store.ListConversations("alice"); len(conversations) != 0 {
+		t.Errorf("expected alice's conversation list to be deleted, got %+v", conversations)
+	}
+	if messages, _ := store.GetMessagesBetween("bob", "carol"); len(messages) != 1 {
+		t.Errorf("expected other conversations to remain, got %d", len(messages))
+	}
+	if contacts, _ := store.ListContacts("bob"); len(contacts) != 0 {
+		t.Errorf("expected contacts to be removed, got %+v", contacts)
+	}
+	if blocked, _ := store.IsBlocked("carol", "alice"); blocked {
+		t.Error("expected blocks to be removed")
+	}
+	if events, _ := store.TakePendingEvents("alice"); len(events) != 0 {
+		t.Errorf("expected queued events to be removed, got %d", len(events))
+	}
+	if a, _ := store.GetAttachment(other.ID); a == nil {
+		t.Error("expected other users' attachments to remain")
+	}
+	if revoked, _ := store.IsTokenRevoked("jti", sessionID); !revoked {
+		t.Error("expected the account's sessions to stay revoked")
+	}
+	if sessions, _ := store.ListSessions(""); len(sessions) != 0 {
+		t.Errorf("expected revoked sessions not to be listed, got %+v", sessions)
+	}
+
+	if _, err := store.DeleteAccount("alice"); err == nil {
+		t.Error("expected error deleting a deleted account")
+	}
+	// Nobody can take over the name, or one that looks like it
+	for _, name := range []string{"alice", "аlice"} {
+		if err := store.CreateUser(&models.User{Username: name, Password: "x", PublicKey: "k"}); err != ErrUsernameTaken {
+			t.Errorf("expected %s to stay reserved, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package store
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+// Errors returned by attachment operations.
+var (
+	ErrAttachmentNotFound   = kindError(ErrNotFound, "attachment not found")
+	ErrAttachmentIncomplete = kindError(ErrInvalid, "attachment upload is not complete")
+	ErrQuotaExceeded        = kindError(ErrConflict, "attachment quota exceeded")
+)
+
+// CreateAttachment registers a pending upload of size bytes for owner.
+// Pending and completed uploads both count towards the owner's quota;
+// a non-positive quota disables the check. The check and the insert are a
+// single statement, so concurrent uploads can't overrun the quota together.
+func (s *Store) CreateAttachment(owner string, size, quota int64) (*models.Attachment, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(raw)
+	stmt := `
+		INSERT INTO attachments (id, owner, size)
+		SELECT ?, ?, ?
+		WHERE ? <= 0 OR (SELECT COALESCE(SUM(size), 0) FROM attachments WHERE owner = ?) + ? <= ?
+	`
+	result, err := s.db.Exec(stmt, id, owner, size, quota, owner, size, quota)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrQuotaExceeded
+	}
+	return s.GetAttachment(id)
+}
+
+// GetAttachment fetches an attachment by ID. It returns nil if it does not exist.
+func (s *Store) GetAttachment(id string) (*models.Attachment, error) {
+	row := s.db.QueryRow(`SELECT id, owner, size, received, digest, created_at FROM attachments WHERE id = ?`, id)
+	a, err := scanAttachment(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func scanAttachment(row rowScanner) (models.Attachment, error) {
+	var a models.Attachment
+	var digest sql.NullString
+	err := row.Scan(&a.ID, &a.Owner, &a.Size, &a.Received, &digest, &a.CreatedAt)
+	a.Digest = digest.String
+	return a, err
+}
+
+// SetAttachmentReceived records upload progress.
+func (s *Store) SetAttachmentReceived(id string, received int64) error {
+	_, err := s.db.Exec(`UPDATE attachments SET received = ? WHERE id = ?`, received, id)
+	return err
+}
+
+// CompleteAttachment marks an upload as finished with the digest of the stored blob.
+func (s *Store) CompleteAttachment(id, digest string) error {
+	_, err := s.db.Exec(`UPDATE attachments SET received = size, digest = ?, completed_at = CURRENT_TIMESTAMP WHERE id = ?`, digest, id)
+	return err
+}
+
+// CheckAttachments verifies that owner uploaded every attachment in ids and that all uploads are complete.
+func (s *Store) CheckAttachments(owner string, ids []string) error {
+	for _, id := range ids {
+		a, err := s.GetAttachment(id)
+		if err != nil {
+			return err
+		}
+		if a == nil || a.Owner != owner {
+			return ErrAttachmentNotFound
+		}
+		if !a.Complete() {
+			return ErrAttachmentIncomplete
+		}
+	}
+	return nil
+}
+
+// LinkAttachments records that a message references the given attachments.
+func (s *Store) LinkAttachments(messageID int64, ids []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := linkAttachments(tx, messageID, ids); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// linkAttachments is LinkAttachments within tx.
+func linkAttachments(tx *sql.Tx, messageID int64, ids []string) error {
+	for _, id := range ids {
+		_, err := tx.Exec(`INSERT OR IGNORE INTO message_attachments (message_id, attachment_id) VALUES (?, ?)`, messageID, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CanAccessAttachment reports whether username uploaded the attachment or
+// took part in a message that references it, or may see the profile of a
+// user who uses it as their avatar.
+func (s *Store) CanAccessAttachment(id, username string) (bool, error) {
+	stmt := `
+		SELECT EXISTS (SELECT 1 FROM attachments WHERE id = ? AND owner = ?)
+		    OR EXISTS (
+				SELECT 1 FROM message_attachments ma
+				JOIN messages m ON m.id = ma.message_id
+				WHERE ma.attachment_id = ? AND m.deleted_at IS NULL
+				  AND (m.username = ? OR m.recipient = ?)
+			)
+		    OR EXISTS (
+				SELECT 1 FROM users u
+				WHERE u.avatar_id = ?
+				  AND NOT EXISTS (SELECT 1 FROM blocks b WHERE b.blocker = u.username AND b.blocked = ?)
+				  AND (u.profile_encrypted = 0 OR EXISTS (
+					SELECT 1 FROM contacts c WHERE c.owner = u.username AND c.contact = ? AND c.status = 'accepted'))
+			)`
+	var ok bool
+	err := s.db.QueryRow(stmt, id, username, id, username, username, id, username, username).Scan(&ok)
+	return ok, err
+}
+
+// StaleAttachments returns uploads that can be garbage collected: completed
+// attachments no message or avatar references, and unfinished uploads, once
+// they are older than grace.
+func (s *Store) StaleAttachments(grace time.Duration) ([]models.Attachment, error) {
+	stmt := `
+		SELECT id, owner, size, received, digest, created_at FROM attachments a
+		WHERE a.created_at < datetime('now', ?)
+		  AND NOT EXISTS (
+			SELECT 1 FROM message_attachments ma
+			JOIN messages m ON m.id = ma.message_id
+			WHERE ma.attachment_id = a.id AND m.deleted_at IS NULL
+		  )
+		  AND NOT EXISTS (SELECT 1 FROM users u WHERE u.avatar_id = a.id)`
+	rows, err := s.db.Query(stmt, sqliteOffset(grace))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stale []models.Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		stale = append(stale, a)
+	}
+	return stale, rows.Err()
+}
+
+// DeleteAttachment removes an attachment row and its message links.
+// It reports whether the blob digest is still used by another attachment.
+func (s *Store) DeleteAttachment(id string) (digestInUse bool, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	row := tx.QueryRow(`SELECT id, owner, size, received, digest, created_at FROM attachments WHERE id = ?`, id)
+	a, err := scanAttachment(row)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`DELETE FROM message_attachments WHERE attachment_id = ?`, id); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`DELETE FROM attachments WHERE id = ?`, id); err != nil {
+		return false, err
+	}
+	if a.Complete() {
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM attachments WHERE digest = ?)`, a.Digest).Scan(&digestInUse)
+		if err != nil {
+			return false, err
+		}
+	}
+	return digestInUse, tx.Commit()
+}
+
+// attachAttachmentIDs loads the attachment IDs referenced by the given messages.
+func (s *Store) attachAttachmentIDs(messages []models.Message) error {
+	index := make(map[int64]int, len(messages))
+	for i, m := range messages {
+		index[m.ID] = i
+	}
+	return forEachBatch(messages, func(placeholders string, args []any) error {
+		stmt := `SELECT message_id, attachment_id FROM message_attachments WHERE message_id IN (` + placeholders + `) ORDER BY rowid ASC`
+		rows, err := s.db.Query(stmt, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var messageID int64
+			var attachmentID string
+			if err := rows.Scan(&messageID, &attachmentID); err != nil {
+				return err
+			}
+			i := index[messageID]
+			messages[i].Attachments = append(messages[i].Attachments, attachmentID)
+		}
+		return rows.Err()
+	})
+}
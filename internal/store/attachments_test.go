@@ -0,0 +1,62 @@
+package store
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestStore_AttachmentQuotaUnderConcurrency(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test_attachment_quota.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// Uploads started together still fit within the quota
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.CreateAttachment("alice", 100, 300); err != nil && err != ErrQuotaExceeded {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	var used int64
+	store.db.QueryRow(`SELECT COALESCE(SUM(size), 0) FROM attachments WHERE owner = 'alice'`).Scan(&used)
+	if used != 300 {
+		t.Errorf("expected the quota to be filled exactly, used %d", used)
+	}
+}
+
+func TestStore_DeleteAttachment(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test_delete_attachment.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	first, _ := store.CreateAttachment("alice", 3, 0)
+	store.CompleteAttachment(first.ID, "digest")
+	second, _ := store.CreateAttachment("bob", 3, 0)
+	store.CompleteAttachment(second.ID, "digest")
+	id, _ := store.CreateMessage(1, "alice", "bob", "with attachment")
+	store.LinkAttachments(id, []string{first.ID})
+
+	// The blob stays while another upload shares it
+	if inUse, err := store.DeleteAttachment(first.ID); err != nil || !inUse {
+		t.Errorf("expected the shared digest to stay in use, got %v %v", inUse, err)
+	}
+	if ok, _ := store.CanAccessAttachment(first.ID, "bob"); ok {
+		t.Error("expected the deleted attachment to be unlinked")
+	}
+	if inUse, err := store.DeleteAttachment(second.ID); err != nil || inUse {
+		t.Errorf("expected the digest to be free, got %v %v", inUse, err)
+	}
+	if inUse, err := store.DeleteAttachment(second.ID); err != nil || inUse {
+		t.Errorf("expected deleting twice to be a no-op, got %v %v", inUse, err)
+	}
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"database/sql"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+// ErrNoContactRequest is returned when accepting a request that was never made.
+var ErrNoContactRequest = kindError(ErrNotFound, "no pending contact request")
+
+// contactStatus returns owner's relationship state with other, or "" if none.
+func (s *Store) contactStatus(owner, other string) (string, error) {
+	var status string
+	err := s.db.QueryRow(`SELECT status FROM contacts WHERE owner = ? AND contact = ?`, owner, other).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return status, err
+}
+
+// RequestContact sends a contact request from owner to other. If other already
+// asked owner, the request is accepted instead. It returns owner's new state.
+func (s *Store) RequestContact(owner, other string) (string, error) {
+	status, err := s.contactStatus(owner, other)
+	if err != nil {
+		return "", err
+	}
+	switch status {
+	case models.ContactAccepted, models.ContactOutgoing:
+		return status, nil
+	case models.ContactIncoming:
+		return models.ContactAccepted, s.AcceptContact(owner, other)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	stmt := `INSERT INTO contacts (owner, contact, status) VALUES (?, ?, ?)`
+	if _, err := tx.Exec(stmt, owner, other, models.ContactOutgoing); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(stmt, other, owner, models.ContactIncoming); err != nil {
+		return "", err
+	}
+	return models.ContactOutgoing, tx.Commit()
+}
+
+// AcceptContact accepts a pending request other sent to owner.
+func (s *Store) AcceptContact(owner, other string) error {
+	status, err := s.contactStatus(owner, other)
+	if err != nil {
+		return err
+	}
+	if status == models.ContactAccepted {
+		return nil
+	}
+	if status != models.ContactIncoming {
+		return ErrNoContactRequest
+	}
+	stmt := `UPDATE contacts SET status = ? WHERE (owner = ? AND contact = ?) OR (owner = ? AND contact = ?)`
+	_, err = s.db.Exec(stmt, models.ContactAccepted, owner, other, other, owner)
+	return err
+}
+
+// RemoveContact deletes the relationship between owner and other in both
+// directions. It also declines or cancels pending requests.
+func (s *Store) RemoveContact(owner, other string) error {
+	stmt := `DELETE FROM contacts WHERE (owner = ? AND contact = ?) OR (owner = ? AND contact = ?)`
+	_, err := s.db.Exec(stmt, owner, other, other, owner)
+	return err
+}
+
+// ListContacts returns owner's contacts and pending requests.
+func (s *Store) ListContacts(owner string) ([]models.Contact, error) {
+	rows, err := s.db.Query(`SELECT contact, status, created_at FROM contacts WHERE owner = ? ORDER BY contact ASC`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	contacts := []models.Contact{}
+	for rows.Next() {
+		var c models.Contact
+		if err := rows.Scan(&c.Username, &c.Status, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, c)
+	}
+	return contacts, rows.Err()
+}
+
+// IsContact reports whether owner and other are accepted contacts.
+func (s *Store) IsContact(owner, other string) (bool, error) {
+	status, err := s.contactStatus(owner, other)
+	return status == models.ContactAccepted, err
+}
+
+// Block adds blocked to blocker's block list and ends any contact relationship.
+func (s *Store) Block(blocker, blocked string) error {
+	if err := s.RemoveContact(blocker, blocked); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO blocks (blocker, blocked) VALUES (?, ?)`, blocker, blocked)
+	return err
+}
+
+// Unblock removes blocked from blocker's block list.
+func (s *Store) Unblock(blocker, blocked string) error {
+	_, err := s.db.Exec(`DELETE FROM blocks WHERE blocker = ? AND blocked = ?`, blocker, blocked)
+	return err
+}
+
+// IsBlocked reports whether blocker has blocked other.
+func (s *Store) IsBlocked(blocker, other string) (bool, error) {
+	var ok bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM blocks WHERE blocker = ? AND blocked = ?)`, blocker, other).Scan(&ok)
+	return ok, err
+}
+
+// ListBlocks returns blocker's block list.
+func (s *Store) ListBlocks(blocker string) ([]models.Block, error) {
+	rows, err := s.db.Query(`SELECT blocked, created_at FROM blocks WHERE blocker = ? ORDER BY blocked ASC`, blocker)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	blocks := []models.Block{}
+	for rows.Next() {
+		var b models.Block
+		if err := rows.Scan(&b.Username, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, rows.Err()
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"os"
+	"testing"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+func TestStore_ContactsAndBlocks(t *testing.T) {
+	dbPath := "test_contacts.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	status, err := store.RequestContact("alice", "bob")
+	if err != nil || status != models.ContactOutgoing {
+		t.Fatalf("expected outgoing request, got %q, %v", status, err)
+	}
+	if ok, _ := store.IsContact("bob", "alice"); ok {
+		t.Error("pending request should not count as a contact")
+	}
+	if err := store.AcceptContact("carol", "alice"); err != ErrNoContactRequest {
+		t.Errorf("expected ErrNoContactRequest, got %v", err)
+	}
+
+	// Requesting back accepts the pending request
+	status, err = store.RequestContact("bob", "alice")
+	if err != nil || status != models.ContactAccepted {
+		t.Fatalf("expected accepted contact, got %q, %v", status, err)
+	}
+	if ok, _ := store.IsContact("alice", "bob"); !ok {
+		t.Error("expected alice and bob to be contacts")
+	}
+	contacts, _ := store.ListContacts("alice")
+	if len(contacts) != 1 || contacts[0].Username != "bob" || contacts[0].Status != models.ContactAccepted {
+		t.Errorf("unexpected contacts: %+v", contacts)
+	}
+
+	// Blocking removes the contact in both directions
+	if err := store.Block("bob", "alice"); err != nil {
+		t.Fatalf("failed to block: %v", err)
+	}
+	if ok, _ := store.IsContact("alice", "bob"); ok {
+		t.Error("block should remove the contact")
+	}
+	if blocked, _ := store.IsBlocked("bob", "alice"); !blocked {
+		t.Error("expected bob to block alice")
+	}
+	if blocked, _ := store.IsBlocked("alice", "bob"); blocked {
+		t.Error("blocks are one-directional")
+	}
+	if err := store.Unblock("bob", "alice"); err != nil {
+		t.Fatalf("failed to unblock: %v", err)
+	}
+	if blocks, _ := store.ListBlocks("bob"); len(blocks) != 0 {
+		t.Errorf("expected no blocks, got %+v", blocks)
+	}
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"database/sql"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+// recordConversationMessage updates both participants' conversation summaries
+// for a newly inserted message. Only the recipient's unread count grows.
+func recordConversationMessage(tx *sql.Tx, messageID int64, sender, recipient string) error {
+	stmt := `
+		INSERT INTO conversations (owner, peer, last_message_id, last_message_at, unread_count)
+		VALUES (?, ?, ?, (SELECT created_at FROM messages WHERE id = ?), ?)
+		ON CONFLICT(owner, peer) DO UPDATE SET
+			last_message_id = excluded.last_message_id,
+			last_message_at = excluded.last_message_at,
+			unread_count = unread_count + excluded.unread_count`
+	if _, err := tx.Exec(stmt, sender, recipient, messageID, messageID, 0); err != nil {
+		return err
+	}
+	if sender == recipient {
+		return nil
+	}
+	_, err := tx.Exec(stmt, recipient, sender, messageID, messageID, 1)
+	return err
+}
+
+// backfillConversations builds summaries from existing messages. Undelivered
+// messages are counted as unread.
+func (s *Store) backfillConversations() error {
+	stmt := `
+		INSERT OR REPLACE INTO conversations (owner, peer, last_message_id, last_message_at, unread_count)
+		SELECT owner, peer, MAX(id), MAX(created_at), SUM(unread) FROM (
+			SELECT username AS owner, recipient AS peer, id, created_at, 0 AS unread FROM messages
+			UNION ALL
+			SELECT recipient, username, id, created_at, CASE WHEN delivered_at IS NULL THEN 1 ELSE 0 END
+			FROM messages WHERE recipient <> username
+		)
+		GROUP BY owner, peer`
+	_, err := s.db.Exec(stmt)
+	return err
+}
+
+// refreshConversations repairs summaries after messages were removed: it drops
+// conversations with no messages left and repoints last_message_id at the
+// newest remaining message.
+func refreshConversations(tx *sql.Tx) error {
+	stmts := []string{
+		`DELETE FROM conversations WHERE NOT EXISTS (
+			SELECT 1 FROM messages m
+			WHERE (m.username = conversations.owner AND m.recipient = conversations.peer)
+			   OR (m.username = conversations.peer AND m.recipient = conversations.owner)
+		)`,
+		`UPDATE conversations SET last_message_id = (
+			SELECT MAX(m.id) FROM messages m
+			WHERE (m.username = conversations.owner AND m.recipient = conversations.peer)
+			   OR (m.username = conversations.peer AND m.recipient = conversations.owner)
+		) WHERE last_message_id NOT IN (SELECT id FROM messages)`,
+		`UPDATE conversations SET last_message_at = (
+			SELECT created_at FROM messages WHERE id = conversations.last_message_id
+		)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListConversations returns owner's conversations, most recent first, with
+// each peer's presence.
+func (s *Store) ListConversations(owner string) ([]models.Conversation, error) {
+	stmt := `
+		SELECT c.peer, c.last_message_id, c.last_message_at, c.unread_count,
+		       COALESCE(u.status, 'offline'), u.last_seen
+		FROM conversations c
+		LEFT JOIN users u ON u.username = c.peer
+		WHERE c.owner = ?
+		ORDER BY c.last_message_at DESC, c.last_message_id DESC`
+	rows, err := s.db.Query(stmt, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	conversations := []models.Conversation{}
+	for rows.Next() {
+		c := models.Conversation{Type: models.ConversationDirect}
+		var lastSeen sql.NullString
+		if err := rows.Scan(&c.Peer, &c.LastMessageID, &c.LastMessageAt, &c.UnreadCount, &c.PeerStatus, &lastSeen); err != nil {
+			return nil, err
+		}
+		c.PeerLastSeen = lastSeen.String
+		conversations = append(conversations, c)
+	}
+	return conversations, rows.Err()
+}
+
+// MarkConversationRead resets owner's unread count for the conversation with peer.
+func (s *Store) MarkConversationRead(owner, peer string) error {
+	_, err := s.db.Exec(`UPDATE conversations SET unread_count = 0 WHERE owner = ? AND peer = ?`, owner, peer)
+	return err
+}
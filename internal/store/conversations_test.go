@@ -0,0 +1,65 @@
+package store
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStore_Conversations(t *testing.T) {
+	dbPath := "test_conversations.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	store.CreateMessage(1, "alice", "bob", "one")
+	store.CreateMessage(2, "bob", "alice", "two")
+	store.CreateMessage(1, "alice", "bob", "three")
+	last, _ := store.CreateMessage(3, "carol", "bob", "four")
+
+	conversations, err := store.ListConversations("bob")
+	if err != nil {
+		t.Fatalf("failed to list conversations: %v", err)
+	}
+	if len(conversations) != 2 {
+		t.Fatalf("expected 2 conversations, got %d", len(conversations))
+	}
+	if c := conversations[0]; c.Peer != "carol" || c.LastMessageID != last || c.UnreadCount != 1 {
+		t.Errorf("unexpected newest conversation: %+v", c)
+	}
+	if c := conversations[1]; c.Peer != "alice" || c.UnreadCount != 2 || c.PeerStatus != "offline" {
+		t.Errorf("unexpected alice conversation: %+v", c)
+	}
+
+	if err := store.MarkConversationRead("bob", "alice"); err != nil {
+		t.Fatalf("failed to mark read: %v", err)
+	}
+	conversations, _ = store.ListConversations("bob")
+	if conversations[1].UnreadCount != 0 {
+		t.Errorf("expected unread count reset, got %d", conversations[1].UnreadCount)
+	}
+
+	// Purging carol's message removes the now-empty conversation
+	store.db.Exec(`UPDATE messages SET created_at = datetime('now', '-2 days') WHERE id = ?`, last)
+	if _, err := store.PurgeMessages(RetentionPolicy{MaxAge: 24 * time.Hour}, false); err != nil {
+		t.Fatalf("purge failed: %v", err)
+	}
+	conversations, _ = store.ListConversations("bob")
+	if len(conversations) != 1 || conversations[0].Peer != "alice" {
+		t.Errorf("expected only alice after purge, got %+v", conversations)
+	}
+
+	// Rebuilding from scratch yields the same summaries
+	store.db.Exec(`DELETE FROM conversations`)
+	if err := store.backfillConversations(); err != nil {
+		t.Fatalf("backfill failed: %v", err)
+	}
+	conversations, _ = store.ListConversations("alice")
+	if len(conversations) != 1 || conversations[0].Peer != "bob" || conversations[0].UnreadCount != 1 {
+		t.Errorf("unexpected backfilled conversations: %+v", conversations)
+	}
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"strings"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+// likeEscaper escapes LIKE wildcards so search terms match literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchUsers returns up to limit users whose username or display name
+// starts with prefix (case-insensitively; encrypted display names never match), ordered by username and starting
+// after the username cursor. Users who opted out of discovery, the viewer
+// themselves and users hiding from a viewer they blocked are left out.
+func (s *Store) SearchUsers(prefix, viewer, after string, limit int) ([]models.UserSummary, error) {
+	pattern := likeEscaper.Replace(prefix) + "%"
+	stmt := `
+		SELECT u.username, CASE WHEN u.profile_encrypted = 0 THEN COALESCE(u.display_name, '') ELSE '' END
+		FROM users u
+		LEFT JOIN privacy_settings p ON p.username = u.username
+		WHERE (u.username LIKE ? ESCAPE '\' OR (u.profile_encrypted = 0 AND u.display_name LIKE ? ESCAPE '\'))
+			AND u.username > ?
+			AND u.username <> ?
+			AND COALESCE(p.discoverable, 1) = 1
+			AND NOT (COALESCE(p.hide_from_blocked, 1) = 1 AND EXISTS (
+				SELECT 1 FROM blocks b WHERE b.blocker = u.username AND b.blocked = ?))
+		ORDER BY u.username ASC
+		LIMIT ?`
+	rows, err := s.db.Query(stmt, pattern, pattern, after, viewer, viewer, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []models.UserSummary{}
+	for rows.Next() {
+		var u models.UserSummary
+		if err := rows.Scan(&u.Username, &u.DisplayName); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"os"
+	"testing"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+func TestStore_SearchUsers(t *testing.T) {
+	dbPath := "test_directory.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	for _, u := range []models.User{
+		{Username: "alice"},
+		{Username: "alfred", DisplayName: "Fred"},
+		{Username: "albert"},
+		{Username: "bob", DisplayName: "Alpha Bob"},
+		{Username: "al_x"},
+	} {
+		if err := store.CreateUser(&u); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	store.SetPrivacySettings("albert", models.PrivacySettings{
+		LastSeen: models.VisibilityEveryone, OnlineStatus: models.VisibilityEveryone, HideFromBlocked: true,
+	})
+
+	users, err := store.SearchUsers("AL", "alice", "", 10)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	var names []string
+	for _, u := range users {
+		names = append(names, u.Username)
+	}
+	// albert opted out and alice is the viewer
+	if len(names) != 3 || names[0] != "al_x" || names[1] != "alfred" || names[2] != "bob" {
+		t.Errorf("unexpected results: %v", names)
+	}
+
+	// Wildcards are matched literally, and pages continue after the cursor
+	if users, _ := store.SearchUsers("al_", "", "", 10); len(users) != 1 || users[0].Username != "al_x" {
+		t.Errorf("expected only al_x for literal underscore, got %+v", users)
+	}
+	if users, _ := store.SearchUsers("al", "bob", "alfred", 10); len(users) != 1 || users[0].Username != "alice" {
+		t.Errorf("expected alice after cursor, got %+v", users)
+	}
+
+	// Users who blocked the viewer are hidden from them
+	store.Block("bob", "alice")
+	if users, _ := store.SearchUsers("alpha", "alice", "", 10); len(users) != 0 {
+		t.Errorf("expected blocking user to be hidden, got %+v", users)
+	}
+}
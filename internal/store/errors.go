@@ -0,0 +1,33 @@
+package store
+
+import "errors"
+
+// Error kinds. Every error the store returns for a request it can't satisfy
+// wraps one of these, so callers can tell "not found" from "conflict"
+// without knowing each specific error.
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrConflict  = errors.New("conflict")
+	ErrForbidden = errors.New("forbidden")
+	ErrInvalid   = errors.New("invalid request")
+)
+
+// Errors shared across the store.
+var (
+	ErrUserNotFound  = kindError(ErrNotFound, "user not found")
+	ErrUsernameTaken = kindError(ErrConflict, "username already exists")
+)
+
+// storeError is a specific error of a given kind.
+type storeError struct {
+	kind    error
+	message string
+}
+
+func (e *storeError) Error() string { return e.message }
+func (e *storeError) Unwrap() error { return e.kind }
+
+// kindError returns an error with message that matches kind under errors.Is.
+func kindError(kind error, message string) error {
+	return &storeError{kind: kind, message: message}
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+func TestStoreErrorsMatchTheirKind(t *testing.T) {
+	dbPath := "test_errors.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	user := &models.User{Username: "alice", Password: "hash", PublicKey: "k"}
+	if err := store.CreateUser(user); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	err = store.CreateUser(&models.User{Username: "alice", Password: "hash", PublicKey: "k"})
+	if !errors.Is(err, ErrUsernameTaken) || !errors.Is(err, ErrConflict) {
+		t.Errorf("expected a username conflict, got %v", err)
+	}
+	if _, err := store.DeleteAccount("nobody"); !errors.Is(err, ErrUserNotFound) || !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected user not found, got %v", err)
+	}
+	if _, err := store.EditMessage(12345, "alice", "x", 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected message not found, got %v", err)
+	}
+	if errors.Is(ErrMessageNotFound, ErrConflict) {
+		t.Error("errors must only match their own kind")
+	}
+}
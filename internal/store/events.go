@@ -0,0 +1,44 @@
+package store
+
+// QueueEvent stores a WebSocket event for a recipient who is not connected.
+// Queued events are delivered the next time the recipient authenticates.
+func (s *Store) QueueEvent(recipient string, payload []byte) error {
+	stmt := `INSERT INTO pending_events (recipient, payload) VALUES (?, ?)`
+	_, err := s.db.Exec(stmt, recipient, string(payload))
+	return err
+}
+
+// TakePendingEvents removes and returns all queued events for recipient, oldest first.
+func (s *Store) TakePendingEvents(recipient string) ([][]byte, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, payload FROM pending_events WHERE recipient = ? ORDER BY id ASC`, recipient)
+	if err != nil {
+		return nil, err
+	}
+	var events [][]byte
+	var lastID int64
+	for rows.Next() {
+		var payload string
+		if err := rows.Scan(&lastID, &payload); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		events = append(events, []byte(payload))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+	if _, err := tx.Exec(`DELETE FROM pending_events WHERE recipient = ? AND id <= ?`, recipient, lastID); err != nil {
+		return nil, err
+	}
+	return events, tx.Commit()
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// LockoutPolicy controls how failed logins lock out an account or address.
+type LockoutPolicy struct {
+	MaxFailures int           // failures allowed before the first lockout; zero disables locking
+	Window      time.Duration // failures older than this are forgotten
+	Base        time.Duration // first lockout; each further failure doubles it
+	Max         time.Duration // longest lockout
+}
+
+// lockoutFor returns how long to lock after failures consecutive failures.
+func (p LockoutPolicy) lockoutFor(failures int) time.Duration {
+	if p.MaxFailures <= 0 || failures < p.MaxFailures {
+		return 0
+	}
+	d := p.Base
+	for i := p.MaxFailures; i < failures && d < p.Max; i++ {
+		d *= 2
+	}
+	return min(d, p.Max)
+}
+
+// LoginLockedUntil returns the latest lockout among keys that is still in
+// effect at now, or the zero time if none is.
+func (s *Store) LoginLockedUntil(now time.Time, keys ...string) (time.Time, error) {
+	var until time.Time
+	for _, key := range keys {
+		var locked sql.NullTime
+		err := s.db.QueryRow(`SELECT locked_until FROM login_failures WHERE key = ?`, key).Scan(&locked)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		if locked.Valid && locked.Time.After(now) && locked.Time.After(until) {
+			until = locked.Time
+		}
+	}
+	return until, nil
+}
+
+// RecordLoginFailure counts a failed login for key and locks it out once
+// policy says so. It returns when the lockout ends, or the zero time. The
+// count is incremented in a single statement, so parallel failures are
+// all counted.
+func (s *Store) RecordLoginFailure(key string, policy LockoutPolicy, now time.Time) (time.Time, error) {
+	// Failures from before the window are forgotten
+	var since time.Time
+	if policy.Window > 0 {
+		since = now.Add(-policy.Window)
+	}
+	var failures int
+	err := s.db.QueryRow(`
+		INSERT INTO login_failures (key, failures, last_failure) VALUES (?, 1, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = CASE WHEN login_failures.last_failure < ? THEN 1 ELSE login_failures.failures + 1 END,
+			last_failure = excluded.last_failure
+		RETURNING failures`, key, now.UTC(), since.UTC()).Scan(&failures)
+	if err != nil {
+		return time.Time{}, err
+	}
+	d := policy.lockoutFor(failures)
+	if d == 0 {
+		return time.Time{}, nil
+	}
+	// A parallel failure may have set a later lockout already
+	until := now.Add(d).UTC()
+	_, err = s.db.Exec(`UPDATE login_failures SET locked_until = ? WHERE key = ? AND (locked_until IS NULL OR locked_until < ?)`, until, key, until)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return until, nil
+}
+
+// ClearLoginFailures forgets failures and lifts lockouts for keys, after a
+// successful login or an admin unlock.
+func (s *Store) ClearLoginFailures(keys ...string) error {
+	for _, key := range keys {
+		if _, err := s.db.Exec(`DELETE FROM login_failures WHERE key = ?`, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
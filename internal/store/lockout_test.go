@@ -0,0 +1,97 @@
+package store
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStore_LoginLockout(t *testing.T) {
+	dbPath := "test_lockout.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	policy := LockoutPolicy{MaxFailures: 3, Window: time.Hour, Base: time.Minute, Max: 3 * time.Minute}
+	now := time.Now()
+	var until time.Time
+	for i := 0; i < 3; i++ {
+		until, _ = store.RecordLoginFailure("user:alice", policy, now)
+	}
+	if want := now.Add(time.Minute); !until.Equal(want.UTC()) {
+		t.Errorf("expected first lockout until %v, got %v", want, until)
+	}
+	if locked, _ := store.LoginLockedUntil(now, "user:bob", "user:alice"); locked.IsZero() {
+		t.Error("expected alice to be locked out")
+	}
+
+	// Each further failure doubles the lockout, up to the maximum
+	until, _ = store.RecordLoginFailure("user:alice", policy, now)
+	if d := until.Sub(now); d != 2*time.Minute {
+		t.Errorf("expected 2m lockout, got %v", d)
+	}
+	until, _ = store.RecordLoginFailure("user:alice", policy, now)
+	if d := until.Sub(now); d != 3*time.Minute {
+		t.Errorf("expected lockout capped at 3m, got %v", d)
+	}
+	if locked, _ := store.LoginLockedUntil(now.Add(4*time.Minute), "user:alice"); !locked.IsZero() {
+		t.Error("expected lockout to expire")
+	}
+
+	// Old failures are forgotten, and clearing lifts the lockout
+	until, _ = store.RecordLoginFailure("user:alice", policy, now.Add(2*time.Hour))
+	if !until.IsZero() {
+		t.Errorf("expected counter to restart after the window, got lockout until %v", until)
+	}
+	store.RecordLoginFailure("user:carol", policy, now)
+	store.RecordLoginFailure("user:carol", policy, now)
+	store.RecordLoginFailure("user:carol", policy, now)
+	store.ClearLoginFailures("user:carol")
+	if locked, _ := store.LoginLockedUntil(now, "user:carol"); !locked.IsZero() {
+		t.Error("expected cleared lockout")
+	}
+}
+
+func TestStore_ParallelLoginFailures(t *testing.T) {
+	dbPath := "test_lockout_parallel.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// Every failure counts, however many arrive at once
+	policy := LockoutPolicy{MaxFailures: 20, Window: time.Hour, Base: time.Minute, Max: time.Hour}
+	now := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.RecordLoginFailure("ip:10.0.0.1", policy, now); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("RecordLoginFailure failed: %v", err)
+	}
+	var failures int
+	store.db.QueryRow(`SELECT failures FROM login_failures WHERE key = 'ip:10.0.0.1'`).Scan(&failures)
+	if failures != 20 {
+		t.Errorf("expected 20 failures, got %d", failures)
+	}
+	if locked, _ := store.LoginLockedUntil(now, "ip:10.0.0.1"); !locked.Equal(now.Add(time.Minute).UTC()) {
+		t.Errorf("expected a one minute lockout, got %v", locked)
+	}
+}
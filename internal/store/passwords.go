@@ -0,0 +1,60 @@
+package store
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"time"
+)
+
+// ErrInvalidResetToken is returned for unknown, expired or already used password reset tokens.
+var ErrInvalidResetToken = kindError(ErrInvalid, "invalid password reset token")
+
+// UpdatePassword replaces username's password hash.
+func (s *Store) UpdatePassword(username, hash string) error {
+	result, err := s.db.Exec(`UPDATE users SET password = ? WHERE username = ?`, hash, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// CreatePasswordResetToken issues a single-use token that lets username set a
+// new password within ttl. Earlier unused tokens of the user stop working.
+func (s *Store) CreatePasswordResetToken(username string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM password_resets WHERE username = ? OR expires_at < CURRENT_TIMESTAMP`, username); err != nil {
+		return "", err
+	}
+	stmt := `INSERT INTO password_resets (token_hash, username, expires_at) VALUES (?, ?, datetime('now', ?))`
+	if _, err := tx.Exec(stmt, hashToken(token), username, sqliteFuture(ttl)); err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// ConsumePasswordResetToken marks a reset token used and returns whose
+// password it resets.
+func (s *Store) ConsumePasswordResetToken(token string) (string, error) {
+	var username string
+	err := s.db.QueryRow(`
+		UPDATE password_resets SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at >= CURRENT_TIMESTAMP
+		RETURNING username`, hashToken(token)).Scan(&username)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidResetToken
+	}
+	return username, err
+}
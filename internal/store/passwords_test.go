@@ -0,0 +1,55 @@
+package store
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+func TestStore_PasswordReset(t *testing.T) {
+	dbPath := "test_passwords.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.CreateUser(&models.User{Username: "alice", Password: "old", PublicKey: "k"}); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if err := store.UpdatePassword("alice", "new"); err != nil {
+		t.Fatalf("UpdatePassword failed: %v", err)
+	}
+	if user, _ := store.GetUserByUsername("alice"); user.Password != "new" {
+		t.Errorf("expected updated hash, got %q", user.Password)
+	}
+	if err := store.UpdatePassword("nobody", "new"); err == nil {
+		t.Error("expected error updating unknown user")
+	}
+
+	first, err := store.CreatePasswordResetToken("alice", time.Hour)
+	if err != nil {
+		t.Fatalf("CreatePasswordResetToken failed: %v", err)
+	}
+	second, _ := store.CreatePasswordResetToken("alice", time.Hour)
+	if _, err := store.ConsumePasswordResetToken(first); err != ErrInvalidResetToken {
+		t.Errorf("expected superseded token to be invalid, got %v", err)
+	}
+	username, err := store.ConsumePasswordResetToken(second)
+	if err != nil || username != "alice" {
+		t.Fatalf("expected token for alice, got %q, %v", username, err)
+	}
+	if _, err := store.ConsumePasswordResetToken(second); err != ErrInvalidResetToken {
+		t.Errorf("expected used token to be invalid, got %v", err)
+	}
+
+	expired, _ := store.CreatePasswordResetToken("alice", time.Hour)
+	store.db.Exec(`UPDATE password_resets SET expires_at = datetime('now', '-1 minute')`)
+	if _, err := store.ConsumePasswordResetToken(expired); err != ErrInvalidResetToken {
+		t.Errorf("expected expired token to be invalid, got %v", err)
+	}
+}
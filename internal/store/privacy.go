@@ -0,0 +1,32 @@
+package store
+
+import (
+	"database/sql"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+// GetPrivacySettings returns username's privacy settings, or the defaults if none were saved.
+func (s *Store) GetPrivacySettings(username string) (models.PrivacySettings, error) {
+	settings := models.DefaultPrivacySettings()
+	row := s.db.QueryRow(`SELECT last_seen, online_status, invisible, hide_from_blocked, discoverable FROM privacy_settings WHERE username = ?`, username)
+	err := row.Scan(&settings.LastSeen, &settings.OnlineStatus, &settings.Invisible, &settings.HideFromBlocked, &settings.Discoverable)
+	if err == sql.ErrNoRows {
+		return models.DefaultPrivacySettings(), nil
+	}
+	return settings, err
+}
+
+// SetPrivacySettings saves username's privacy settings.
+func (s *Store) SetPrivacySettings(username string, settings models.PrivacySettings) error {
+	stmt := `
+		INSERT INTO privacy_settings (username, last_seen, online_status, invisible, hide_from_blocked, discoverable) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(username) DO UPDATE SET
+			last_seen = excluded.last_seen,
+			online_status = excluded.online_status,
+			invisible = excluded.invisible,
+			hide_from_blocked = excluded.hide_from_blocked,
+			discoverable = excluded.discoverable`
+	_, err := s.db.Exec(stmt, username, settings.LastSeen, settings.OnlineStatus, settings.Invisible, settings.HideFromBlocked, settings.Discoverable)
+	return err
+}
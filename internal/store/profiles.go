@@ -0,0 +1,55 @@
+package store
+
+import (
+	"database/sql"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+// ErrInvalidAvatar is returned when an avatar is not a completed upload owned by the user.
+var ErrInvalidAvatar = kindError(ErrInvalid, "avatar must be a completed attachment you uploaded")
+
+// GetProfile returns username's profile, or nil if the user does not exist.
+func (s *Store) GetProfile(username string) (*models.Profile, error) {
+	stmt := `
+		SELECT username, COALESCE(display_name, ''), COALESCE(bio, ''), COALESCE(avatar_id, ''),
+			profile_encrypted, profile_version, COALESCE(profile_updated_at, '')
+		FROM users WHERE username IN (?, ?) ORDER BY username = ? DESC LIMIT 1`
+	var p models.Profile
+	err := s.db.QueryRow(stmt, username, models.CanonicalUsername(username), username).Scan(&p.Username, &p.DisplayName, &p.Bio, &p.Avatar,
+		&p.Encrypted, &p.Version, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// UpdateProfile saves username's profile fields and bumps its version.
+// A non-empty avatar must be a completed attachment owned by username.
+func (s *Store) UpdateProfile(username string, p models.Profile) (*models.Profile, error) {
+	if p.Avatar != "" {
+		a, err := s.GetAttachment(p.Avatar)
+		if err != nil {
+			return nil, err
+		}
+		if a == nil || a.Owner != username || !a.Complete() {
+			return nil, ErrInvalidAvatar
+		}
+	}
+	stmt := `
+		UPDATE users SET
+			display_name = NULLIF(?, ''),
+			bio = NULLIF(?, ''),
+			avatar_id = NULLIF(?, ''),
+			profile_encrypted = ?,
+			profile_version = profile_version + 1,
+			profile_updated_at = CURRENT_TIMESTAMP
+		WHERE username = ?`
+	if _, err := s.db.Exec(stmt, p.DisplayName, p.Bio, p.Avatar, p.Encrypted, username); err != nil {
+		return nil, err
+	}
+	return s.GetProfile(username)
+}
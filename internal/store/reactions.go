@@ -0,0 +1,102 @@
+package store
+
+import (
+	"strings"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+// ErrNotParticipant is returned when a user acts on a message from a conversation they are not part of.
+var ErrNotParticipant = kindError(ErrForbidden, "not a participant in this conversation")
+
+// reactableMessage checks that user may react to the message with the given ID.
+func (s *Store) reactableMessage(messageID int64, user string) (*models.Message, error) {
+	m, err := s.GetMessageByID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, ErrMessageNotFound
+	}
+	if m.Username != user && m.Recipient != user {
+		return nil, ErrNotParticipant
+	}
+	if m.Deleted {
+		return nil, ErrMessageDeleted
+	}
+	return m, nil
+}
+
+// SetReaction stores or replaces reactor's encrypted reaction on a message.
+// It returns the message reacted to.
+func (s *Store) SetReaction(messageID int64, reactor, ciphertext string) (*models.Message, error) {
+	m, err := s.reactableMessage(messageID, reactor)
+	if err != nil {
+		return nil, err
+	}
+	stmt := `
+		INSERT INTO reactions (message_id, reactor, ciphertext) VALUES (?, ?, ?)
+		ON CONFLICT(message_id, reactor) DO UPDATE SET ciphertext = excluded.ciphertext, created_at = CURRENT_TIMESTAMP`
+	if _, err := s.db.Exec(stmt, messageID, reactor, ciphertext); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// RemoveReaction deletes reactor's reaction on a message.
+// It returns the message reacted to.
+func (s *Store) RemoveReaction(messageID int64, reactor string) (*models.Message, error) {
+	m, err := s.reactableMessage(messageID, reactor)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.db.Exec(`DELETE FROM reactions WHERE message_id = ? AND reactor = ?`, messageID, reactor); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// attachReactions loads the reactions for the given messages.
+func (s *Store) attachReactions(messages []models.Message) error {
+	index := make(map[int64]int, len(messages))
+	for i, m := range messages {
+		index[m.ID] = i
+	}
+	return forEachBatch(messages, func(placeholders string, args []any) error {
+		stmt := `SELECT message_id, reactor, ciphertext, created_at FROM reactions WHERE message_id IN (` + placeholders + `) ORDER BY created_at ASC, reactor ASC`
+		rows, err := s.db.Query(stmt, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var r models.Reaction
+			if err := rows.Scan(&r.MessageID, &r.Reactor, &r.Ciphertext, &r.CreatedAt); err != nil {
+				return err
+			}
+			i := index[r.MessageID]
+			messages[i].Reactions = append(messages[i].Reactions, r)
+		}
+		return rows.Err()
+	})
+}
+
+// messageBatchSize keeps IN (...) lists well below SQLite's variable limit.
+const messageBatchSize = 500
+
+// forEachBatch calls fn with placeholders and arguments for the IDs of
+// successive batches of messages.
+func forEachBatch(messages []models.Message, fn func(placeholders string, args []any) error) error {
+	for start := 0; start < len(messages); start += messageBatchSize {
+		end := min(start+messageBatchSize, len(messages))
+		args := make([]any, 0, end-start)
+		for _, m := range messages[start:end] {
+			args = append(args, m.ID)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+		if err := fn(placeholders, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
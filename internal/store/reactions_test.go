@@ -0,0 +1,57 @@
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+func TestStore_Reactions(t *testing.T) {
+	dbPath := "test_reactions.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	id, err := store.CreateMessage(1, "alice", "bob", "ciphertext")
+	if err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+
+	if _, err := store.SetReaction(id, "mallory", "enc-emoji"); err != ErrNotParticipant {
+		t.Errorf("expected ErrNotParticipant, got %v", err)
+	}
+	if _, err := store.SetReaction(id, "bob", "enc-thumbs-up"); err != nil {
+		t.Fatalf("failed to react: %v", err)
+	}
+	// Reacting again replaces the previous reaction
+	if _, err := store.SetReaction(id, "bob", "enc-heart"); err != nil {
+		t.Fatalf("failed to replace reaction: %v", err)
+	}
+	if _, err := store.SetReaction(id, "alice", "enc-laugh"); err != nil {
+		t.Fatalf("failed to react: %v", err)
+	}
+
+	messages, err := store.GetMessagesBetween("alice", "bob")
+	if err != nil {
+		t.Fatalf("failed to fetch messages: %v", err)
+	}
+	if len(messages) != 1 || len(messages[0].Reactions) != 2 {
+		t.Fatalf("expected 2 reactions, got %+v", messages)
+	}
+	for _, r := range messages[0].Reactions {
+		if r.Reactor == "bob" && r.Ciphertext != "enc-heart" {
+			t.Errorf("expected bob's reaction to be replaced, got %+v", r)
+		}
+	}
+
+	if _, err := store.RemoveReaction(id, "bob"); err != nil {
+		t.Fatalf("failed to remove reaction: %v", err)
+	}
+	messages, _ = store.GetMessagesBetween("alice", "bob")
+	if len(messages[0].Reactions) != 1 || messages[0].Reactions[0].Reactor != "alice" {
+		t.Errorf("expected only alice's reaction, got %+v", messages[0].Reactions)
+	}
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// RetentionPolicy describes which messages the purge job removes.
+// A zero value for any field disables that rule.
+type RetentionPolicy struct {
+	MaxAge             time.Duration // delete messages older than this
+	MaxPerConversation int           // keep only the newest N messages per conversation
+	DeliveredMaxAge    time.Duration // delete delivered messages older than this
+}
+
+// Enabled reports whether at least one retention rule is active.
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxPerConversation > 0 || p.DeliveredMaxAge > 0
+}
+
+// PurgeReport holds the number of rows removed by each retention rule.
+type PurgeReport struct {
+	Expired   int64 `json:"expired"`
+	Delivered int64 `json:"delivered"`
+	OverLimit int64 `json:"over_limit"`
+	DryRun    bool  `json:"dry_run"`
+}
+
+// Total returns the number of rows purged across all rules.
+func (r PurgeReport) Total() int64 {
+	return r.Expired + r.Delivered + r.OverLimit
+}
+
+// PurgeMessages applies the retention policy to the messages table.
+// Rules run in order (age, delivered, per-conversation limit), so a row is
+// counted once under the first rule that matches it. With dryRun set the
+// deletes are rolled back and only the counts are reported.
+func (s *Store) PurgeMessages(policy RetentionPolicy, dryRun bool) (PurgeReport, error) {
+	report := PurgeReport{DryRun: dryRun}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	if policy.MaxAge > 0 {
+		res, err := tx.Exec(`DELETE FROM messages WHERE created_at < datetime('now', ?)`, sqliteOffset(policy.MaxAge))
+		if err != nil {
+			return report, err
+		}
+		report.Expired, _ = res.RowsAffected()
+	}
+	if policy.DeliveredMaxAge > 0 {
+		res, err := tx.Exec(`DELETE FROM messages WHERE delivered_at IS NOT NULL AND delivered_at < datetime('now', ?)`, sqliteOffset(policy.DeliveredMaxAge))
+		if err != nil {
+			return report, err
+		}
+		report.Delivered, _ = res.RowsAffected()
+	}
+	if policy.MaxPerConversation > 0 {
+		stmt := `
+			DELETE FROM messages WHERE id IN (
+				SELECT id FROM (
+					SELECT id, ROW_NUMBER() OVER (
+						PARTITION BY MIN(username, recipient), MAX(username, recipient)
+						ORDER BY created_at DESC, id DESC
+					) AS rn
+					FROM messages
+				) WHERE rn > ?
+			)`
+		res, err := tx.Exec(stmt, policy.MaxPerConversation)
+		if err != nil {
+			return report, err
+		}
+		report.OverLimit, _ = res.RowsAffected()
+	}
+
+	for _, stmt := range []string{
+		`DELETE FROM reactions WHERE message_id NOT IN (SELECT id FROM messages)`,
+		`DELETE FROM message_attachments WHERE message_id NOT IN (SELECT id FROM messages)`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return report, err
+		}
+	}
+	if report.Total() > 0 {
+		if err := refreshConversations(tx); err != nil {
+			return report, err
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	return report, tx.Commit()
+}
+
+// IncrementalVacuum returns up to pages free pages to the filesystem.
+// A non-positive value frees all of them.
+func (s *Store) IncrementalVacuum(pages int) error {
+	if pages <= 0 {
+		_, err := s.db.Exec("PRAGMA incremental_vacuum;")
+		return err
+	}
+	_, err := s.db.Exec(fmt.Sprintf("PRAGMA incremental_vacuum(%d);", pages))
+	return err
+}
+
+// sqliteOffset formats d as a negative modifier for SQLite's datetime().
+func sqliteOffset(d time.Duration) string {
+	return fmt.Sprintf("-%d seconds", int64(d/time.Second))
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStore_PurgeMessages(t *testing.T) {
+	dbPath := "test_retention.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// alice <-> bob: one old message, one delivered a while ago, three recent
+	for _, m := range []struct{ from, to string }{
+		{"alice", "bob"}, {"bob", "alice"}, {"alice", "bob"}, {"bob", "alice"}, {"alice", "bob"},
+	} {
+		if _, err := store.CreateMessage(1, m.from, m.to, "ciphertext"); err != nil {
+			t.Fatalf("failed to create message: %v", err)
+		}
+	}
+	if _, err := store.db.Exec(`UPDATE messages SET created_at = datetime('now', '-40 days') WHERE id = 1`); err != nil {
+		t.Fatalf("failed to age message: %v", err)
+	}
+	if _, err := store.db.Exec(`UPDATE messages SET delivered_at = datetime('now', '-10 days') WHERE id = 2`); err != nil {
+		t.Fatalf("failed to mark message delivered: %v", err)
+	}
+
+	policy := RetentionPolicy{
+		MaxAge:             30 * 24 * time.Hour,
+		DeliveredMaxAge:    7 * 24 * time.Hour,
+		MaxPerConversation: 2,
+	}
+
+	report, err := store.PurgeMessages(policy, true)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if report.Expired != 1 || report.Delivered != 1 || report.OverLimit != 1 {
+		t.Errorf("unexpected dry run report: %+v", report)
+	}
+	messages, _ := store.GetMessagesBetween("alice", "bob")
+	if len(messages) != 5 {
+		t.Fatalf("dry run deleted rows: expected 5 messages, got %d", len(messages))
+	}
+
+	report, err = store.PurgeMessages(policy, false)
+	if err != nil {
+		t.Fatalf("purge failed: %v", err)
+	}
+	if report.Total() != 3 {
+		t.Errorf("expected 3 purged messages, got %+v", report)
+	}
+	messages, _ = store.GetMessagesBetween("alice", "bob")
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 remaining messages, got %d", len(messages))
+	}
+	if messages[0].ID != 4 || messages[1].ID != 5 {
+		t.Errorf("expected newest messages to remain, got ids %d and %d", messages[0].ID, messages[1].ID)
+	}
+
+	if err := store.IncrementalVacuum(0); err != nil {
+		t.Errorf("incremental vacuum failed: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+// ErrSessionNotFound is returned when a session does not exist, belongs to
+// someone else or was already revoked.
+var ErrSessionNotFound = kindError(ErrNotFound, "session not found")
+
+// sessionTouchInterval throttles last-use updates so authenticated requests
+// don't all write to the database.
+const sessionTouchInterval = time.Minute
+
+// CreateSession records a new login session and returns its ID. A positive
+// ttl sets when it lapses unless refreshed; zero means it lasts until revoked.
+func (s *Store) CreateSession(username, deviceName, userAgent, ip string, ttl time.Duration) (string, error) {
+	id, err := NewTokenID()
+	if err != nil {
+		return "", err
+	}
+	var expires interface{}
+	if ttl > 0 {
+		expires = sqliteFuture(ttl)
+	}
+	stmt := `
+		INSERT INTO sessions (id, username, device_name, user_agent, ip, expires_at)
+		VALUES (?, ?, ?, ?, ?, datetime('now', ?))`
+	if _, err := s.db.Exec(stmt, id, username, deviceName, userAgent, ip, expires); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// TouchSession records that a session was just used from ip with userAgent.
+func (s *Store) TouchSession(id, userAgent, ip string) error {
+	stmt := `
+		UPDATE sessions SET last_used_at = CURRENT_TIMESTAMP, user_agent = ?, ip = ?
+		WHERE id = ? AND revoked_at IS NULL
+		  AND (last_used_at < datetime('now', ?) OR user_agent <> ? OR ip <> ?)`
+	_, err := s.db.Exec(stmt, userAgent, ip, id, sqliteOffset(sessionTouchInterval), userAgent, ip)
+	return err
+}
+
+// ListSessions returns username's active sessions, most recently used first.
+func (s *Store) ListSessions(username string) ([]models.Session, error) {
+	stmt := `
+		SELECT id, device_name, user_agent, ip, created_at, last_used_at
+		FROM sessions
+		WHERE username = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		ORDER BY last_used_at DESC, created_at DESC`
+	rows, err := s.db.Query(stmt, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(&session.ID, &session.DeviceName, &session.UserAgent, &session.IP,
+			&session.CreatedAt, &session.LastUsedAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes a session and its refresh tokens; access tokens
+// carrying the session ID stop working too.
+func (s *Store) RevokeSession(sessionID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL`, sessionID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE session_id = ? AND revoked_at IS NULL`, sessionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokeUserSession revokes one of username's active sessions.
+func (s *Store) RevokeUserSession(username, sessionID string) error {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM sessions WHERE id = ? AND username = ? AND revoked_at IS NULL)`,
+		sessionID, username).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrSessionNotFound
+	}
+	return s.RevokeSession(sessionID)
+}
+
+// RevokeUserSessions revokes all of username's sessions except keep (which
+// may be empty), e.g. after a password change. It returns the revoked IDs.
+func (s *Store) RevokeUserSessions(username, keep string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT id FROM sessions WHERE username = ? AND id <> ? AND revoked_at IS NULL`, username, keep)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if err := s.RevokeSession(id); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// sqliteFuture converts a duration into a datetime() modifier pointing forward.
+func sqliteFuture(d time.Duration) string {
+	return fmt.Sprintf("+%d seconds", int64(d/time.Second))
+}
@@ -1,8 +1,11 @@
 package store
 
 import (
+	"context"
 	"database/sql"
-	"errors"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/edpsouza/chatterbox/internal/models"
 	_ "github.com/mattn/go-sqlite3"
@@ -13,9 +16,17 @@ type Store struct {
 	db *sql.DB
 }
 
+// busyTimeout is how long a statement waits for another connection's write
+// lock before failing with "database is locked".
+const busyTimeout = 5 * time.Second
+
 // NewStore initializes the SQLite database and returns a Store.
 func NewStore(dbPath string) (*Store, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	sep := "?"
+	if strings.Contains(dbPath, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite3", dbPath+sep+"_busy_timeout="+strconv.FormatInt(busyTimeout.Milliseconds(), 10))
 	if err != nil {
 		return nil, err
 	}
@@ -23,6 +34,9 @@ func NewStore(dbPath string) (*Store, error) {
 		return nil, err
 	}
 	store := &Store{db: db}
+	if err := store.enableIncrementalVacuum(); err != nil {
+		return nil, err
+	}
 	if err := store.migrate(); err != nil {
 		return nil, err
 	}
@@ -40,7 +54,14 @@ func (s *Store) migrate() error {
 		password TEXT NOT NULL,
 		public_key TEXT,
 		status TEXT DEFAULT 'offline',
-		last_seen DATETIME
+		last_seen DATETIME,
+		display_name TEXT,
+		bio TEXT,
+		avatar_id TEXT,
+		profile_encrypted INTEGER NOT NULL DEFAULT 0,
+		profile_version INTEGER NOT NULL DEFAULT 0,
+		profile_updated_at DATETIME,
+		username_skeleton TEXT
 	);`
 	_, err = s.db.Exec(userTable)
 	if err != nil {
@@ -76,6 +97,23 @@ func (s *Store) migrate() error {
 			return err
 		}
 	}
+	profileColumns := []struct{ name, definition string }{
+		{"display_name", "TEXT"},
+		{"bio", "TEXT"},
+		{"avatar_id", "TEXT"},
+		{"profile_encrypted", "INTEGER NOT NULL DEFAULT 0"},
+		{"profile_version", "INTEGER NOT NULL DEFAULT 0"},
+		{"profile_updated_at", "DATETIME"},
+		{"username_skeleton", "TEXT"},
+	}
+	for _, column := range profileColumns {
+		if columns[column.name] {
+			continue
+		}
+		if _, err := s.db.Exec("ALTER TABLE users ADD COLUMN " + column.name + " " + column.definition); err != nil {
+			return err
+		}
+	}
 	messageTable := `
 	CREATE TABLE IF NOT EXISTS messages (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -114,16 +152,308 @@ func (s *Store) migrate() error {
 			return err
 		}
 	}
-	return nil
+	for _, col := range []struct{ name, def string }{
+		{"delivered_at", "DATETIME"},
+		{"version", "INTEGER NOT NULL DEFAULT 0"},
+		{"edited_at", "DATETIME"},
+		{"deleted_at", "DATETIME"},
+		{"reply_to", "INTEGER REFERENCES messages(id)"},
+	} {
+		if !columns[col.name] {
+			_, err = s.db.Exec("ALTER TABLE messages ADD COLUMN " + col.name + " " + col.def)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	pendingTable := `
+	CREATE TABLE IF NOT EXISTS pending_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		recipient TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+	_, err = s.db.Exec(pendingTable)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("CREATE INDEX IF NOT EXISTS idx_pending_events_recipient ON pending_events(recipient)")
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("CREATE INDEX IF NOT EXISTS idx_messages_reply_to ON messages(reply_to)")
+	if err != nil {
+		return err
+	}
+
+	attachmentTables := `
+	CREATE TABLE IF NOT EXISTS attachments (
+		id TEXT PRIMARY KEY,
+		owner TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		received INTEGER NOT NULL DEFAULT 0,
+		digest TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		completed_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_attachments_owner ON attachments(owner);
+	CREATE INDEX IF NOT EXISTS idx_attachments_digest ON attachments(digest);
+	CREATE TABLE IF NOT EXISTS message_attachments (
+		message_id INTEGER NOT NULL,
+		attachment_id TEXT NOT NULL,
+		PRIMARY KEY (message_id, attachment_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_message_attachments_attachment ON message_attachments(attachment_id);`
+	_, err = s.db.Exec(attachmentTables)
+	if err != nil {
+		return err
+	}
+
+	var hasConversations bool
+	err = s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'conversations')`).Scan(&hasConversations)
+	if err != nil {
+		return err
+	}
+	conversationTable := `
+	CREATE TABLE IF NOT EXISTS conversations (
+		owner TEXT NOT NULL,
+		peer TEXT NOT NULL,
+		last_message_id INTEGER NOT NULL,
+		last_message_at DATETIME NOT NULL,
+		unread_count INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (owner, peer)
+	);`
+	_, err = s.db.Exec(conversationTable)
+	if err != nil {
+		return err
+	}
+	if !hasConversations {
+		// Backfill summaries for messages stored before the table existed
+		if err := s.backfillConversations(); err != nil {
+			return err
+		}
+	}
+
+	privacyTable := `
+	CREATE TABLE IF NOT EXISTS privacy_settings (
+		username TEXT PRIMARY KEY,
+		last_seen TEXT NOT NULL DEFAULT 'everyone',
+		online_status TEXT NOT NULL DEFAULT 'everyone',
+		invisible INTEGER NOT NULL DEFAULT 0
+	);`
+	_, err = s.db.Exec(privacyTable)
+	if err != nil {
+		return err
+	}
+	for _, column := range []string{"hide_from_blocked", "discoverable"} {
+		var exists bool
+		err = s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pragma_table_info('privacy_settings') WHERE name = ?)`, column).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			_, err = s.db.Exec("ALTER TABLE privacy_settings ADD COLUMN " + column + " INTEGER NOT NULL DEFAULT 1")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	contactTables := `
+	CREATE TABLE IF NOT EXISTS contacts (
+		owner TEXT NOT NULL,
+		contact TEXT NOT NULL,
+		status TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (owner, contact)
+	);
+	CREATE TABLE IF NOT EXISTS blocks (
+		blocker TEXT NOT NULL,
+		blocked TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (blocker, blocked)
+	);`
+	_, err = s.db.Exec(contactTables)
+	if err != nil {
+		return err
+	}
+
+	reactionTable := `
+	CREATE TABLE IF NOT EXISTS reactions (
+		message_id INTEGER NOT NULL,
+		reactor TEXT NOT NULL,
+		ciphertext TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (message_id, reactor)
+	);`
+	_, err = s.db.Exec(reactionTable)
+	if err != nil {
+		return err
+	}
+
+	tokenTables := `
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		token_hash TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		session_id TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		used_at DATETIME,
+		revoked_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session ON refresh_tokens(session_id);
+	CREATE INDEX IF NOT EXISTS idx_refresh_tokens_username ON refresh_tokens(username);
+	CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti TEXT PRIMARY KEY,
+		expires_at DATETIME NOT NULL
+	);`
+	_, err = s.db.Exec(tokenTables)
+	if err != nil {
+		return err
+	}
+
+	sessionTable := `
+	CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		device_name TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		ip TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME,
+		revoked_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_sessions_username ON sessions(username);`
+	_, err = s.db.Exec(sessionTable)
+	if err != nil {
+		return err
+	}
+	twoFactorTables := `
+	CREATE TABLE IF NOT EXISTS two_factor (
+		username TEXT PRIMARY KEY,
+		secret TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 0,
+		last_step INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		username TEXT NOT NULL,
+		code_hash TEXT NOT NULL,
+		used_at DATETIME,
+		PRIMARY KEY (username, code_hash)
+	);`
+	_, err = s.db.Exec(twoFactorTables)
+	if err != nil {
+		return err
+	}
+
+	loginFailureTable := `
+	CREATE TABLE IF NOT EXISTS login_failures (
+		key TEXT PRIMARY KEY,
+		failures INTEGER NOT NULL DEFAULT 0,
+		last_failure DATETIME NOT NULL,
+		locked_until DATETIME
+	);`
+	_, err = s.db.Exec(loginFailureTable)
+	if err != nil {
+		return err
+	}
+
+	passwordResetTable := `
+	CREATE TABLE IF NOT EXISTS password_resets (
+		token_hash TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		used_at DATETIME
+	);`
+	_, err = s.db.Exec(passwordResetTable)
+	if err != nil {
+		return err
+	}
+
+	// Sessions issued before the sessions table existed only live in refresh_tokens
+	_, err = s.db.Exec(`
+		INSERT OR IGNORE INTO sessions (id, username, created_at, last_used_at, expires_at, revoked_at)
+		SELECT session_id, username, MIN(created_at), MAX(COALESCE(used_at, created_at)), MAX(expires_at), MAX(revoked_at)
+		FROM refresh_tokens GROUP BY session_id`)
+	if err != nil {
+		return err
+	}
+
+	usernameTables := `
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_skeleton ON users(username_skeleton);
+	CREATE TABLE IF NOT EXISTS username_collisions (
+		username TEXT NOT NULL,
+		conflicts_with TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		detected_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (username, conflicts_with)
+	);
+	CREATE TABLE IF NOT EXISTS retired_usernames (
+		skeleton TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		retired_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS username_skeleton_version (
+		version INTEGER NOT NULL
+	);`
+	_, err = s.db.Exec(usernameTables)
+	if err != nil {
+		return err
+	}
+	return s.migrateUsernames()
+}
+
+// enableIncrementalVacuum switches the database to incremental auto-vacuum so
+// pages freed by the retention job can be returned to the filesystem.
+// Existing databases need a one-off VACUUM for the mode change to take effect.
+func (s *Store) enableIncrementalVacuum() error {
+	var mode int
+	if err := s.db.QueryRow("PRAGMA auto_vacuum;").Scan(&mode); err != nil {
+		return err
+	}
+	if mode == 2 {
+		return nil
+	}
+	// The pragma and VACUUM must run on the same connection.
+	conn, err := s.db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), "PRAGMA auto_vacuum = INCREMENTAL;"); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(context.Background(), "VACUUM;")
+	return err
 }
 
 // CreateUser inserts a new user into the database.
 func (s *Store) CreateUser(user *models.User) error {
-	stmt := `INSERT INTO users (username, password, public_key) VALUES (?, ?, ?)`
-	result, err := s.db.Exec(stmt, user.Username, user.Password, user.PublicKey)
+	// Names of deleted accounts, and names that look like them, stay taken
+	var retired bool
+	if err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM retired_usernames WHERE skeleton = ?)`, models.UsernameSkeleton(user.Username)).Scan(&retired); err != nil {
+		return err
+	}
+	if retired {
+		return ErrUsernameTaken
+	}
+	stmt := `INSERT INTO users (username, password, public_key, display_name, username_skeleton) VALUES (?, ?, ?, NULLIF(?, ''), ?)`
+	result, err := s.db.Exec(stmt, user.Username, user.Password, user.PublicKey, user.DisplayName, models.UsernameSkeleton(user.Username))
 	if err != nil {
 		if sqliteIsUniqueConstraint(err) {
-			return errors.New("username already exists")
+			return ErrUsernameTaken
+		}
+		if isSkeletonConflict(err) {
+			// The skeleton index can fire before the username one
+			var exists bool
+			if s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE username = ?)`, user.Username).Scan(&exists); exists {
+				return ErrUsernameTaken
+			}
+			return ErrUsernameConfusable
 		}
 		return err
 	}
@@ -134,14 +464,17 @@ func (s *Store) CreateUser(user *models.User) error {
 	return nil
 }
 
-// GetUserByUsername fetches a user by username.
+// GetUserByUsername fetches a user by username, in any case or Unicode
+// form. An account whose exact name was given is preferred, so accounts
+// left unnormalized by a username collision remain reachable.
 func (s *Store) GetUserByUsername(username string) (*models.User, error) {
-	stmt := `SELECT id, username, password, public_key, status, last_seen FROM users WHERE LOWER(username) = LOWER(?)`
+	stmt := `SELECT id, username, password, public_key, status, last_seen, COALESCE(display_name, '') FROM users
+		WHERE username IN (?, ?) ORDER BY username = ? DESC LIMIT 1`
 
-	row := s.db.QueryRow(stmt, username)
+	row := s.db.QueryRow(stmt, username, models.CanonicalUsername(username), username)
 	var user models.User
 	var lastSeen sql.NullString
-	err := row.Scan(&user.ID, &user.Username, &user.Password, &user.PublicKey, &user.Status, &lastSeen)
+	err := row.Scan(&user.ID, &user.Username, &user.Password, &user.PublicKey, &user.Status, &lastSeen, &user.DisplayName)
 	if lastSeen.Valid {
 		user.LastSeen = lastSeen.String
 	} else {
@@ -168,11 +501,61 @@ func (s *Store) Close() error {
 	return s.db.Close()
 }
 
-// CreateMessage inserts a new chat message into the database.
-func (s *Store) CreateMessage(userID int64, username, recipient, content string) error {
-	stmt := `INSERT INTO messages (user_id, username, recipient, content) VALUES (?, ?, ?, ?)`
-	_, err := s.db.Exec(stmt, userID, username, recipient, content)
-	return err
+// CreateMessage inserts a new chat message into the database and returns its ID.
+func (s *Store) CreateMessage(userID int64, username, recipient, content string) (int64, error) {
+	return s.CreateReply(userID, username, recipient, content, 0)
+}
+
+// ErrInvalidReply is returned when a reply targets a message outside the conversation.
+var ErrInvalidReply = kindError(ErrInvalid, "reply_to must reference a message in the same conversation")
+
+// CreateReply inserts a chat message that quotes replyTo and returns its ID.
+// The quoted message must belong to the same conversation; a zero replyTo
+// creates a plain message.
+func (s *Store) CreateReply(userID int64, username, recipient, content string, replyTo int64) (int64, error) {
+	return s.CreateReplyWithAttachments(userID, username, recipient, content, replyTo, nil)
+}
+
+// CreateReplyWithAttachments is CreateReply for a message that references
+// attachments; the message is stored together with its links or not at all.
+func (s *Store) CreateReplyWithAttachments(userID int64, username, recipient, content string, replyTo int64, attachments []string) (int64, error) {
+	var parent sql.NullInt64
+	if replyTo != 0 {
+		m, err := s.GetMessageByID(replyTo)
+		if err != nil {
+			return 0, err
+		}
+		if m == nil || !sameConversation(m, username, recipient) {
+			return 0, ErrInvalidReply
+		}
+		parent = sql.NullInt64{Int64: replyTo, Valid: true}
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	stmt := `INSERT INTO messages (user_id, username, recipient, content, reply_to) VALUES (?, ?, ?, ?, ?)`
+	result, err := tx.Exec(stmt, userID, username, recipient, content, parent)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := recordConversationMessage(tx, id, username, recipient); err != nil {
+		return 0, err
+	}
+	if err := linkAttachments(tx, id, attachments); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// sameConversation reports whether m was exchanged between userA and userB.
+func sameConversation(m *models.Message, userA, userB string) bool {
+	return (m.Username == userA && m.Recipient == userB) || (m.Username == userB && m.Recipient == userA)
 }
 
 // GetRecentMessages fetches the most recent N messages.
@@ -212,31 +595,186 @@ func (s *Store) GetRecentMessages(limit int) ([]struct {
 	return messages, nil
 }
 
+// messageColumns lists the columns read by scanMessage, in order.
+const messageColumns = `id, user_id, username, recipient, content, created_at, version, edited_at, deleted_at, reply_to`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanMessage reads a message row selected with messageColumns.
+// Deleted messages come back as tombstones with no content.
+func scanMessage(row rowScanner) (models.Message, error) {
+	var m models.Message
+	var editedAt, deletedAt sql.NullString
+	var replyTo sql.NullInt64
+	if err := row.Scan(&m.ID, &m.UserID, &m.Username, &m.Recipient, &m.Content, &m.CreatedAt, &m.Version, &editedAt, &deletedAt, &replyTo); err != nil {
+		return m, err
+	}
+	m.EditedAt = editedAt.String
+	m.ReplyTo = replyTo.Int64
+	if deletedAt.Valid {
+		m.Deleted = true
+		m.Content = ""
+	}
+	return m, nil
+}
+
+// GetMessageByID fetches a single message. It returns nil if the message does not exist.
+func (s *Store) GetMessageByID(id int64) (*models.Message, error) {
+	row := s.db.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE id = ?`, id)
+	m, err := scanMessage(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Errors returned by EditMessage and DeleteMessage.
+var (
+	ErrMessageNotFound   = kindError(ErrNotFound, "message not found")
+	ErrNotMessageSender  = kindError(ErrForbidden, "only the sender can change a message")
+	ErrEditWindowExpired = kindError(ErrForbidden, "edit window has expired")
+	ErrMessageDeleted    = kindError(ErrConflict, "message has been deleted")
+)
+
+// checkMutable verifies that sender may still change the message with the given ID.
+func (s *Store) checkMutable(id int64, sender string, window time.Duration) (*models.Message, error) {
+	m, err := s.GetMessageByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, ErrMessageNotFound
+	}
+	if m.Username != sender {
+		return nil, ErrNotMessageSender
+	}
+	if m.Deleted {
+		return nil, ErrMessageDeleted
+	}
+	if window > 0 {
+		var expired bool
+		err := s.db.QueryRow(`SELECT created_at < datetime('now', ?) FROM messages WHERE id = ?`, sqliteOffset(window), id).Scan(&expired)
+		if err != nil {
+			return nil, err
+		}
+		if expired {
+			return nil, ErrEditWindowExpired
+		}
+	}
+	return m, nil
+}
+
+// EditMessage replaces the ciphertext of a message sent by sender and bumps its version.
+// A zero window allows edits at any time.
+func (s *Store) EditMessage(id int64, sender, content string, window time.Duration) (*models.Message, error) {
+	if _, err := s.checkMutable(id, sender, window); err != nil {
+		return nil, err
+	}
+	stmt := `UPDATE messages SET content = ?, version = version + 1, edited_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL`
+	if _, err := s.db.Exec(stmt, content, id); err != nil {
+		return nil, err
+	}
+	return s.GetMessageByID(id)
+}
+
+// DeleteMessage tombstones a message sent by sender, discarding its ciphertext.
+// A zero window allows deletes at any time.
+func (s *Store) DeleteMessage(id int64, sender string, window time.Duration) (*models.Message, error) {
+	if _, err := s.checkMutable(id, sender, window); err != nil {
+		return nil, err
+	}
+	stmt := `UPDATE messages SET content = '', version = version + 1, deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL`
+	if _, err := s.db.Exec(stmt, id); err != nil {
+		return nil, err
+	}
+	if _, err := s.db.Exec(`DELETE FROM reactions WHERE message_id = ?`, id); err != nil {
+		return nil, err
+	}
+	if _, err := s.db.Exec(`DELETE FROM message_attachments WHERE message_id = ?`, id); err != nil {
+		return nil, err
+	}
+	return s.GetMessageByID(id)
+}
+
 // GetMessagesBetween fetches encrypted messages exchanged between two users, ordered by created_at ascending.
+// Deleted messages are returned as tombstones.
 func (s *Store) GetMessagesBetween(userA, userB string) ([]models.Message, error) {
 	stmt := `
-		SELECT id, user_id, username, recipient, content, created_at
+		SELECT ` + messageColumns + `
 		FROM messages
 		WHERE (username = ? AND recipient = ?)
 		   OR (username = ? AND recipient = ?)
-		ORDER BY created_at ASC
+		ORDER BY created_at ASC, id ASC
 	`
-	rows, err := s.db.Query(stmt, userA, userB, userB, userA)
+	return s.queryMessages(stmt, userA, userB, userB, userA)
+}
+
+// GetThread fetches a thread root and every reply below it, ordered by created_at ascending.
+// Only messages exchanged between userA and userB are returned.
+func (s *Store) GetThread(userA, userB string, rootID int64) ([]models.Message, error) {
+	stmt := `
+		WITH RECURSIVE thread(id) AS (
+			SELECT id FROM messages WHERE id = ?
+			UNION
+			SELECT m.id FROM messages m JOIN thread t ON m.reply_to = t.id
+		)
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE id IN (SELECT id FROM thread)
+		  AND ((username = ? AND recipient = ?) OR (username = ? AND recipient = ?))
+		ORDER BY created_at ASC, id ASC
+	`
+	return s.queryMessages(stmt, rootID, userA, userB, userB, userA)
+}
+
+// queryMessages runs a query selecting messageColumns and attaches reactions to the results.
+func (s *Store) queryMessages(stmt string, args ...any) ([]models.Message, error) {
+	rows, err := s.db.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var messages []models.Message
 	for rows.Next() {
-		var m models.Message
-		if err := rows.Scan(&m.ID, &m.UserID, &m.Username, &m.Recipient, &m.Content, &m.CreatedAt); err != nil {
+		m, err := scanMessage(rows)
+		if err != nil {
 			return nil, err
 		}
 		messages = append(messages, m)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.attachReactions(messages); err != nil {
+		return nil, err
+	}
+	if err := s.attachAttachmentIDs(messages); err != nil {
+		return nil, err
+	}
 	return messages, nil
 }
 
+// MarkMessagesDelivered records that all undelivered messages from sender to
+// recipient have reached the recipient.
+func (s *Store) MarkMessagesDelivered(sender, recipient string) error {
+	stmt := `UPDATE messages SET delivered_at = CURRENT_TIMESTAMP WHERE username = ? AND recipient = ? AND delivered_at IS NULL`
+	_, err := s.db.Exec(stmt, sender, recipient)
+	return err
+}
+
+// MarkMessageDelivered records that the message with the given id has
+// reached its recipient.
+func (s *Store) MarkMessageDelivered(id int64) error {
+	_, err := s.db.Exec(`UPDATE messages SET delivered_at = CURRENT_TIMESTAMP WHERE id = ? AND delivered_at IS NULL`, id)
+	return err
+}
+
 // SetUserStatus updates the user's status (e.g., "online", "offline").
 func (s *Store) SetUserStatus(username, status string) error {
 	stmt := `UPDATE users SET status = ? WHERE username = ?`
@@ -244,6 +782,13 @@ func (s *Store) SetUserStatus(username, status string) error {
 	return err
 }
 
+// ResetPresence marks every user offline. It is called at startup, when no
+// connections exist and any stored online/away status is stale.
+func (s *Store) ResetPresence() error {
+	_, err := s.db.Exec(`UPDATE users SET status = 'offline' WHERE status <> 'offline'`)
+	return err
+}
+
 // SetUserLastSeen updates the user's last_seen timestamp.
 func (s *Store) SetUserLastSeen(username, timestamp string) error {
 	stmt := `UPDATE users SET last_seen = ? WHERE username = ?`
@@ -2,7 +2,9 @@ package store
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/edpsouza/chatterbox/internal/models"
 )
@@ -35,7 +37,7 @@ func TestStore_CreateAndFetchMessage(t *testing.T) {
 	}
 
 	// Create a message
-	err = store.CreateMessage(fetchedUser.ID, "testuser", "recipientuser", "ciphertext123")
+	_, err = store.CreateMessage(fetchedUser.ID, "testuser", "recipientuser", "ciphertext123")
 	if err != nil {
 		t.Fatalf("failed to create message: %v", err)
 	}
@@ -55,3 +57,156 @@ func TestStore_CreateAndFetchMessage(t *testing.T) {
 		t.Errorf("unexpected sender/recipient: got %s -> %s", messages[0].Username, messages[0].Recipient)
 	}
 }
+
+func TestStore_EditAndDeleteMessage(t *testing.T) {
+	dbPath := "test_edit.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	id, err := store.CreateMessage(1, "alice", "bob", "original")
+	if err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+
+	if _, err := store.EditMessage(id, "bob", "hijacked", time.Minute); err != ErrNotMessageSender {
+		t.Errorf("expected ErrNotMessageSender, got %v", err)
+	}
+
+	edited, err := store.EditMessage(id, "alice", "edited", time.Minute)
+	if err != nil {
+		t.Fatalf("failed to edit message: %v", err)
+	}
+	if edited.Content != "edited" || edited.Version != 1 || edited.EditedAt == "" {
+		t.Errorf("unexpected edited message: %+v", edited)
+	}
+
+	if _, err := store.DeleteMessage(id, "alice", time.Minute); err != nil {
+		t.Fatalf("failed to delete message: %v", err)
+	}
+	messages, err := store.GetMessagesBetween("bob", "alice")
+	if err != nil {
+		t.Fatalf("failed to fetch messages: %v", err)
+	}
+	if len(messages) != 1 || !messages[0].Deleted || messages[0].Content != "" || messages[0].Version != 2 {
+		t.Errorf("expected tombstone, got %+v", messages)
+	}
+	if _, err := store.EditMessage(id, "alice", "again", time.Minute); err != ErrMessageDeleted {
+		t.Errorf("expected ErrMessageDeleted, got %v", err)
+	}
+
+	// Messages outside the window can no longer be changed
+	old, _ := store.CreateMessage(1, "alice", "bob", "old")
+	if _, err := store.db.Exec(`UPDATE messages SET created_at = datetime('now', '-1 hour') WHERE id = ?`, old); err != nil {
+		t.Fatalf("failed to age message: %v", err)
+	}
+	if _, err := store.DeleteMessage(old, "alice", time.Minute); err != ErrEditWindowExpired {
+		t.Errorf("expected ErrEditWindowExpired, got %v", err)
+	}
+}
+
+func TestStore_RepliesAndThreads(t *testing.T) {
+	dbPath := "test_threads.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	root, _ := store.CreateMessage(1, "alice", "bob", "root")
+	other, _ := store.CreateMessage(1, "alice", "carol", "elsewhere")
+	reply, err := store.CreateReply(2, "bob", "alice", "reply", root)
+	if err != nil {
+		t.Fatalf("failed to create reply: %v", err)
+	}
+	if _, err := store.CreateReply(1, "alice", "bob", "nested", reply); err != nil {
+		t.Fatalf("failed to create nested reply: %v", err)
+	}
+	if _, err := store.CreateMessage(1, "alice", "bob", "unrelated"); err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+
+	// Replies must stay inside the conversation
+	if _, err := store.CreateReply(2, "bob", "alice", "cross-post", other); err != ErrInvalidReply {
+		t.Errorf("expected ErrInvalidReply, got %v", err)
+	}
+
+	thread, err := store.GetThread("bob", "alice", root)
+	if err != nil {
+		t.Fatalf("failed to fetch thread: %v", err)
+	}
+	if len(thread) != 3 {
+		t.Fatalf("expected 3 messages in thread, got %d", len(thread))
+	}
+	if thread[1].ReplyTo != root || thread[2].ReplyTo != reply {
+		t.Errorf("unexpected reply links: %d, %d", thread[1].ReplyTo, thread[2].ReplyTo)
+	}
+
+	// A thread cannot be read from outside its conversation
+	thread, _ = store.GetThread("alice", "carol", root)
+	if len(thread) != 0 {
+		t.Errorf("expected no messages for foreign thread, got %d", len(thread))
+	}
+}
+
+func TestStore_MarkMessageDelivered(t *testing.T) {
+	dbPath := "test_delivered.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	first, _ := store.CreateMessage(1, "alice", "bob", "queued while bob was away")
+	second, _ := store.CreateMessage(1, "alice", "bob", "pushed live")
+	if err := store.MarkMessageDelivered(second); err != nil {
+		t.Fatalf("failed to mark message delivered: %v", err)
+	}
+
+	// Only the pushed message counts as delivered
+	for id, want := range map[int64]bool{first: false, second: true} {
+		var delivered bool
+		if err := store.db.QueryRow(`SELECT delivered_at IS NOT NULL FROM messages WHERE id = ?`, id).Scan(&delivered); err != nil {
+			t.Fatalf("failed to read message %d: %v", id, err)
+		}
+		if delivered != want {
+			t.Errorf("message %d: expected delivered %v, got %v", id, want, delivered)
+		}
+	}
+}
+
+func TestStore_CreateReplyWithAttachments(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "test_reply_attachments.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	a, _ := store.CreateAttachment("alice", 3, 0)
+	store.CompleteAttachment(a.ID, "digest")
+	other, _ := store.CreateMessage(1, "alice", "carol", "elsewhere")
+
+	// A message that can't be stored leaves no links behind
+	if _, err := store.CreateReplyWithAttachments(1, "alice", "bob", "cross-post", other, []string{a.ID}); err != ErrInvalidReply {
+		t.Fatalf("expected ErrInvalidReply, got %v", err)
+	}
+	if ok, _ := store.CanAccessAttachment(a.ID, "bob"); ok {
+		t.Error("expected bob not to see an attachment from an unstored message")
+	}
+
+	id, err := store.CreateReplyWithAttachments(1, "alice", "bob", "with attachment", 0, []string{a.ID})
+	if err != nil || id == 0 {
+		t.Fatalf("failed to create message: %v", err)
+	}
+	if ok, err := store.CanAccessAttachment(a.ID, "bob"); err != nil || !ok {
+		t.Errorf("expected bob to see the attachment, got %v %v", ok, err)
+	}
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// ErrInvalidRefreshToken is returned for unknown, expired, revoked or reused refresh tokens.
+var ErrInvalidRefreshToken = kindError(ErrInvalid, "invalid refresh token")
+
+// NewTokenID returns a random identifier for sessions and access tokens.
+func NewTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the hex SHA-256 of a bearer secret; only hashes are stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateRefreshToken issues a refresh token for username in sessionID,
+// valid for ttl. Expired tokens of the user are dropped along the way.
+func (s *Store) CreateRefreshToken(username, sessionID string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	if _, err := s.db.Exec(`DELETE FROM refresh_tokens WHERE username = ? AND expires_at < CURRENT_TIMESTAMP`, username); err != nil {
+		return "", err
+	}
+	stmt := `INSERT INTO refresh_tokens (token_hash, username, session_id, expires_at) VALUES (?, ?, ?, datetime('now', ?))`
+	if _, err := s.db.Exec(stmt, hashToken(token), username, sessionID, sqliteFuture(ttl)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// session and returns the session's username and ID. Presenting a token that
+// was already rotated means it leaked, so the whole session is revoked.
+func (s *Store) RotateRefreshToken(token string, ttl time.Duration) (username, sessionID, next string, err error) {
+	var expired, used, revoked bool
+	row := s.db.QueryRow(`
+		SELECT username, session_id, expires_at < CURRENT_TIMESTAMP, used_at IS NOT NULL, revoked_at IS NOT NULL
+		FROM refresh_tokens WHERE token_hash = ?`, hashToken(token))
+	err = row.Scan(&username, &sessionID, &expired, &used, &revoked)
+	if err == sql.ErrNoRows {
+		return "", "", "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	if used && !revoked {
+		if err := s.RevokeSession(sessionID); err != nil {
+			return "", "", "", err
+		}
+		return "", "", "", ErrInvalidRefreshToken
+	}
+	if expired || revoked {
+		return "", "", "", ErrInvalidRefreshToken
+	}
+
+	// Only one concurrent rotation of the same token may win
+	result, err := s.db.Exec(`UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE token_hash = ? AND used_at IS NULL`, hashToken(token))
+	if err != nil {
+		return "", "", "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", "", "", ErrInvalidRefreshToken
+	}
+	next, err = s.CreateRefreshToken(username, sessionID, ttl)
+	if err != nil {
+		return "", "", "", err
+	}
+	_, err = s.db.Exec(`UPDATE sessions SET last_used_at = CURRENT_TIMESTAMP, expires_at = datetime('now', ?) WHERE id = ?`,
+		sqliteFuture(ttl), sessionID)
+	return username, sessionID, next, err
+}
+
+// RevokeAccessToken adds an access token's jti to the denylist until it
+// expires. Entries for tokens that have expired anyway are pruned.
+func (s *Store) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	if _, err := s.db.Exec(`DELETE FROM revoked_tokens WHERE expires_at < CURRENT_TIMESTAMP`); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)`,
+		jti, expiresAt.UTC().Format("2006-01-02 15:04:05"))
+	return err
+}
+
+// IsTokenRevoked reports whether an access token was revoked, either by its
+// own jti or because its session was.
+func (s *Store) IsTokenRevoked(jti, sessionID string) (bool, error) {
+	stmt := `
+		SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ?)
+		    OR EXISTS (SELECT 1 FROM sessions WHERE id = ? AND revoked_at IS NOT NULL)`
+	var revoked bool
+	err := s.db.QueryRow(stmt, jti, sessionID).Scan(&revoked)
+	return revoked, err
+}
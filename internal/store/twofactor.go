@@ -0,0 +1,152 @@
+package store
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"strings"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+// ErrTwoFactorEnabled is returned when enrolling a user who already has 2FA on.
+var ErrTwoFactorEnabled = kindError(ErrConflict, "two-factor authentication already enabled")
+
+// recoveryEncoding renders recovery codes in an unambiguous, case-insensitive alphabet.
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GetTwoFactor returns username's TOTP enrollment, or nil if there is none.
+func (s *Store) GetTwoFactor(username string) (*models.TwoFactor, error) {
+	var tf models.TwoFactor
+	err := s.db.QueryRow(`SELECT secret, enabled, last_step FROM two_factor WHERE username = ?`, username).
+		Scan(&tf.Secret, &tf.Enabled, &tf.LastStep)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tf, nil
+}
+
+// StartTwoFactor stores a pending TOTP secret, replacing any earlier pending one.
+func (s *Store) StartTwoFactor(username, secret string) error {
+	result, err := s.db.Exec(`
+		INSERT INTO two_factor (username, secret) VALUES (?, ?)
+		ON CONFLICT(username) DO UPDATE SET secret = excluded.secret, last_step = 0, created_at = CURRENT_TIMESTAMP
+		WHERE two_factor.enabled = 0`, username, secret)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+// EnableTwoFactor turns on a pending enrollment once the user proved they
+// can generate codes, and stores hashes of their recovery codes.
+func (s *Store) EnableTwoFactor(username string, step int64, recoveryCodes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE two_factor SET enabled = 1, last_step = ? WHERE username = ?`, step, username); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, username, recoveryCodes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTwoFactor removes username's enrollment and recovery codes.
+func (s *Store) DisableTwoFactor(username string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM two_factor WHERE username = ?`, username); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE username = ?`, username); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code from step was accepted. It returns false
+// if that step (or a later one) was already used, which means a replay.
+func (s *Store) UseTOTPStep(username string, step int64) (bool, error) {
+	result, err := s.db.Exec(`UPDATE two_factor SET last_step = ? WHERE username = ? AND last_step < ?`, step, username, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// SetRecoveryCodes replaces username's recovery codes.
+func (s *Store) SetRecoveryCodes(username string, codes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := replaceRecoveryCodes(tx, username, codes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, username string, codes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE username = ?`, username); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO recovery_codes (username, code_hash) VALUES (?, ?)`,
+			username, hashToken(normalizeRecoveryCode(code))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode consumes one of username's unused recovery codes.
+func (s *Store) UseRecoveryCode(username, code string) (bool, error) {
+	result, err := s.db.Exec(`UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE username = ? AND code_hash = ? AND used_at IS NULL`,
+		username, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// RemainingRecoveryCodes counts username's unused recovery codes.
+func (s *Store) RemainingRecoveryCodes(username string) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE username = ? AND used_at IS NULL`, username).Scan(&n)
+	return n, err
+}
+
+// NewRecoveryCodes returns n random codes formatted like "abcde-fghij".
+func NewRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode ignores case, dashes and spaces users may type.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
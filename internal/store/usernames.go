@@ -0,0 +1,247 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+// ErrUsernameConfusable is returned when a new username looks like an existing one.
+var ErrUsernameConfusable = kindError(ErrConflict, "username too similar to an existing one")
+
+// usernameColumns lists every column that holds a username, for renames.
+var usernameColumns = []struct{ table, column string }{
+	{"messages", "username"},
+	{"messages", "recipient"},
+	{"pending_events", "recipient"},
+	{"attachments", "owner"},
+	{"conversations", "owner"},
+	{"conversations", "peer"},
+	{"privacy_settings", "username"},
+	{"contacts", "owner"},
+	{"contacts", "contact"},
+	{"blocks", "blocker"},
+	{"blocks", "blocked"},
+	{"reactions", "reactor"},
+	{"refresh_tokens", "username"},
+	{"sessions", "username"},
+	{"two_factor", "username"},
+	{"recovery_codes", "username"},
+	{"password_resets", "username"},
+}
+
+// skeletonVersion identifies the rules models.UsernameSkeleton follows.
+// Stored skeletons made under other rules are recomputed at startup.
+const skeletonVersion = 2
+
+// collisionSkeleton is stored for accounts the migration couldn't
+// normalize, so they aren't taken up again on the next start.
+func collisionSkeleton(username string) string {
+	return "collision:" + username
+}
+
+// migrateUsernames brings accounts created before usernames were normalized
+// in line: names are rewritten to their canonical form everywhere they are
+// stored, and each account gets a confusable skeleton. Accounts whose names
+// clash with an earlier account are left untouched, get a placeholder
+// skeleton and are recorded in username_collisions for an operator to
+// resolve. When the skeleton rules change, every skeleton is recomputed.
+func (s *Store) migrateUsernames() error {
+	if err := s.resetOutdatedSkeletons(); err != nil {
+		return err
+	}
+	var pending bool
+	if err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE username_skeleton IS NULL)`).Scan(&pending); err != nil || !pending {
+		return err
+	}
+
+	type account struct {
+		username string
+		skeleton sql.NullString
+	}
+	rows, err := s.db.Query(`SELECT username, username_skeleton FROM users ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	var accounts []account
+	for rows.Next() {
+		var a account
+		if err := rows.Scan(&a.username, &a.skeleton); err != nil {
+			rows.Close()
+			return err
+		}
+		accounts = append(accounts, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Names differing only in case or Unicode form can't both be canonical
+	first := map[string]string{}
+	count := map[string]int{}
+	for _, a := range accounts {
+		canonical := models.CanonicalUsername(a.username)
+		if count[canonical] == 0 {
+			first[canonical] = a.username
+		}
+		count[canonical]++
+	}
+	taken := map[string]string{}
+	for _, a := range accounts {
+		if a.skeleton.Valid {
+			taken[a.skeleton.String] = a.username
+		}
+	}
+	for _, a := range accounts {
+		if a.skeleton.Valid {
+			continue
+		}
+		canonical := models.CanonicalUsername(a.username)
+		if owner := first[canonical]; owner != a.username {
+			if err := s.recordUsernameCollision(a.username, owner, "case"); err != nil {
+				return err
+			}
+			continue
+		}
+		if count[canonical] > 1 {
+			// Keep the earliest name as it is; renaming it would clash
+			canonical = a.username
+		}
+		skeleton := models.UsernameSkeleton(canonical)
+		if owner, ok := taken[skeleton]; ok {
+			if err := s.recordUsernameCollision(a.username, owner, "confusable"); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.renameUser(a.username, canonical, skeleton); err != nil {
+			return err
+		}
+		taken[skeleton] = canonical
+	}
+	return nil
+}
+
+// renameUser moves an account and everything referring to it to a new name.
+func (s *Store) renameUser(from, to, skeleton string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE users SET username = ?, username_skeleton = ? WHERE username = ?`, to, skeleton, from); err != nil {
+		return err
+	}
+	if from != to {
+		for _, c := range usernameColumns {
+			if _, err := tx.Exec("UPDATE "+c.table+" SET "+c.column+" = ? WHERE "+c.column+" = ?", to, from); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// recordUsernameCollision notes that username clashes with conflictsWith
+// and gives it a placeholder skeleton.
+func (s *Store) recordUsernameCollision(username, conflictsWith, reason string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`INSERT OR IGNORE INTO username_collisions (username, conflicts_with, reason) VALUES (?, ?, ?)`,
+		username, conflictsWith, reason)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE users SET username_skeleton = ? WHERE username = ?`, collisionSkeleton(username), username); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// resetOutdatedSkeletons clears skeletons made under older rules, along
+// with the collisions found under them, so migrateUsernames recomputes
+// them. Retired names get their skeletons recomputed in place.
+func (s *Store) resetOutdatedSkeletons() error {
+	var version int
+	err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM username_skeleton_version`).Scan(&version)
+	if err != nil || version == skeletonVersion {
+		return err
+	}
+	rows, err := s.db.Query(`SELECT username, retired_at FROM retired_usernames`)
+	if err != nil {
+		return err
+	}
+	type retiredName struct {
+		username  string
+		retiredAt time.Time
+	}
+	var retired []retiredName
+	for rows.Next() {
+		var r retiredName
+		if err := rows.Scan(&r.username, &r.retiredAt); err != nil {
+			rows.Close()
+			return err
+		}
+		retired = append(retired, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmts := []string{
+		`UPDATE users SET username_skeleton = NULL`,
+		`DELETE FROM username_collisions`,
+		`DELETE FROM retired_usernames`,
+		`DELETE FROM username_skeleton_version`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	for _, r := range retired {
+		stmt := `INSERT OR IGNORE INTO retired_usernames (skeleton, username, retired_at) VALUES (?, ?, ?)`
+		if _, err := tx.Exec(stmt, models.UsernameSkeleton(r.username), r.username, r.retiredAt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO username_skeleton_version (version) VALUES (?)`, skeletonVersion); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UsernameCollisions lists accounts the username migration couldn't normalize.
+func (s *Store) UsernameCollisions() ([]models.UsernameCollision, error) {
+	rows, err := s.db.Query(`SELECT username, conflicts_with, reason, detected_at FROM username_collisions ORDER BY detected_at, username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	collisions := []models.UsernameCollision{}
+	for rows.Next() {
+		var c models.UsernameCollision
+		if err := rows.Scan(&c.Username, &c.ConflictsWith, &c.Reason, &c.DetectedAt); err != nil {
+			return nil, err
+		}
+		collisions = append(collisions, c)
+	}
+	return collisions, rows.Err()
+}
+
+// isSkeletonConflict checks if an error is a violation of the unique username skeleton.
+func isSkeletonConflict(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "users.username_skeleton")
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/edpsouza/chatterbox/internal/models"
+)
+
+func TestStore_MigrateUsernames(t *testing.T) {
+	dbPath := "test_usernames.db"
+	defer os.Remove(dbPath)
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	// Accounts created before normalization, without skeletons
+	for _, name := range []string{"Alice", "alice", "Bob", "bоb", "carol"} {
+		if _, err := store.db.Exec(`INSERT INTO users (username, password, public_key) VALUES (?, 'x', 'k')`, name); err != nil {
+			t.Fatalf("insert %s: %v", name, err)
+		}
+	}
+	store.CreateMessage(3, "Bob", "carol", "hi")
+	store.db.Exec(`INSERT INTO contacts (owner, contact, status) VALUES ('carol', 'Bob', 'accepted')`)
+	store.Close()
+
+	// Reopening runs the migration
+	store, err = NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	if user, _ := store.GetUserByUsername("BOB"); user == nil || user.Username != "bob" {
+		t.Errorf("expected Bob to be renamed to bob, got %+v", user)
+	}
+	if messages, _ := store.GetMessagesBetween("bob", "carol"); len(messages) != 1 {
+		t.Errorf("expected Bob's messages to follow the rename, got %d", len(messages))
+	}
+	var contact string
+	store.db.QueryRow(`SELECT contact FROM contacts WHERE owner = 'carol'`).Scan(&contact)
+	if contact != "bob" {
+		t.Errorf("expected contact to follow the rename, got %q", contact)
+	}
+
+	// Colliding accounts are left alone and reported
+	if user, _ := store.GetUserByUsername("Alice"); user == nil || user.Username != "Alice" {
+		t.Errorf("expected exact match for Alice, got %+v", user)
+	}
+	if user, _ := store.GetUserByUsername("alice"); user == nil || user.Username != "alice" {
+		t.Errorf("expected exact match for alice, got %+v", user)
+	}
+	collisions, err := store.UsernameCollisions()
+	if err != nil {
+		t.Fatalf("UsernameCollisions failed: %v", err)
+	}
+	want := map[string]string{"alice": "case", "bоb": "confusable"}
+	if len(collisions) != len(want) {
+		t.Fatalf("expected %d collisions, got %+v", len(want), collisions)
+	}
+	for _, c := range collisions {
+		if want[c.Username] != c.Reason {
+			t.Errorf("unexpected collision %+v", c)
+		}
+	}
+
+	// The migration doesn't run again for the colliding accounts
+	var pending bool
+	store.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE username_skeleton IS NULL)`).Scan(&pending)
+	if pending {
+		t.Error("expected every account to have a skeleton after migrating")
+	}
+
+	// New accounts can't look like existing ones
+	err = store.CreateUser(&models.User{Username: "аlice", Password: "x", PublicKey: "k"})
+	if err != ErrUsernameConfusable {
+		t.Errorf("expected ErrUsernameConfusable, got %v", err)
+	}
+	if err := store.CreateUser(&models.User{Username: "dave", Password: "x", PublicKey: "k"}); err != nil {
+		t.Errorf("CreateUser failed: %v", err)
+	}
+}
+
+func TestStore_RecomputeOutdatedSkeletons(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test_skeletons.db")
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.CreateUser(&models.User{Username: "clara", Password: "x", PublicKey: "k"})
+	store.CreateUser(&models.User{Username: "emil", Password: "x", PublicKey: "k"})
+	store.DeleteAccount("emil")
+	// Skeletons made under older rules, which folded "cl" into "d" and "i" into "l"
+	store.db.Exec(`UPDATE users SET username_skeleton = 'dara' WHERE username = 'clara'`)
+	store.db.Exec(`UPDATE retired_usernames SET skeleton = 'emll' WHERE username = 'emil'`)
+	store.db.Exec(`DELETE FROM username_skeleton_version`)
+	store.Close()
+
+	store, err = NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	for _, name := range []string{"dara", "emll"} {
+		if err := store.CreateUser(&models.User{Username: name, Password: "x", PublicKey: "k"}); err != nil {
+			t.Errorf("expected %s to be available, got %v", name, err)
+		}
+	}
+	if err := store.CreateUser(&models.User{Username: "cl.ara", Password: "x", PublicKey: "k"}); err != ErrUsernameConfusable {
+		t.Errorf("expected cl.ara to look like clara, got %v", err)
+	}
+	if err := store.CreateUser(&models.User{Username: "emil", Password: "x", PublicKey: "k"}); err != ErrUsernameTaken {
+		t.Errorf("expected emil to stay retired, got %v", err)
+	}
+}
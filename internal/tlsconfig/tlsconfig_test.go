@@ -0,0 +1,215 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a fresh self-signed certificate for commonName to
+// certFile and keyFile.
+func writeCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func commonName(t *testing.T, r *Reloader) string {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(r.Certificate().Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestReloader_ReloadKeepsCertificateOnError(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "one.example")
+
+	r, err := NewReloader(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+	if name := commonName(t, r); name != "one.example" {
+		t.Fatalf("expected one.example, got %s", name)
+	}
+
+	writeCert(t, certFile, keyFile, "two.example")
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if name := commonName(t, r); name != "two.example" {
+		t.Errorf("expected two.example after reload, got %s", name)
+	}
+
+	// A broken key leaves the current certificate in place
+	os.WriteFile(keyFile, []byte("garbage"), 0o600)
+	if err := r.Reload(); err == nil {
+		t.Error("expected reload of a broken key to fail")
+	}
+	if name := commonName(t, r); name != "two.example" {
+		t.Errorf("expected two.example to stay after a failed reload, got %s", name)
+	}
+
+	if _, err := NewReloader(certFile, keyFile, ""); err == nil {
+		t.Error("expected NewReloader to fail on a broken key")
+	}
+	if _, err := NewReloader(certFile, keyFile, filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("expected NewReloader to fail on a missing client CA file")
+	}
+}
+
+func TestReloader_WatchPicksUpChanges(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "one.example")
+	r, err := NewReloader(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	writeCert(t, certFile, keyFile, "two.example")
+	// Make sure the modification time moves even on coarse filesystems
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for commonName(t, r) != "two.example" {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate change was not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloader_ServesCurrentCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "one.example")
+	caFile := filepath.Join(dir, "ca.pem")
+	writeCert(t, caFile, filepath.Join(dir, "ca-key.pem"), "ca.example")
+	r, err := NewReloader(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Proto))
+	}))
+	server.EnableHTTP2 = true
+	server.TLS = r.Config(tls.VersionTLS12)
+	server.StartTLS()
+	defer server.Close()
+
+	handshake := func(serverName string) *x509.Certificate {
+		t.Helper()
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h2"},
+		})
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		defer conn.Close()
+		state := conn.ConnectionState()
+		if state.NegotiatedProtocol != "h2" {
+			t.Errorf("expected HTTP/2 to be negotiated, got %q", state.NegotiatedProtocol)
+		}
+		return state.PeerCertificates[0]
+	}
+
+	if cert := handshake("one.example"); cert.Subject.CommonName != "one.example" {
+		t.Errorf("expected one.example, got %s", cert.Subject.CommonName)
+	}
+	writeCert(t, certFile, keyFile, "two.example")
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if cert := handshake("two.example"); cert.Subject.CommonName != "two.example" {
+		t.Errorf("expected new connections to get two.example, got %s", cert.Subject.CommonName)
+	}
+
+	// TLS 1.1 clients are refused
+	_, err = tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS11,
+	})
+	if err == nil {
+		t.Error("expected a TLS 1.1 handshake to fail")
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for in, want := range map[string]uint16{"": tls.VersionTLS12, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13} {
+		if got, err := ParseVersion(in); err != nil || got != want {
+			t.Errorf("ParseVersion(%q) = %v, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"1.0", "1.1", "tls13"} {
+		if _, err := ParseVersion(in); err == nil {
+			t.Errorf("expected ParseVersion(%q) to fail", in)
+		}
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	cases := []struct {
+		port, target, want string
+	}{
+		{"443", "http://chat.example:80/v1/users?q=al", "https://chat.example/v1/users?q=al"},
+		{"8443", "http://chat.example/v1/ws", "https://chat.example:8443/v1/ws"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		RedirectHandler(c.port).ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.target, nil))
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != c.want {
+			t.Errorf("redirect of %s: got %d %q, want %q", c.target, w.Code, w.Header().Get("Location"), c.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B vectors for SHA-1, truncated to 6 digits.
+func TestCode_RFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range vectors {
+		code, err := Code(secret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code failed: %v", err)
+		}
+		if code != v.code {
+			t.Errorf("at %d: expected %s, got %s", v.unix, v.code, code)
+		}
+	}
+}
+
+func TestValidate_Skew(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatalf("NewSecret failed: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	previous, _ := Code(secret, Step(now)-1)
+	if step, ok := Validate(secret, previous, now, 1); !ok || step != Step(now)-1 {
+		t.Errorf("expected previous step to validate with skew 1")
+	}
+	if _, ok := Validate(secret, previous, now, 0); ok {
+		t.Error("expected previous step to fail without skew")
+	}
+	if _, ok := Validate(secret, "12345", now, 1); ok {
+		t.Error("expected short code to fail")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("Chatterbox", "alice", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/Chatterbox:alice?") || !strings.Contains(uri, "secret=ABC") {
+		t.Errorf("unexpected URI: %s", uri)
+	}
+}